@@ -2,13 +2,15 @@ provider "netbox" {
   request_timeout = "4m"
 }
 
-
+resource "netbox_tag" "acc" {
+  name = "BasePathTest-acc"
+}
 
 resource "netbox_available_prefixes" "gke-pods" {
   parent_prefix = "240.0.0.0/4"
   //parent_prefix_id = 2
   prefix_length = 7
-  tags          = ["BasePathTest-acc"]
+  tags          = [netbox_tag.acc.name]
   /*
     custom_fields   {
       helpers      = "sdfdf"
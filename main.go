@@ -3,13 +3,29 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"sort"
 
 	"github.com/fenglyu/terraform-provider-netbox/netbox"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "orphans" {
+		if err := orphans(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := generateImports(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var debugMode bool
 
 	flag.BoolVar(&debugMode, "debuggable", false, "set to true to run the provider with support for debuggers like delve")
@@ -28,3 +44,122 @@ func main() {
 			ProviderFunc: netbox.Provider})
 	}
 }
+
+// orphans lists the prefixes created within a workspace which aren't in its state, and optionally deletes them.
+// The netbox host and token are taken from the same environment variables as the provider.
+func orphans(args []string) error {
+	flags := flag.NewFlagSet("orphans", flag.ExitOnError)
+	statePath := flags.String("state", "terraform.tfstate", "the state file of the workspace, e.g. from terraform state pull")
+	workspace := flags.String("workspace", "", "the workspace set in the provider the prefixes were created with, required")
+	remove := flags.Bool("delete", false, "delete the orphaned prefixes, except the reserved ones")
+	flags.Parse(args)
+
+	// Only the prefixes created within a workspace are stamped
+	if *workspace == "" {
+		return fmt.Errorf("-workspace is required, it's the workspace set in the provider")
+	}
+
+	f, err := os.Open(*statePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tracked, err := netbox.ReadStatePrefixIDs(f)
+	if err != nil {
+		return err
+	}
+
+	config, err := envConfig()
+	if err != nil {
+		return err
+	}
+
+	orphaned, err := netbox.FindOrphanedPrefixes(config, *workspace, tracked)
+	if err != nil {
+		return err
+	}
+	for _, p := range orphaned {
+		fmt.Printf("%d\t%s\t%s\t%s\n", p.ID, p.Prefix, p.Status, p.Description)
+		if *remove && !p.Deletable() {
+			// Reserved by an allocation which may still be in progress
+			fmt.Fprintf(os.Stderr, "Not deleting the reserved prefix %d\n", p.ID)
+			continue
+		}
+		if *remove {
+			if err := netbox.DeletePrefix(config, p.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// generateImports writes the import blocks and the resources of the prefixes matching the filters of the
+// netbox_prefixes data source, to bring prefixes created by hand under terraform.
+func generateImports(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	outPath := flags.String("out", "", "the file to write the configuration to, instead of stdout")
+	filterNames := make([]string, 0)
+	prefixFilters := netbox.PrefixFilters()
+	for name := range prefixFilters {
+		filterNames = append(filterNames, name)
+	}
+	sort.Strings(filterNames)
+	filterValues := make(map[string]*string, len(filterNames))
+	for _, name := range filterNames {
+		filterValues[name] = flags.String(name, "", prefixFilters[name])
+	}
+	// The flags which aren't filters are rejected by the flag set, so are the arguments left over
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return fmt.Errorf("Unexpected argument %s, the filters are given as flags, e.g. -tag gke", flags.Arg(0))
+	}
+
+	filters := make(map[string]string)
+	for name, value := range filterValues {
+		if *value != "" {
+			filters[name] = *value
+		}
+	}
+
+	config, err := envConfig()
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *outPath != "" {
+		if out, err = os.Create(*outPath); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	written, err := netbox.WritePrefixImports(config, filters, out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d prefixes\n", written)
+	return nil
+}
+
+// envConfig connects to netbox with the host and token taken from the same environment variables as the provider
+func envConfig() (*netbox.Config, error) {
+	config := &netbox.Config{
+		ApiToken: firstEnv("NETBOX_TOKEN", "NETBOX_API_TOKEN", "API_TOKEN"),
+		Host:     firstEnv("NETBOX_HOST"),
+		BasePath: firstEnv("NETBOX_BASE_PATH"),
+	}
+	if err := config.LoadAndValidate(context.Background()); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
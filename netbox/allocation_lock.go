@@ -0,0 +1,495 @@
+package netbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fenglyu/go-netbox/netbox/client/extras"
+	"github.com/fenglyu/go-netbox/netbox/models"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const (
+	lockBackendMemory    = "memory"
+	lockBackendFile      = "file"
+	lockBackendNetboxTag = "netbox_tag"
+
+	lockTagColor = "9e9e9e"
+)
+
+var (
+	lockBackends = []string{
+		lockBackendMemory, lockBackendFile, lockBackendNetboxTag,
+	}
+
+	defaultLockTTL     = 2 * time.Minute
+	defaultLockTimeout = 10 * time.Minute
+
+	// How often a held lock is polled for
+	lockRetryInterval = time.Second
+)
+
+// allocationLease is what a lock backend stores about the holder of a lock
+type allocationLease struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+func (l *allocationLease) expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+// lockBackend stores one lease per key outside of the provider process,
+// so allocations are serialized across terraform runs.
+type lockBackend interface {
+	// tryAcquire takes the lock of key if it is free or its lease expired,
+	// otherwise it reports the current holder, if any.
+	tryAcquire(ctx context.Context, key string, lease allocationLease) (bool, *allocationLease, error)
+	// renew extends the lease of a lock held by lease.Owner
+	renew(ctx context.Context, key string, lease allocationLease) error
+	// release frees a lock held by lease.Owner
+	release(ctx context.Context, key string, lease allocationLease) error
+}
+
+// allocationLocker takes a lease on a lock key and renews it until it's unlocked,
+// a run that crashed holds the lock no longer than the TTL.
+type allocationLocker struct {
+	config  *Config
+	backend lockBackend
+	owner   string
+	ttl     time.Duration
+	timeout time.Duration
+}
+
+func newAllocationLocker(c *Config) (*allocationLocker, error) {
+	var backend lockBackend
+	switch c.LockBackend {
+	case "", lockBackendMemory:
+		return nil, nil
+	case lockBackendFile:
+		if c.LockDir == "" {
+			return nil, fmt.Errorf("lock_dir is required by the %s lock backend", lockBackendFile)
+		}
+		if err := os.MkdirAll(c.LockDir, 0755); err != nil {
+			return nil, fmt.Errorf("Cannot create the lock directory %s: %v", c.LockDir, err)
+		}
+		backend = &fileLockBackend{dir: c.LockDir}
+	case lockBackendNetboxTag:
+		backend = &tagLockBackend{config: c, ids: make(map[string]int64)}
+	default:
+		return nil, fmt.Errorf("Unknown lock backend %q, expected one of %v", c.LockBackend, lockBackends)
+	}
+
+	owner, err := newLockOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	locker := &allocationLocker{
+		config:  c,
+		backend: backend,
+		owner:   owner,
+		ttl:     c.LockTTL,
+		timeout: c.LockTimeout,
+	}
+	if locker.ttl <= 0 {
+		locker.ttl = defaultLockTTL
+	}
+	if locker.timeout <= 0 {
+		locker.timeout = defaultLockTimeout
+	}
+	return locker, nil
+}
+
+// newLockOwner identifies this provider process as the holder of a lock
+func newLockOwner() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), hex.EncodeToString(b)), nil
+}
+
+// Lock waits until the lock of key is taken, up to the lock timeout. The returned
+// function releases the lock. A nil locker, the memory backend, only relies on mutexKV.
+func (l *allocationLocker) Lock(ctx context.Context, key string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	ctx = newLogSubsystem(ctx, l.config, logSubsystemLock)
+	// The timeout only bounds the wait, the lease is renewed and released past it
+	acquireCtx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	for {
+		lease := allocationLease{Owner: l.owner, Expires: time.Now().Add(l.ttl)}
+		tflog.SubsystemDebug(acquireCtx, logSubsystemLock, "Acquiring lock", map[string]interface{}{
+			"lock":  key,
+			"owner": l.owner,
+		})
+		ok, held, err := l.backend.tryAcquire(acquireCtx, key, lease)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot acquire lock %s: %w", key, err)
+		}
+		if ok {
+			tflog.SubsystemDebug(acquireCtx, logSubsystemLock, "Acquired lock", map[string]interface{}{
+				"lock":    key,
+				"expires": lease.Expires.Format(time.RFC3339),
+			})
+			return l.keepAlive(ctx, key), nil
+		}
+
+		select {
+		case <-acquireCtx.Done():
+			if held != nil {
+				return nil, fmt.Errorf("Timed out after %s waiting for lock %s held by %s until %s", l.timeout, key, held.Owner, held.Expires.Format(time.RFC3339))
+			}
+			return nil, fmt.Errorf("Timed out after %s waiting for lock %s", l.timeout, key)
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// keepAlive renews the lease of key until the returned function releases it
+func (l *allocationLocker) keepAlive(ctx context.Context, key string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				lease := allocationLease{Owner: l.owner, Expires: time.Now().Add(l.ttl)}
+				if err := l.backend.renew(ctx, key, lease); err != nil {
+					tflog.SubsystemWarn(ctx, logSubsystemLock, "Cannot renew lock", map[string]interface{}{
+						"lock":  key,
+						"error": err.Error(),
+					})
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		tflog.SubsystemDebug(ctx, logSubsystemLock, "Releasing lock", map[string]interface{}{
+			"lock": key,
+		})
+		if err := l.backend.release(ctx, key, allocationLease{Owner: l.owner}); err != nil {
+			tflog.SubsystemWarn(ctx, logSubsystemLock, "Cannot release lock, it expires on its own", map[string]interface{}{
+				"lock":  key,
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
+// fileLockBackend keeps a lock file per key in a directory, which may be on a shared filesystem
+type fileLockBackend struct {
+	dir string
+}
+
+func (b *fileLockBackend) path(key string) string {
+	return filepath.Join(b.dir, key+".lock")
+}
+
+func (b *fileLockBackend) tryAcquire(ctx context.Context, key string, lease allocationLease) (bool, *allocationLease, error) {
+	path := b.path(key)
+	ok, err := b.link(path, lease)
+	if ok || err != nil {
+		return ok, nil, err
+	}
+
+	held, err := readLeaseFile(path)
+	if os.IsNotExist(err) {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	if !held.expired(time.Now()) {
+		return false, held, nil
+	}
+
+	// Move the expired lock out of the way before removing it, so a lock
+	// which has just been taken by another run isn't removed by mistake.
+	tflog.SubsystemWarn(ctx, logSubsystemLock, "Breaking expired lock", map[string]interface{}{
+		"lock":    key,
+		"owner":   held.Owner,
+		"expired": held.Expires.Format(time.RFC3339),
+	})
+	stale := fmt.Sprintf("%s.%s.stale", path, hex.EncodeToString([]byte(lease.Owner)))
+	if err := os.Rename(path, stale); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil, nil
+		}
+		return false, nil, err
+	}
+	moved, err := readLeaseFile(stale)
+	if err == nil && (moved.Owner != held.Owner || !moved.Expires.Equal(held.Expires)) {
+		// Put back the lock of the run which won the race
+		_ = os.Link(stale, path)
+		_ = os.Remove(stale)
+		return false, moved, nil
+	}
+	_ = os.Remove(stale)
+
+	ok, err = b.link(path, lease)
+	return ok, nil, err
+}
+
+// link writes the lease to a temporary file and hard links it as the lock file,
+// which fails when the lock file exists, and never exposes a partly written lock.
+func (b *fileLockBackend) link(path string, lease allocationLease) (bool, error) {
+	tmp, err := writeLeaseTempFile(b.dir, lease)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Link(tmp, path); err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *fileLockBackend) renew(ctx context.Context, key string, lease allocationLease) error {
+	path := b.path(key)
+	held, err := readLeaseFile(path)
+	if err != nil {
+		return err
+	}
+	if held.Owner != lease.Owner {
+		return fmt.Errorf("lock is held by %s", held.Owner)
+	}
+
+	tmp, err := writeLeaseTempFile(b.dir, lease)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (b *fileLockBackend) release(ctx context.Context, key string, lease allocationLease) error {
+	path := b.path(key)
+	held, err := readLeaseFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if held.Owner != lease.Owner {
+		return fmt.Errorf("lock is held by %s", held.Owner)
+	}
+	return os.Remove(path)
+}
+
+func readLeaseFile(path string) (*allocationLease, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lease := &allocationLease{}
+	if err := json.Unmarshal(data, lease); err != nil {
+		// A lock file which can't be read never blocks for longer than an expired lease
+		return &allocationLease{Owner: "unknown"}, nil
+	}
+	return lease, nil
+}
+
+func writeLeaseTempFile(dir string, lease allocationLease) (string, error) {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(dir, ".lease-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// tagLockBackend keeps a lock as a netbox tag whose slug is the lock key, and the lease in its description.
+// Tag slugs are unique, so creating the tag either takes the lock or fails. Netbox 2.8 has no journal
+// entries, and updating the tags or custom fields of the parent prefix itself can't be done atomically.
+type tagLockBackend struct {
+	config *Config
+
+	lock sync.Mutex
+	ids  map[string]int64
+}
+
+func (b *tagLockBackend) tryAcquire(ctx context.Context, key string, lease allocationLease) (bool, *allocationLease, error) {
+	ok, err := b.create(ctx, key, lease)
+	if ok || err != nil {
+		return ok, nil, err
+	}
+
+	tag, err := b.find(key)
+	if err != nil || tag == nil {
+		return false, nil, err
+	}
+	held := &allocationLease{Owner: "unknown"}
+	_ = json.Unmarshal([]byte(tag.Description), held)
+	if !held.expired(time.Now()) {
+		return false, held, nil
+	}
+
+	// The tag is deleted by its ID, so a lock which has just been taken by another run is left alone
+	tflog.SubsystemWarn(ctx, logSubsystemLock, "Breaking expired lock", map[string]interface{}{
+		"lock":    key,
+		"owner":   held.Owner,
+		"expired": held.Expires.Format(time.RFC3339),
+	})
+	params := extras.ExtrasTagsDeleteParams{
+		ID:      tag.ID,
+		Context: context.Background(),
+	}
+	if _, err := b.config.extras.ExtrasTagsDelete(&params, nil); err != nil {
+		tflog.SubsystemDebug(ctx, logSubsystemLock, "Cannot delete the expired lock tag", map[string]interface{}{
+			"id":    tag.ID,
+			"error": err.Error(),
+		})
+	}
+
+	ok, err = b.create(ctx, key, lease)
+	return ok, nil, err
+}
+
+func (b *tagLockBackend) create(ctx context.Context, key string, lease allocationLease) (bool, error) {
+	description, err := json.Marshal(lease)
+	if err != nil {
+		return false, err
+	}
+	params := extras.ExtrasTagsCreateParams{
+		Data: &models.Tag{
+			Name:        &key,
+			Slug:        &key,
+			Color:       lockTagColor,
+			Description: string(description),
+		},
+		Context: context.Background(),
+	}
+	res, err := b.config.extras.ExtrasTagsCreate(&params, nil)
+	if err != nil {
+		// Tell a taken slug apart from a failed request
+		tflog.SubsystemDebug(ctx, logSubsystemLock, "Cannot create the lock tag", map[string]interface{}{
+			"lock":  key,
+			"error": err.Error(),
+		})
+		tag, ferr := b.find(key)
+		if ferr != nil {
+			return false, ferr
+		}
+		if tag == nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.ids[key] = res.GetPayload().ID
+	return true, nil
+}
+
+func (b *tagLockBackend) find(key string) (*models.Tag, error) {
+	params := extras.ExtrasTagsListParams{
+		Slug:    &key,
+		Context: context.Background(),
+	}
+	res, err := b.config.extras.ExtrasTagsList(&params, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil || res.Payload == nil || len(res.Payload.Results) == 0 {
+		return nil, nil
+	}
+	return res.Payload.Results[0], nil
+}
+
+func (b *tagLockBackend) id(key string) (int64, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	id, ok := b.ids[key]
+	return id, ok
+}
+
+func (b *tagLockBackend) renew(ctx context.Context, key string, lease allocationLease) error {
+	id, ok := b.id(key)
+	if !ok {
+		return fmt.Errorf("lock is not held")
+	}
+	description, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	params := extras.ExtrasTagsPartialUpdateParams{
+		ID: id,
+		Data: &models.Tag{
+			Name:        &key,
+			Slug:        &key,
+			Color:       lockTagColor,
+			Description: string(description),
+		},
+		Context: context.Background(),
+	}
+	_, err = b.config.extras.ExtrasTagsPartialUpdate(&params, nil)
+	return err
+}
+
+func (b *tagLockBackend) release(ctx context.Context, key string, lease allocationLease) error {
+	id, ok := b.id(key)
+	if !ok {
+		return nil
+	}
+	params := extras.ExtrasTagsDeleteParams{
+		ID:      id,
+		Context: context.Background(),
+	}
+	// A lock tag already deleted was broken by another run once it expired
+	if _, err := b.config.extras.ExtrasTagsDelete(&params, nil); err != nil && !isNotFound(err) {
+		return err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.ids, key)
+	return nil
+}
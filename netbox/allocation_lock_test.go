@@ -0,0 +1,255 @@
+package netbox
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-log/tflogtest"
+)
+
+func testFileLocker(dir, owner string, ttl, timeout time.Duration) *allocationLocker {
+	return &allocationLocker{
+		backend: &fileLockBackend{dir: dir},
+		owner:   owner,
+		ttl:     ttl,
+		timeout: timeout,
+	}
+}
+
+func TestFileLockExcludesOtherOwners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netbox-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(interval time.Duration) { lockRetryInterval = interval }(lockRetryInterval)
+	lockRetryInterval = 10 * time.Millisecond
+
+	first := testFileLocker(dir, "first", time.Minute, time.Second)
+	second := testFileLocker(dir, "second", time.Minute, 100*time.Millisecond)
+
+	unlock, err := first.Lock(context.Background(), "availableprefixes_1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := second.Lock(context.Background(), "availableprefixes_1"); err == nil || !strings.Contains(err.Error(), "held by first") {
+		t.Fatalf("expected the lock to be held by first, got %v", err)
+	}
+
+	// Other keys are not affected
+	unlockOther, err := second.Lock(context.Background(), "availableprefixes_2")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	unlockOther()
+
+	unlock()
+	unlock, err = second.Lock(context.Background(), "availableprefixes_1")
+	if err != nil {
+		t.Fatalf("expected the released lock to be taken, got %v", err)
+	}
+	unlock()
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("expected no files left in the lock directory, got %d", len(files))
+	}
+}
+
+func TestFileLockExpires(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netbox-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(interval time.Duration) { lockRetryInterval = interval }(lockRetryInterval)
+	lockRetryInterval = 10 * time.Millisecond
+
+	// A crashed run never releases nor renews its lock
+	backend := &fileLockBackend{dir: dir}
+	crashed := allocationLease{Owner: "crashed", Expires: time.Now().Add(200 * time.Millisecond)}
+	if ok, _, err := backend.tryAcquire(context.Background(), "availableprefixes_1", crashed); !ok || err != nil {
+		t.Fatalf("expected the lock to be taken, got %t %v", ok, err)
+	}
+
+	locker := testFileLocker(dir, "second", time.Minute, 5*time.Second)
+	var output bytes.Buffer
+	start := time.Now()
+	unlock, err := locker.Lock(tflogtest.RootLogger(context.Background(), &output), "availableprefixes_1")
+	if err != nil {
+		t.Fatalf("expected the expired lock to be broken, got %v", err)
+	}
+	defer unlock()
+	if waited := time.Since(start); waited < 150*time.Millisecond {
+		t.Fatalf("expected to wait for the lease to expire, waited %s", waited)
+	}
+
+	held, err := readLeaseFile(backend.path("availableprefixes_1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held.Owner != "second" {
+		t.Fatalf("expected the lock to be held by second, got %s", held.Owner)
+	}
+
+	entries, err := tflogtest.MultilineJSONDecode(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var broken bool
+	for _, entry := range entries {
+		if entry["@module"] != "provider."+logSubsystemLock {
+			t.Errorf("expected every lock entry in the lock subsystem, got %v", entry)
+		}
+		if entry["@message"] == "Breaking expired lock" {
+			broken = entry["@module"] == "provider."+logSubsystemLock && entry["@level"] == "warn" && entry["owner"] == "crashed"
+		}
+	}
+	if !broken {
+		t.Fatalf("expected a warning about the broken lock in the lock subsystem, got %v", entries)
+	}
+}
+
+func TestFileLockRenewsLease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netbox-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(interval time.Duration) { lockRetryInterval = interval }(lockRetryInterval)
+	lockRetryInterval = 10 * time.Millisecond
+
+	first := testFileLocker(dir, "first", 150*time.Millisecond, time.Second)
+	second := testFileLocker(dir, "second", 150*time.Millisecond, 500*time.Millisecond)
+
+	unlock, err := first.Lock(context.Background(), "availableprefixes_1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer unlock()
+
+	// The lease is renewed for longer than its TTL as long as it's held
+	if _, err := second.Lock(context.Background(), "availableprefixes_1"); err == nil {
+		t.Fatalf("expected the renewed lock to be held by first")
+	}
+}
+
+func TestNewAllocationLocker(t *testing.T) {
+	if locker, err := newAllocationLocker(&Config{}); locker != nil || err != nil {
+		t.Fatalf("expected no locker for the memory backend, got %v %v", locker, err)
+	}
+	if _, err := newAllocationLocker(&Config{LockBackend: lockBackendFile}); err == nil {
+		t.Fatalf("expected the file backend to require lock_dir")
+	}
+	if _, err := newAllocationLocker(&Config{LockBackend: "consul"}); err == nil {
+		t.Fatalf("expected an unknown backend to fail")
+	}
+
+	dir, err := ioutil.TempDir("", "netbox-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	locker, err := newAllocationLocker(&Config{LockBackend: lockBackendFile, LockDir: dir})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if locker.ttl != defaultLockTTL || locker.timeout != defaultLockTimeout {
+		t.Fatalf("expected the default TTL and timeout, got %s %s", locker.ttl, locker.timeout)
+	}
+
+	// A nil locker only relies on mutexKV
+	var none *allocationLocker
+	unlock, err := none.Lock(context.Background(), "availableprefixes_1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	unlock()
+}
+
+// A lease of a few nanoseconds would tick the renewal at 0, the durations are at least a second
+func TestLockDurationsValidated(t *testing.T) {
+	cases := map[string]bool{
+		"2m":    true,
+		"1s":    true,
+		"999ms": false,
+		"5ns":   false,
+		"0s":    false,
+		"-1m":   false,
+		"two":   false,
+	}
+	for _, key := range []string{"lock_ttl", "lock_timeout"} {
+		validate := Provider().Schema[key].ValidateDiagFunc
+		for value, valid := range cases {
+			if diags := validate(value, cty.GetAttrPath(key)); diags.HasError() == valid {
+				t.Errorf("%s = %q: expected valid %t, got %v", key, value, valid, diags)
+			}
+		}
+	}
+}
+
+// ctxLockBackend fails the calls made with a context which is already done
+type ctxLockBackend struct {
+	lock     sync.Mutex
+	renewed  int
+	released bool
+}
+
+func (b *ctxLockBackend) tryAcquire(ctx context.Context, key string, lease allocationLease) (bool, *allocationLease, error) {
+	return ctx.Err() == nil, nil, ctx.Err()
+}
+
+func (b *ctxLockBackend) renew(ctx context.Context, key string, lease allocationLease) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.renewed++
+	return nil
+}
+
+func (b *ctxLockBackend) release(ctx context.Context, key string, lease allocationLease) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.released = true
+	return nil
+}
+
+func TestLockRenewsPastTheAcquireTimeout(t *testing.T) {
+	backend := &ctxLockBackend{}
+	locker := &allocationLocker{
+		backend: backend,
+		owner:   "first",
+		ttl:     30 * time.Millisecond,
+		timeout: 10 * time.Millisecond,
+	}
+
+	unlock, err := locker.Lock(context.Background(), "availableprefixes_1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// Held past the acquire timeout
+	time.Sleep(50 * time.Millisecond)
+	unlock()
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.renewed == 0 || !backend.released {
+		t.Fatalf("expected the lease renewed and released, got %d renewals, released %t", backend.renewed, backend.released)
+	}
+}
@@ -13,6 +13,11 @@ func emptyOrDefaultStringSuppress(defaultVal string) schema.SchemaDiffSuppressFu
 	}
 }
 
+// createOnlySuppress suppresses the changes of an argument which only applies when the resource is created
+func createOnlySuppress(k, old, new string, d *schema.ResourceData) bool {
+	return d.Id() != ""
+}
+
 func suppressEmptyCustomFieldsDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
 	oldi, newi := d.GetChange("custom_fields")
 
@@ -3,7 +3,9 @@ package netbox
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
@@ -13,8 +15,14 @@ import (
 	"time"
 
 	"github.com/fenglyu/go-netbox/netbox/client"
+	"github.com/fenglyu/go-netbox/netbox/client/dcim"
+	"github.com/fenglyu/go-netbox/netbox/client/extras"
+	"github.com/fenglyu/go-netbox/netbox/client/ipam"
+	"github.com/fenglyu/go-netbox/netbox/client/tenancy"
+	"github.com/go-openapi/runtime"
 	runtimeclient "github.com/go-openapi/runtime/client"
 	"github.com/go-openapi/strfmt"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 )
 
 var (
@@ -26,11 +34,6 @@ var (
 	prefixinitializeStatus = []string{
 		"container", "active", "reserved", "deprecated",
 	}
-	// Below is a work-around for netbox v2.4.7
-	prefixStatusContainer  int64 = 0
-	prefixStatusActive     int64 = 1
-	prefixStatusReserved   int64 = 2
-	prefixStatusDeprecated int64 = 3
 
 	NetboxApiGeneralQueryLimit int64 = 0
 )
@@ -42,11 +45,74 @@ type Config struct {
 	BasePath       string
 	RequestTimeout time.Duration
 
-	// new box client
-	client *client.NetBox
+	// The prefixes created are stamped with the workspace
+	Workspace string
+	// Tags which don't exist are created rather than failing the plan
+	AutoCreateTags bool
+
+	// Allocations are serialized across terraform runs by the lock backend
+	LockBackend string
+	LockDir     string
+	LockTTL     time.Duration
+	LockTimeout time.Duration
+
+	// The values of these custom fields are masked in the logs
+	SensitiveCustomFields []string
+	// Every request is stamped with a correlation ID and logged with its status and latency
+	TraceRequests bool
+
+	// The version the provider talks to NetBox with, read from NetBox
+	apiVersion netboxVersion
+
+	// netbox api, narrowed down to the operations the provider uses so unit tests can mock them
+	ipam    ipamClient
+	dcim    dcimClient
+	tenancy tenancyClient
+	extras  extrasClient
+	locker  *allocationLocker
+	// sites, VRFs, VLANs, roles and tenants looked up by name
+	lookups *lookupCache
+	// prefixes read concurrently, merged into one request
+	prefixReads *prefixReadBatcher
 	//context context.Context
 }
 
+// ipamClient is the part of the netbox IPAM api the provider uses
+type ipamClient interface {
+	IpamIPAddressesDelete(params *ipam.IpamIPAddressesDeleteParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamIPAddressesDeleteNoContent, error)
+	IpamIPAddressesList(params *ipam.IpamIPAddressesListParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamIPAddressesListOK, error)
+	IpamPrefixesAvailablePrefixesCreate(params *ipam.IpamPrefixesAvailablePrefixesCreateParams, authInfo runtime.ClientAuthInfoWriter, writer io.Writer) (*ipam.IpamPrefixesAvailablePrefixesCreateCreated, error)
+	IpamPrefixesAvailablePrefixesRead(params *ipam.IpamPrefixesAvailablePrefixesReadParams, authInfo runtime.ClientAuthInfoWriter, writer io.Writer) (*ipam.IpamPrefixesAvailablePrefixesReadOK, error)
+	IpamPrefixesCreate(params *ipam.IpamPrefixesCreateParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamPrefixesCreateCreated, error)
+	IpamPrefixesDelete(params *ipam.IpamPrefixesDeleteParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamPrefixesDeleteNoContent, error)
+	IpamPrefixesList(params *ipam.IpamPrefixesListParams, authInfo runtime.ClientAuthInfoWriter, writer io.Writer) (*ipam.IpamPrefixesListOK, error)
+	IpamPrefixesPartialUpdate(params *ipam.IpamPrefixesPartialUpdateParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamPrefixesPartialUpdateOK, error)
+	IpamPrefixesRead(params *ipam.IpamPrefixesReadParams, authInfo runtime.ClientAuthInfoWriter, writer io.Writer) (*ipam.IpamPrefixesReadOK, error)
+	IpamRolesList(params *ipam.IpamRolesListParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamRolesListOK, error)
+	IpamVlansDelete(params *ipam.IpamVlansDeleteParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamVlansDeleteNoContent, error)
+	IpamVlansList(params *ipam.IpamVlansListParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamVlansListOK, error)
+	IpamVrfsList(params *ipam.IpamVrfsListParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamVrfsListOK, error)
+}
+
+// dcimClient is the part of the netbox DCIM api the provider uses
+type dcimClient interface {
+	DcimSitesList(params *dcim.DcimSitesListParams, authInfo runtime.ClientAuthInfoWriter) (*dcim.DcimSitesListOK, error)
+}
+
+// tenancyClient is the part of the netbox tenancy api the provider uses
+type tenancyClient interface {
+	TenancyTenantsList(params *tenancy.TenancyTenantsListParams, authInfo runtime.ClientAuthInfoWriter) (*tenancy.TenancyTenantsListOK, error)
+}
+
+// extrasClient is the part of the netbox extras api the provider uses
+type extrasClient interface {
+	ExtrasTagsCreate(params *extras.ExtrasTagsCreateParams, authInfo runtime.ClientAuthInfoWriter) (*extras.ExtrasTagsCreateCreated, error)
+	ExtrasTagsDelete(params *extras.ExtrasTagsDeleteParams, authInfo runtime.ClientAuthInfoWriter) (*extras.ExtrasTagsDeleteNoContent, error)
+	ExtrasTagsList(params *extras.ExtrasTagsListParams, authInfo runtime.ClientAuthInfoWriter) (*extras.ExtrasTagsListOK, error)
+	ExtrasTagsPartialUpdate(params *extras.ExtrasTagsPartialUpdateParams, authInfo runtime.ClientAuthInfoWriter) (*extras.ExtrasTagsPartialUpdateOK, error)
+	ExtrasTagsRead(params *extras.ExtrasTagsReadParams, authInfo runtime.ClientAuthInfoWriter) (*extras.ExtrasTagsReadOK, error)
+}
+
 func (c *Config) LoadAndValidate(ctx context.Context) error {
 	if c.BasePath == "" {
 		c.BasePath = NetboxDefaultBasePath
@@ -70,21 +136,51 @@ func (c *Config) LoadAndValidate(ctx context.Context) error {
 
 	host, schemes := getHost(c.Host)
 
-	if err := ApiAccessTest(host, c.BasePath, c.ApiToken, schemes, InsecureSkipVerify); err != nil {
+	ctx = newLogSubsystem(ctx, c, logSubsystemHTTP)
+	apiVersion, err := apiAccessTest(ctx, host, c.BasePath, c.ApiToken, schemes, InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	if c.apiVersion, err = negotiateNetboxVersion(ctx, apiVersion); err != nil {
 		return err
 	}
 	httpClient, err := runtimeclient.TLSClient(runtimeclient.TLSClientOptions{InsecureSkipVerify: InsecureSkipVerify})
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	// The error responses are decoded after they are logged
+	var transport http.RoundTripper = newNetboxLoggingTransport(ctx, c, httpClient.Transport)
+	if c.TraceRequests {
+		if transport, err = newNetboxTracingTransport(ctx, c, transport); err != nil {
+			return err
+		}
+	}
+	transport = &netboxCompatTransport{version: c.apiVersion, next: transport}
+	httpClient.Transport = &netboxErrorTransport{next: &netboxQueryTransport{next: &netboxClearTransport{next: transport}}}
 	t := runtimeclient.NewWithClient(host, c.BasePath, schemes, httpClient)
 
-	log.Printf("[INFO] Instantiating http client for host %s and path %s", host, c.BasePath)
+	tflog.SubsystemInfo(ctx, logSubsystemHTTP, "Instantiating http client", map[string]interface{}{
+		"host":        host,
+		"base_path":   c.BasePath,
+		"schemes":     schemes,
+		"api_version": c.apiVersion.String(),
+	})
 	if c.ApiToken != "" {
 		t.DefaultAuthentication = runtimeclient.APIKeyAuth(AuthHeaderName, "header", fmt.Sprintf(AuthHeaderFormat, c.ApiToken))
 	}
-	//t.SetDebug(true)
-	c.client = client.New(t, strfmt.Default)
+	netboxClient := client.New(t, strfmt.Default)
+	c.ipam = netboxClient.Ipam
+	c.dcim = netboxClient.Dcim
+	c.tenancy = netboxClient.Tenancy
+	c.extras = netboxClient.Extras
+
+	c.lookups = newLookupCache()
+	c.prefixReads = newPrefixReadBatcher(c)
+
+	c.locker, err = newAllocationLocker(c)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -141,7 +237,14 @@ func selectScheme(schemes []string) string {
 	return scheme
 }
 
-func ApiAccessTest(host, path, token string, schemes []string, InsecureSkipVerify bool) error {
+func ApiAccessTest(ctx context.Context, host, path, token string, schemes []string, InsecureSkipVerify bool) error {
+	_, err := apiAccessTest(ctx, host, path, token, schemes, InsecureSkipVerify)
+	return err
+}
+
+// apiAccessTest tests the access to NetBox and returns its API version, from the API-Version header of
+// the API root, or from the status endpoint, or "" when NetBox doesn't tell it.
+func apiAccessTest(ctx context.Context, host, path, token string, schemes []string, InsecureSkipVerify bool) (string, error) {
 	//Test url example: "http://netbox.k8s.me/api/"
 	schema := selectScheme(schemes)
 	url := fmt.Sprintf("%s://%s%s", schema, host, path)
@@ -156,17 +259,56 @@ func ApiAccessTest(host, path, token string, schemes []string, InsecureSkipVerif
 	}
 	req, err := http.NewRequest(method, url, nil)
 	if err != nil {
-		log.Println(err)
+		return "", fmt.Errorf("Cannot build the request to test the access to %s: %w", url, err)
 	}
 
-	req.Header.Add("Authorization", fmt.Sprintf("Token %s", token))
+	req.Header.Add(AuthHeaderName, fmt.Sprintf(AuthHeaderFormat, token))
+	tflog.SubsystemDebug(ctx, logSubsystemHTTP, "Testing the access to NetBox", map[string]interface{}{
+		"url":                  url,
+		"insecure_skip_verify": InsecureSkipVerify,
+		"token_set":            token != "",
+	})
 	res, err := client.Do(req)
 	if err != nil {
-		return err
+		tflog.SubsystemError(ctx, logSubsystemHTTP, "NetBox is unreachable", map[string]interface{}{
+			"url":   url,
+			"error": err.Error(),
+		})
+		return "", err
 	}
 	defer res.Body.Close()
+	tflog.SubsystemDebug(ctx, logSubsystemHTTP, "Tested the access to NetBox", map[string]interface{}{
+		"url":         url,
+		"status":      res.StatusCode,
+		"api_version": res.Header.Get("API-Version"),
+	})
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf(res.Status)
+		return "", fmt.Errorf(res.Status)
 	}
-	return nil
+	if apiVersion := res.Header.Get("API-Version"); apiVersion != "" {
+		return apiVersion, nil
+	}
+
+	// The status endpoint of NetBox 2.10 and later tells the version of NetBox
+	statusURL := strings.TrimSuffix(url, "/") + "/status/"
+	if req, err = http.NewRequest(method, statusURL, nil); err != nil {
+		return "", fmt.Errorf("Cannot build the request to read the status of %s: %w", statusURL, err)
+	}
+	req.Header.Add(AuthHeaderName, fmt.Sprintf(AuthHeaderFormat, token))
+	status, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer status.Body.Close()
+	var body struct {
+		NetboxVersion string `json:"netbox-version"`
+	}
+	if status.StatusCode != http.StatusOK || json.NewDecoder(status.Body).Decode(&body) != nil {
+		return "", nil
+	}
+	tflog.SubsystemDebug(ctx, logSubsystemHTTP, "Read the status of NetBox", map[string]interface{}{
+		"url":            statusURL,
+		"netbox_version": body.NetboxVersion,
+	})
+	return body.NetboxVersion, nil
 }
@@ -29,7 +29,7 @@ func TestApiAccessTestInHttp(t *testing.T) {
 	if schemes[0] != "http" {
 		t.Skipf("scheme http not supported, only %s", schemes[0])
 	}
-	err := ApiAccessTest(host, config.BasePath, config.ApiToken, []string{"http"}, true)
+	err := ApiAccessTest(context.Background(), host, config.BasePath, config.ApiToken, []string{"http"}, true)
 	if err != nil {
 		t.Skipf("error %v", err)
 	}
@@ -46,7 +46,7 @@ func TestApiAccessTestInHttps(t *testing.T) {
 	if schemes[0] != "https" {
 		t.Skipf("scheme https not supported, only %s", schemes[0])
 	}
-	err := ApiAccessTest(host, config.BasePath, config.ApiToken, []string{"https"}, true)
+	err := ApiAccessTest(context.Background(), host, config.BasePath, config.ApiToken, []string{"https"}, true)
 	if err != nil {
 		t.Fatalf("error %v", err)
 	}
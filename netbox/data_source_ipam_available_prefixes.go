@@ -0,0 +1,139 @@
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+var availablePrefixesParentKeys = []string{
+	"parent_prefix",
+	"parent_prefix_id",
+}
+
+// dataSourceIpamAvailablePrefixes lists the free blocks under a parent prefix.
+// Without a parent it falls back to listing existing prefixes like netbox_prefixes does,
+// which is what this data source used to do.
+func dataSourceIpamAvailablePrefixes() *schema.Resource {
+	ds := dataSourceIpamPrefixes()
+	ds.Read = nil
+	ds.ReadContext = dataSourceIpamAvailablePrefixesRead
+
+	ds.Schema["parent_prefix"] = &schema.Schema{
+		Type:             schema.TypeString,
+		Optional:         true,
+		ConflictsWith:    []string{"parent_prefix_id"},
+		ValidateDiagFunc: IsCIDRNetworkDiagFunc(1, 128),
+		Description:      "List the free blocks under the parent_prefix",
+	}
+	ds.Schema["parent_prefix_id"] = &schema.Schema{
+		Type:             schema.TypeInt,
+		Optional:         true,
+		ConflictsWith:    []string{"parent_prefix"},
+		ValidateDiagFunc: IntAtLeastDiagFunc(0),
+		Description:      "A unique integer value identifying the prefix whose free blocks are listed",
+	}
+	ds.Schema["prefix_length"] = &schema.Schema{
+		Type:             schema.TypeInt,
+		Optional:         true,
+		ValidateDiagFunc: IntBetweenDiagFunc(1, 128),
+		Description:      "Only list free blocks large enough to hold a prefix of this length",
+	}
+	ds.Schema["available_prefixes"] = &schema.Schema{
+		Type:        schema.TypeList,
+		Elem:        &schema.Schema{Type: schema.TypeString},
+		Computed:    true,
+		Description: "Free blocks under the parent prefix in CIDR notation",
+	}
+	return ds
+}
+
+func dataSourceIpamAvailablePrefixesRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	ctx = newLogSubsystem(ctx, config, logSubsystemDataSourceAvailablePrefixes)
+
+	if !isAvailablePrefixesParentSet(d) {
+		diags := dataSourceIpamPrefixesRead(ctx, d, m)
+		if diags.HasError() {
+			return diags
+		}
+		return append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "Listing existing prefixes with netbox_available_prefixes is deprecated",
+			Detail:   "Use the netbox_prefixes data source to list existing prefixes, set parent_prefix or parent_prefix_id to list free blocks.",
+		})
+	}
+
+	var parentID int64
+	if v, ok := d.GetOk("parent_prefix_id"); ok {
+		parentID = int64(v.(int))
+	} else {
+		results, err := getIpamPrefixes(config, d)
+		if err != nil {
+			return netboxDiag(err)
+		}
+		parentID = results[0].ID
+	}
+
+	available, err := getIpamAvailablePrefixes(config, parentID)
+	if err != nil {
+		return netboxDiag(err)
+	}
+	tflog.SubsystemDebug(ctx, logSubsystemDataSourceAvailablePrefixes, "Listed the free blocks", map[string]interface{}{
+		"parent_prefix_id": parentID,
+		"count":            len(available),
+	})
+
+	var family string
+	if v, ok := d.GetOk("family"); ok {
+		family = v.(string)
+	}
+	prefixLength, _ := d.Get("prefix_length").(int)
+
+	blocks := make([]string, 0, len(available))
+	for _, a := range available {
+		_, ipnet, err := net.ParseCIDR(a.Prefix)
+		if err != nil {
+			return diag.Errorf("Error parsing available prefix %s: %v", a.Prefix, err)
+		}
+		ones, bits := ipnet.Mask.Size()
+		if family != "" && family != fmt.Sprintf("%d", familyOfBits(bits)) {
+			continue
+		}
+		if prefixLength != 0 && (prefixLength < ones || prefixLength > bits) {
+			continue
+		}
+		blocks = append(blocks, a.Prefix)
+	}
+
+	if err := d.Set("available_prefixes", blocks); err != nil {
+		return diag.Errorf("Error setting available prefixes: %s", err)
+	}
+	if err := d.Set("prefixes", nil); err != nil {
+		return diag.Errorf("Error setting prefixes: %s", err)
+	}
+
+	d.SetId(d.Get("name").(string))
+	return nil
+}
+
+func isAvailablePrefixesParentSet(d *schema.ResourceData) bool {
+	for _, k := range availablePrefixesParentKeys {
+		if _, ok := d.GetOk(k); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// familyOfBits returns the netbox family, 4 or 6, of an address with the given number of bits
+func familyOfBits(bits int) int {
+	if bits == 32 {
+		return 4
+	}
+	return 6
+}
@@ -0,0 +1,88 @@
+package netbox
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestAccDataSourceAvailablePrefixesFreeBlocks(t *testing.T) {
+
+	context := map[string]interface{}{
+		"parent_prefix_id":     testNetboxParentPrefixIdWithVrf,
+		"random_prefix_length": randIntRange(t, 16, 30),
+		"random_suffix":        randString(t, 10),
+	}
+	resourceName := "data.netbox_available_prefixes.free"
+
+	resource.ParallelTest(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		CheckDestroy:      testAccCheckAvailablePrefixesDestroyProducer(t),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataSourceAvailablePrefixesConfigFreeBlocks(context),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "name", "free_blocks"),
+					resource.TestCheckResourceAttr(resourceName, "prefixes.#", "0"),
+					resource.TestMatchResourceAttr(resourceName, "available_prefixes.#", regexp.MustCompile(`^[1-9]\d*$`)),
+					resource.TestMatchResourceAttr(resourceName, "available_prefixes.0", regexp.MustCompile(`^\d+\.\d+\.\d+\.\d+/\d+$`)),
+					testAccDataSourceAvailablePrefixesExcludes(resourceName, "netbox_available_prefixes.foo"),
+					resource.TestCheckResourceAttr("data.netbox_available_prefixes.ipv6", "available_prefixes.#", "0"),
+				),
+			},
+		},
+	})
+}
+
+// testAccDataSourceAvailablePrefixesExcludes checks that an allocated prefix isn't listed as a free block
+func testAccDataSourceAvailablePrefixesExcludes(datasourceName string, resourceName string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		ds, ok := s.RootModule().Resources[datasourceName]
+		if !ok {
+			return fmt.Errorf("root module has no resource called %s", datasourceName)
+		}
+		rs, ok := s.RootModule().Resources[resourceName]
+		if !ok {
+			return fmt.Errorf("can't find %s in state", resourceName)
+		}
+
+		allocated := rs.Primary.Attributes["prefix"]
+		for k, v := range ds.Primary.Attributes {
+			if regexp.MustCompile(`^available_prefixes\.\d+$`).MatchString(k) && v == allocated {
+				return fmt.Errorf("allocated prefix %s is listed as a free block", allocated)
+			}
+		}
+		return nil
+	}
+}
+
+func testAccDataSourceAvailablePrefixesConfigFreeBlocks(config map[string]interface{}) string {
+	return Nprintf(`
+resource "netbox_available_prefixes" "foo" {
+  parent_prefix_id 	= %{parent_prefix_id}
+  prefix_length 	= %{random_prefix_length}
+  status          	= "active"
+
+  description = "testAccDataSourceAvailablePrefixesConfigFreeBlocks description"
+  tags        = ["datasource-FreeBlocks-acc%{random_suffix}-01"]
+  custom_fields  {}
+}
+
+data "netbox_available_prefixes" "free" {
+  name             = "free_blocks"
+  parent_prefix_id = %{parent_prefix_id}
+  depends_on       = [netbox_available_prefixes.foo]
+}
+
+data "netbox_available_prefixes" "ipv6" {
+  name             = "free_ipv6_blocks"
+  parent_prefix_id = %{parent_prefix_id}
+  family           = "6"
+  depends_on       = [netbox_available_prefixes.foo]
+}
+`, config)
+}
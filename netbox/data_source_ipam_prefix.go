@@ -0,0 +1,159 @@
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/fenglyu/go-netbox/netbox/client/ipam"
+	"github.com/fenglyu/go-netbox/netbox/models"
+)
+
+var prefixLookupKeys = []string{
+	"id",
+	"prefix",
+}
+
+func dataSourceIpamPrefix() *schema.Resource {
+	dsSchema := datasourceSchemaFromResourceSchema(resourceIpamAvailablePrefixes().Schema)
+	// Allocation arguments only make sense for the resource
+	delete(dsSchema, "subnet_index")
+	delete(dsSchema, "allocation_strategy")
+	delete(dsSchema, "alignment")
+	delete(dsSchema, "fallback_parent_prefix_ids")
+	// prefix and vrf are computed when looking up by id
+	dsSchema["prefix"].Optional = true
+	dsSchema["vrf"].Optional = true
+	dsSchema["prefix"].ValidateDiagFunc = IsCIDRNetworkDiagFunc(1, 128)
+	dsSchema["prefix"].ExactlyOneOf = prefixLookupKeys
+
+	dsSchema["id"] = &schema.Schema{
+		Type:         schema.TypeString,
+		Optional:     true,
+		Computed:     true,
+		ExactlyOneOf: prefixLookupKeys,
+		Description:  "Search for a prefix by ID",
+	}
+	for k, v := range prefixUsageSchema() {
+		dsSchema[k] = v
+	}
+
+	return &schema.Resource{
+		ReadContext: dataSourceIpamPrefixRead,
+		Schema:      dsSchema,
+	}
+}
+
+func dataSourceIpamPrefixRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	ctx = newLogSubsystem(ctx, config, logSubsystemDataSourcePrefix)
+
+	prefix, err := lookupIpamPrefix(config, d)
+	if err != nil {
+		return netboxDiag(err)
+	}
+	tflog.SubsystemDebug(ctx, logSubsystemDataSourcePrefix, "Found prefix", map[string]interface{}{
+		"id": prefix.ID,
+	})
+
+	d.SetId(fmt.Sprintf("%d", prefix.ID))
+	d.Set("prefix", prefix.Prefix)
+	d.Set("description", prefix.Description)
+	d.Set("custom_fields", flatterCustomFields(d, prefix.CustomFields))
+	d.Set("is_pool", prefix.IsPool)
+	d.Set("created", prefix.Created.String())
+	d.Set("last_updated", prefix.LastUpdated.String())
+	d.Set("tags", flattenTags(prefix.Tags))
+	if prefix.Family != nil {
+		d.Set("family", prefix.Family.Value)
+	}
+	if prefix.Status != nil {
+		d.Set("status", prefix.Status.Value)
+	}
+	if pl, err := strconv.Atoi(strings.Split(*prefix.Prefix, "/")[1]); err == nil {
+		d.Set("prefix_length", pl)
+	}
+
+	if prefix.Site != nil {
+		d.Set("site", prefix.Site.Name)
+	}
+	if prefix.Tenant != nil {
+		d.Set("tenant", prefix.Tenant.Name)
+	}
+	if prefix.Role != nil {
+		d.Set("role", prefix.Role.Name)
+	}
+	if prefix.Vlan != nil {
+		d.Set("vlan", prefix.Vlan.Name)
+	}
+	if prefix.Vrf != nil {
+		d.Set("vrf", prefix.Vrf.Name)
+	}
+
+	parentPrefix, err := getIpamParentPrefixes(config, d, prefix)
+	if err != nil {
+		return netboxDiag(err)
+	}
+	// A top level prefix has no parent
+	if parentPrefix != nil {
+		d.Set("parent_prefix", parentPrefix.Prefix)
+		d.Set("parent_prefix_id", int(parentPrefix.ID))
+	}
+
+	usage, err := getIpamPrefixUsage(config, prefix)
+	if err != nil {
+		return netboxDiag(err)
+	}
+	for k, v := range flattenPrefixUsage(prefix, usage) {
+		d.Set(k, v)
+	}
+
+	return nil
+}
+
+// lookupIpamPrefix returns the only prefix matching either the given ID, or the given prefix within the VRF,
+// or within the global table without one
+func lookupIpamPrefix(config *Config, d *schema.ResourceData) (*models.Prefix, error) {
+	if v, ok := d.GetOk("id"); ok {
+		id, err := strconv.Atoi(v.(string))
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing prefix ID %q: %v", v.(string), err)
+		}
+		return getIpamPrefixByID(config, int64(id))
+	}
+
+	prefix := d.Get("prefix").(string)
+	param := ipam.IpamPrefixesListParams{
+		Prefix:  &prefix,
+		Limit:   &NetboxApiGeneralQueryLimit,
+		Context: context.Background(),
+	}
+
+	// The same prefix may be in a VRF too, which isn't the one asked for
+	vrfID := "null"
+	if _, ok := d.GetOk("vrf"); ok {
+		vrfs, err := getIpamVrfs(config, d)
+		if err != nil {
+			return nil, err
+		}
+		vrfID = strconv.FormatInt(vrfs[0].ID, 10)
+	}
+	param.VrfID = &vrfID
+
+	ipamPrefixListBody, err := config.ipam.IpamPrefixesList(&param, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ipamPrefixListBody == nil || ipamPrefixListBody.Payload == nil || len(ipamPrefixListBody.Payload.Results) == 0 {
+		return nil, fmt.Errorf("No prefix %s found", prefix)
+	}
+	if len(ipamPrefixListBody.Payload.Results) > 1 {
+		return nil, fmt.Errorf("%d prefixes %s found, look it up by its id", len(ipamPrefixListBody.Payload.Results), prefix)
+	}
+	return ipamPrefixListBody.Payload.Results[0], nil
+}
@@ -0,0 +1,220 @@
+package netbox
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestAccDataSourcePrefixById(t *testing.T) {
+
+	context := map[string]interface{}{
+		"parent_prefix_id":     testNetboxParentPrefixIdWithVrf,
+		"random_prefix_length": randIntRange(t, 16, 30),
+		"random_suffix":        randString(t, 10),
+	}
+	resourceName := "data.netbox_prefix.bar"
+
+	resource.ParallelTest(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		CheckDestroy:      testAccCheckAvailablePrefixesDestroyProducer(t),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataSourcePrefixConfigById(context),
+				Check: resource.ComposeTestCheckFunc(
+					testAccDataSourcePrefixCheck(resourceName, "netbox_available_prefixes.foo"),
+					resource.TestCheckResourceAttr(resourceName, "parent_prefix_id", fmt.Sprintf("%d", testNetboxParentPrefixIdWithVrf)),
+					resource.TestMatchResourceAttr(resourceName, "parent_prefix", regexp.MustCompile(`^\d+\.\d+\.\d+\.\d+/\d+$`)),
+					resource.TestCheckResourceAttr(resourceName, "utilization", "0"),
+					resource.TestCheckResourceAttr(resourceName, "child_prefix_count", "0"),
+					resource.TestCheckResourceAttr(resourceName, "used_ip_count", "0"),
+					resource.TestCheckResourceAttr(resourceName, "available_prefixes.#", "1"),
+					resource.TestCheckResourceAttrPair(resourceName, "available_prefixes.0", "netbox_available_prefixes.foo", "prefix"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccDataSourcePrefixByPrefixAndVrf(t *testing.T) {
+
+	context := map[string]interface{}{
+		"parent_prefix_id":     testNetboxParentPrefixIdWithVrf,
+		"random_prefix_length": randIntRange(t, 16, 30),
+		"random_suffix":        randString(t, 10),
+	}
+	resourceName := "data.netbox_prefix.bar"
+
+	resource.ParallelTest(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		CheckDestroy:      testAccCheckAvailablePrefixesDestroyProducer(t),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataSourcePrefixConfigByPrefixAndVrf(context),
+				Check: resource.ComposeTestCheckFunc(
+					testAccDataSourcePrefixCheck(resourceName, "netbox_available_prefixes.foo"),
+					resource.TestCheckResourceAttrPair(resourceName, "id", "netbox_available_prefixes.foo", "id"),
+					resource.TestCheckResourceAttr(resourceName, "parent_prefix_id", fmt.Sprintf("%d", testNetboxParentPrefixIdWithVrf)),
+				),
+			},
+		},
+	})
+}
+
+func TestAccDataSourcePrefixNotFound(t *testing.T) {
+	resource.ParallelTest(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      testAccDataSourcePrefixConfigNotFound(),
+				ExpectError: regexp.MustCompile("No prefix 192.0.2.0/32 found"),
+			},
+		},
+	})
+}
+
+func testAccDataSourcePrefixCheck(datasourceName string, resourceName string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		ds, ok := s.RootModule().Resources[datasourceName]
+		if !ok {
+			return fmt.Errorf("root module has no resource called %s", datasourceName)
+		}
+
+		rs, ok := s.RootModule().Resources[resourceName]
+		if !ok {
+			return fmt.Errorf("can't find %s in state", resourceName)
+		}
+
+		datasourceAttributes := ds.Primary.Attributes
+		resourceAttributes := rs.Primary.Attributes
+
+		instanceAttrsToTest := []string{
+			"prefix",
+			"prefix_length",
+			"is_pool",
+			"status",
+			"description",
+			"tags.#",
+			"created",
+			"family",
+			"last_updated",
+			"role",
+			"site",
+			"tenant",
+			"vlan",
+			"vrf",
+		}
+
+		for _, attrToCheck := range instanceAttrsToTest {
+			if datasourceAttributes[attrToCheck] != resourceAttributes[attrToCheck] {
+				return fmt.Errorf(
+					"%s is %s; want %s",
+					attrToCheck,
+					datasourceAttributes[attrToCheck],
+					resourceAttributes[attrToCheck],
+				)
+			}
+		}
+
+		return nil
+	}
+}
+
+func testAccDataSourcePrefixConfigById(config map[string]interface{}) string {
+	return Nprintf(`
+resource "netbox_available_prefixes" "foo" {
+  parent_prefix_id 	= %{parent_prefix_id}
+  prefix_length 	= %{random_prefix_length}
+  is_pool          	= true
+  status          	= "active"
+  role = "gcp"
+  site = "se1"
+  vlan = "gcp"
+  vrf  = "activision"
+  tenant = "cloud"
+
+  description = "testAccDataSourcePrefixConfigById description"
+  tags        = ["datasource-Prefix-acc%{random_suffix}-01", "datasource-Prefix-acc%{random_suffix}-02"]
+  custom_fields  {}
+}
+
+data "netbox_prefix" "bar" {
+  id = netbox_available_prefixes.foo.id
+}
+`, config)
+}
+
+func testAccDataSourcePrefixConfigByPrefixAndVrf(config map[string]interface{}) string {
+	return Nprintf(`
+resource "netbox_available_prefixes" "foo" {
+  parent_prefix_id 	= %{parent_prefix_id}
+  prefix_length 	= %{random_prefix_length}
+  is_pool          	= true
+  status          	= "active"
+  role = "gcp"
+  site = "se1"
+  vlan = "gcp"
+  vrf  = "activision"
+  tenant = "cloud"
+
+  description = "testAccDataSourcePrefixConfigByPrefixAndVrf description"
+  tags        = ["datasource-Prefix-acc%{random_suffix}-01"]
+  custom_fields  {}
+}
+
+data "netbox_prefix" "bar" {
+  prefix = netbox_available_prefixes.foo.prefix
+  vrf    = netbox_available_prefixes.foo.vrf
+}
+`, config)
+}
+
+func testAccDataSourcePrefixConfigNotFound() string {
+	return `
+data "netbox_prefix" "bar" {
+  prefix = "192.0.2.0/32"
+}
+`
+}
+
+// A prefix both in a VRF and in the global table is looked up in the global table without a vrf
+func TestLookupIpamPrefixGlobalTable(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	f.lock.Lock()
+	vrf := f.vrfs[0]
+	inVrf := f.seedPrefix("10.1.0.0/24", vrf, "active")
+	global := f.seedPrefix("10.1.0.0/24", nil, "active")
+	f.lock.Unlock()
+
+	cases := map[string]struct {
+		raw map[string]interface{}
+		id  int64
+	}{
+		"global table": {raw: map[string]interface{}{"prefix": "10.1.0.0/24"}, id: global.id},
+		"vrf":          {raw: map[string]interface{}{"prefix": "10.1.0.0/24", "vrf": vrf.name}, id: inVrf.id},
+	}
+	for tn, tc := range cases {
+		d := schema.TestResourceDataRaw(t, dataSourceIpamPrefix().Schema, tc.raw)
+		prefix, err := lookupIpamPrefix(config, d)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tn, err)
+			continue
+		}
+		if prefix.ID != tc.id {
+			t.Errorf("%s: expected the prefix with ID %d, got %d", tn, tc.id, prefix.ID)
+		}
+	}
+}
@@ -6,14 +6,21 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 
 	"github.com/fenglyu/go-netbox/netbox/client/ipam"
 )
 
-func dataSourceIpamAvailablePrefixes() *schema.Resource {
+func dataSourceIpamPrefixes() *schema.Resource {
 
 	prefixSchema := datasourceSchemaFromResourceSchema(resourceIpamAvailablePrefixes().Schema)
+	// Allocation arguments only make sense for the resource
+	delete(prefixSchema, "subnet_index")
+	delete(prefixSchema, "allocation_strategy")
+	delete(prefixSchema, "alignment")
+	delete(prefixSchema, "fallback_parent_prefix_ids")
 	// Add prefix id to prefix output
 
 	prefixSchema["id"] = &schema.Schema{
@@ -22,11 +29,14 @@ func dataSourceIpamAvailablePrefixes() *schema.Resource {
 		Optional:    true,
 		Description: "An identifier for the prefix",
 	}
+	for k, v := range prefixUsageSchema() {
+		prefixSchema[k] = v
+	}
 
 	// This is a schema Element that will allow us to read and place all returned prefixes into the
 	// `prefixes` attribute.
 	return &schema.Resource{
-		Read: dataSourceIpamAvailablePrefixesRead,
+		ReadContext: dataSourceIpamPrefixesRead,
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:        schema.TypeString,
@@ -68,6 +78,12 @@ func dataSourceIpamAvailablePrefixes() *schema.Resource {
 				Optional:    true,
 				Description: "Limit the number of returned results",
 			},
+			"include_usage": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Compute the usage of every prefix returned, which costs 3 requests per prefix",
+			},
 			"offset": {
 				Type:        schema.TypeInt,
 				Optional:    true,
@@ -165,11 +181,93 @@ func dataSourceIpamAvailablePrefixes() *schema.Resource {
 	}
 }
 
-func dataSourceIpamAvailablePrefixesRead(d *schema.ResourceData, m interface{}) error {
+func dataSourceIpamPrefixesRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	config := m.(*Config)
+	ctx = newLogSubsystem(ctx, config, logSubsystemDataSourcePrefixes)
+
+	param, err := expandIpamPrefixesListParams(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	param.WithContext(context.Background())
+	ipamPrefixListBody, err := config.ipam.IpamPrefixesList(param, nil, nil)
+	if err != nil {
+		return netboxDiag(err)
+	}
+	tflog.SubsystemDebug(ctx, logSubsystemDataSourcePrefixes, "Listed prefixes", map[string]interface{}{
+		"count": len(ipamPrefixListBody.Payload.Results),
+	})
+
+	// Container to store results
+	prefixes := make([]map[string]interface{}, 0)
 
-	// construct a prefix query
-	param := ipam.IpamPrefixesListParams{}
+	prefixIdList := make([]string, 0)
+	for _, prefix := range ipamPrefixListBody.Payload.Results {
+		data := map[string]interface{}{}
+		data["description"] = prefix.Description
+		data["custom_fields"] = flatternDatasourceCF(d, prefix.CustomFields)
+		data["is_pool"] = prefix.IsPool
+		data["created"] = prefix.Created.String()
+		data["family"] = prefix.Family.Value
+		data["last_updated"] = prefix.LastUpdated.String()
+		data["prefix"] = prefix.Prefix
+		data["status"] = *prefix.Status.Value
+		data["tags"] = flattenTags(prefix.Tags)
+		data["id"] = prefix.ID
+
+		if prefix.Site != nil {
+			data["site"] = prefix.Site.Name
+		}
+		if prefix.Tenant != nil {
+			data["tenant"] = prefix.Tenant.Name
+		}
+		if prefix.Role != nil {
+			data["role"] = prefix.Role.Name
+		}
+		if prefix.Vlan != nil {
+			data["vlan"] = prefix.Vlan.Name
+		}
+		if prefix.Vrf != nil {
+			data["vrf"] = prefix.Vrf.Name
+		}
+
+		pl, err := strconv.Atoi(strings.Split(*prefix.Prefix, "/")[1])
+		if err != nil {
+			return diag.Errorf("Error parsing *prefix.Prefix parameter %v", err)
+		}
+		data["prefix_length"] = pl
+
+		if d.Get("include_usage").(bool) {
+			usage, err := getIpamPrefixUsage(config, prefix)
+			if err != nil {
+				return netboxDiag(err)
+			}
+			for k, v := range flattenPrefixUsage(prefix, usage) {
+				data[k] = v
+			}
+		}
+
+		prefixes = append(prefixes, data)
+		prefixIdList = append(prefixIdList, fmt.Sprintf("%d", prefix.ID))
+	}
+
+	if err := d.Set("prefixes", prefixes); err != nil {
+		return diag.Errorf("Error retrieving prefixes: %s", err)
+	}
+
+	if v, ok := d.GetOk("id"); ok {
+		id := v.(string)
+		d.SetId(id)
+	} else {
+		d.SetId(d.Get("name").(string))
+	}
+
+	return nil
+}
+
+// expandIpamPrefixesListParams builds the prefix query of the filters of the data source
+func expandIpamPrefixesListParams(d *schema.ResourceData) (*ipam.IpamPrefixesListParams, error) {
+	param := &ipam.IpamPrefixesListParams{}
 
 	if v, ok := d.GetOk("contains"); ok {
 		contains := v.(string)
@@ -177,7 +275,10 @@ func dataSourceIpamAvailablePrefixesRead(d *schema.ResourceData, m interface{})
 	}
 
 	if v, ok := d.GetOk("family"); ok {
-		family := v.(float64)
+		family, err := strconv.ParseFloat(v.(string), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing family parameter %v", err)
+		}
 		param.SetFamily(&family)
 	}
 
@@ -197,7 +298,7 @@ func dataSourceIpamAvailablePrefixesRead(d *schema.ResourceData, m interface{})
 	}
 
 	if v, ok := d.GetOk("limit"); ok {
-		limit := v.(int64)
+		limit := int64(v.(int))
 		param.SetLimit(&limit)
 	}
 
@@ -207,7 +308,7 @@ func dataSourceIpamAvailablePrefixesRead(d *schema.ResourceData, m interface{})
 	}
 
 	if v, ok := d.GetOk("offset"); ok {
-		offset := v.(int64)
+		offset := int64(v.(int))
 		param.SetOffset(&offset)
 	}
 
@@ -216,7 +317,7 @@ func dataSourceIpamAvailablePrefixesRead(d *schema.ResourceData, m interface{})
 
 		prefixLength, err := strconv.Atoi(strings.Split(prefix, "/")[1])
 		if err != nil {
-			return fmt.Errorf("Error parsing prefix parameter %v", err)
+			return nil, fmt.Errorf("Error parsing prefix parameter %v", err)
 		}
 
 		maskLength := float64(prefixLength)
@@ -276,7 +377,10 @@ func dataSourceIpamAvailablePrefixesRead(d *schema.ResourceData, m interface{})
 	}
 
 	if v, ok := d.GetOk("vlan_vid"); ok {
-		vlanVID := v.(float64)
+		vlanVID, err := strconv.ParseFloat(v.(string), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing vlan_vid parameter %v", err)
+		}
 		param.SetVlanVid(&vlanVID)
 	}
 
@@ -300,65 +404,5 @@ func dataSourceIpamAvailablePrefixesRead(d *schema.ResourceData, m interface{})
 		param.SetWithinInclude(&withinInclude)
 	}
 
-	param.WithContext(context.Background())
-	ipamPrefixListBody, err := config.client.Ipam.IpamPrefixesList(&param, nil)
-	if err != nil {
-		return err
-	}
-
-	// Container to store results
-	prefixes := make([]map[string]interface{}, 0)
-
-	prefixIdList := make([]string, 0)
-	for _, prefix := range ipamPrefixListBody.Payload.Results {
-		data := map[string]interface{}{}
-		data["description"] = prefix.Description
-		data["custom_fields"] = flatternDatasourceCF(d, prefix.CustomFields)
-		data["is_pool"] = prefix.IsPool
-		data["created"] = prefix.Created.String()
-		data["family"] = prefix.Family.Value
-		data["last_updated"] = prefix.LastUpdated.String()
-		data["prefix"] = prefix.Prefix
-		data["status"] = *prefix.Status.Value
-		data["tags"] = prefix.Tags
-		data["id"] = prefix.ID
-
-		if prefix.Site != nil {
-			data["site"] = prefix.Site.Name
-		}
-		if prefix.Tenant != nil {
-			data["tenant"] = prefix.Tenant.Name
-		}
-		if prefix.Role != nil {
-			data["role"] = prefix.Role.Name
-		}
-		if prefix.Vlan != nil {
-			data["vlan"] = prefix.Vlan.Name
-		}
-		if prefix.Vrf != nil {
-			data["vrf"] = prefix.Vrf.Name
-		}
-
-		pl, err := strconv.Atoi(strings.Split(*prefix.Prefix, "/")[1])
-		if err != nil {
-			return fmt.Errorf("Error parsing *prefix.Prefix parameter %v", err)
-		}
-		data["prefix_length"] = pl
-
-		prefixes = append(prefixes, data)
-		prefixIdList = append(prefixIdList, fmt.Sprintf("%d", prefix.ID))
-	}
-
-	if err := d.Set("prefixes", prefixes); err != nil {
-		return fmt.Errorf("Error retrieving prefixes: %s", err)
-	}
-
-	if v, ok := d.GetOk("id"); ok {
-		id := v.(string)
-		d.SetId(id)
-	} else {
-		d.SetId(d.Get("name").(string))
-	}
-
-	return nil
+	return param, nil
 }
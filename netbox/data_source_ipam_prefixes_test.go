@@ -15,6 +15,7 @@ func TestAccDataSourceAvailablePrefixesByPrefix(t *testing.T) {
 	context := map[string]interface{}{
 		"parent_prefix_id":     testNetboxParentPrefixIdWithVrf,
 		"random_prefix_length": randIntRange(t, 16, 30),
+		"random_suffix":        randString(t, 10),
 	}
 	resourceName := "data.netbox_available_prefixes.bar"
 
@@ -43,6 +44,10 @@ func TestAccDataSourceAvailablePrefixesByPrefix(t *testing.T) {
 					resource.TestCheckResourceAttr(resourceName, "prefixes.0.vrf", "activision"),
 					resource.TestCheckResourceAttr(resourceName, "prefixes.0.tenant", "cloud"),
 					resource.TestCheckResourceAttr(resourceName, "prefixes.0.tags.#", "3"),
+					resource.TestCheckResourceAttr(resourceName, "prefixes.0.utilization", "0"),
+					resource.TestCheckResourceAttr(resourceName, "prefixes.0.child_prefix_count", "0"),
+					resource.TestCheckResourceAttr(resourceName, "prefixes.0.used_ip_count", "0"),
+					resource.TestCheckResourceAttr(resourceName, "prefixes.0.available_prefixes.#", "1"),
 				),
 			},
 		},
@@ -54,6 +59,7 @@ func TestAccDataSourceAvailablePrefixesByPrefixId(t *testing.T) {
 	context := map[string]interface{}{
 		"parent_prefix_id":     testNetboxParentPrefixIdWithVrf,
 		"random_prefix_length": randIntRange(t, 16, 30),
+		"random_suffix":        randString(t, 10),
 	}
 	resourceName := "data.netbox_available_prefixes.bar"
 	resource.ParallelTest(t, resource.TestCase{
@@ -87,6 +93,32 @@ func TestAccDataSourceAvailablePrefixesByPrefixId(t *testing.T) {
 	})
 }
 
+func TestAccDataSourcePrefixesByPrefix(t *testing.T) {
+
+	context := map[string]interface{}{
+		"parent_prefix_id":     testNetboxParentPrefixIdWithVrf,
+		"random_prefix_length": randIntRange(t, 16, 30),
+		"random_suffix":        randString(t, 10),
+	}
+	resourceName := "data.netbox_prefixes.bar"
+
+	resource.ParallelTest(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		CheckDestroy:      testAccCheckDataSourceAvailablePrefixesDestroyProducer(t),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataSourcePrefixesConfigByPrefix(context),
+				Check: resource.ComposeTestCheckFunc(
+					testAccDataSourceAvailablePrefixesCheck(resourceName, "netbox_available_prefixes.foo"),
+					resource.TestCheckResourceAttr(resourceName, "name", "prefix_lookup"),
+					resource.TestCheckResourceAttr(resourceName, "prefixes.#", "1"),
+				),
+			},
+		},
+	})
+}
+
 /**/
 // Those two tests require terraform 0.13.0 to properly work, Skip them here
 func TestAccDataSourceAvailablePrefixesByTag(t *testing.T) {
@@ -249,12 +281,38 @@ resource "netbox_available_prefixes" "foo" {
 
   custom_fields  {}
   description = "testAccDataSourceComputeInstanceConfig description"
-  tags        = ["datasource-AvailablePrefix-acc01", "datasource-AvailablePrefix-acc02", "datasource-AvailablePrefix-acc03"]
+  tags        = ["datasource-AvailablePrefix-acc%{random_suffix}-01", "datasource-AvailablePrefix-acc%{random_suffix}-02", "datasource-AvailablePrefix-acc%{random_suffix}-03"]
 }
 
 data "netbox_available_prefixes" "bar"{
   name = "prefix_lookup"
   prefix = netbox_available_prefixes.foo.prefix
+  include_usage = true
+}
+`, config)
+}
+
+func testAccDataSourcePrefixesConfigByPrefix(config map[string]interface{}) string {
+	return Nprintf(`
+resource "netbox_available_prefixes" "foo" {
+  parent_prefix_id 	= %{parent_prefix_id}
+  prefix_length 	= %{random_prefix_length}
+  is_pool          	= true
+  status          	= "active"
+  role = "gcp"
+  site = "se1"
+  vlan = "gcp"
+  vrf  = "activision"
+  tenant = "cloud"
+
+  custom_fields  {}
+  description = "testAccDataSourcePrefixesConfigByPrefix description"
+  tags        = ["datasource-Prefixes-acc%{random_suffix}-01", "datasource-Prefixes-acc%{random_suffix}-02"]
+}
+
+data "netbox_prefixes" "bar"{
+  name = "prefix_lookup"
+  prefix = netbox_available_prefixes.foo.prefix
 }
 `, config)
 }
@@ -273,7 +331,7 @@ resource "netbox_available_prefixes" "foo" {
   tenant = "cloud"
 
   description = "testAccDataSourceComputeInstanceConfig description"
-  tags        = ["datasource-AvailablePrefix-acc06", "datasource-AvailablePrefix-acc04", "datasource-AvailablePrefix-acc05"]
+  tags        = ["datasource-AvailablePrefix-acc%{random_suffix}-06", "datasource-AvailablePrefix-acc%{random_suffix}-04", "datasource-AvailablePrefix-acc%{random_suffix}-05"]
   custom_fields  {}
 }
 
@@ -298,7 +356,7 @@ resource "netbox_available_prefixes" "foo" {
   tenant = "cloud"
 
   description = "testAccDataSourceAvailablePrefixesConfigByParameters ==> foo"
-  tags        = ["datasource-%{random_suffix}-accTag01", "datasource-AvailablePrefix-accTag02", "datasource-AvailablePrefix-accTag03"]
+  tags        = ["datasource-AvailablePrefix-acc%{random_suffix}-01", "datasource-AvailablePrefix-acc%{random_suffix}-02", "datasource-AvailablePrefix-acc%{random_suffix}-03"]
   custom_fields  {}
 }
 
@@ -314,7 +372,7 @@ resource "netbox_available_prefixes" "bar" {
   tenant = "cloud"
 
   description = "testAccDataSourceAvailablePrefixesConfigByParameters ==> bar"
-  tags        = ["datasource-%{random_suffix}-accTag01", "datasource-AvailablePrefix-accTag04", "datasource-AvailablePrefix-accTag05"]
+  tags        = ["datasource-AvailablePrefix-acc%{random_suffix}-01", "datasource-AvailablePrefix-acc%{random_suffix}-04", "datasource-AvailablePrefix-acc%{random_suffix}-05"]
   custom_fields  {}
 }
 
@@ -330,13 +388,13 @@ resource "netbox_available_prefixes" "neo" {
   tenant = "cloud"
 
   description = "testAccDataSourceAvailablePrefixesConfigByParameters ==> neo"
-  tags        = ["datasource-%{random_suffix}-accTag06", "datasource-AvailablePrefix-accTag07", "datasource-AvailablePrefix-accTag08"]
+  tags        = ["datasource-AvailablePrefix-acc%{random_suffix}-06", "datasource-AvailablePrefix-acc%{random_suffix}-07", "datasource-AvailablePrefix-acc%{random_suffix}-08"]
   custom_fields  {}
 }
 
 data "netbox_available_prefixes" "tag"{
   name = "prefix_lookup_by_tag"
-  tag = lower("datasource-%{random_suffix}-accTag01")
+  tag = lower("datasource-AvailablePrefix-acc%{random_suffix}-01")
   depends_on  = [netbox_available_prefixes.bar, netbox_available_prefixes.foo, netbox_available_prefixes.neo]
 }
 
@@ -0,0 +1,156 @@
+package netbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/fenglyu/go-netbox/netbox/client/extras"
+	"github.com/fenglyu/go-netbox/netbox/models"
+)
+
+const lookupTag = "tag"
+
+var errTagNotFound = errors.New("tag not found")
+
+// getExtrasTag looks a tag up by name, or by slug
+func getExtrasTag(config *Config, tag string) (*models.Tag, error) {
+	found, err := config.lookups.get(lookupTag, tag, func() (interface{}, error) {
+		for _, byName := range []bool{true, false} {
+			value := tag
+			params := extras.ExtrasTagsListParams{
+				Limit:   &NetboxApiGeneralQueryLimit,
+				Context: context.Background(),
+			}
+			if byName {
+				params.Name = &value
+			} else {
+				params.Slug = &value
+			}
+			res, err := config.extras.ExtrasTagsList(&params, nil)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot look up the tag %s: %w", tag, err)
+			}
+			if res != nil && res.Payload != nil && len(res.Payload.Results) > 0 {
+				return res.Payload.Results[0], nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", errTagNotFound, tag)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found.(*models.Tag), nil
+}
+
+// resolveTags turns the tags given by name or slug into the names NetBox assigns the tags by.
+// The tags which don't exist are created with auto_create_tags, or fail. NetBox 2.8 takes any
+// string as a tag, the tags are passed through.
+func resolveTags(config *Config, tags []string) ([]string, error) {
+	if !config.apiVersion.atLeast(netboxNestedTagsVersion) {
+		return tags, nil
+	}
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		found, err := getExtrasTag(config, tag)
+		if errors.Is(err, errTagNotFound) && config.AutoCreateTags {
+			found, err = createExtrasTag(config, tag)
+		}
+		if errors.Is(err, errTagNotFound) {
+			return nil, fmt.Errorf("Tag %s doesn't exist in NetBox, create it with a netbox_tag resource the prefix refers to, e.g. netbox_tag.<name>.slug, or set auto_create_tags", tag)
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, *found.Name)
+	}
+	return names, nil
+}
+
+// createExtrasTag creates a tag named after an unknown tag of a prefix
+func createExtrasTag(config *Config, name string) (*models.Tag, error) {
+	slug := tagSlug(name)
+	params := extras.ExtrasTagsCreateParams{
+		Data: &models.Tag{
+			Name:  &name,
+			Slug:  &slug,
+			Color: defaultTagColor,
+		},
+		Context: context.Background(),
+	}
+	res, err := config.extras.ExtrasTagsCreate(&params, nil)
+	config.lookups.invalidate(lookupTag)
+	if err != nil {
+		// Created by a concurrent run in between
+		if found, ferr := getExtrasTag(config, name); ferr == nil {
+			return found, nil
+		}
+		return nil, fmt.Errorf("Cannot create the tag %s: %w", name, err)
+	}
+	return res.GetPayload(), nil
+}
+
+// tagSlug derives the slug of a tag from its name, the way NetBox does
+func tagSlug(name string) string {
+	slug := strings.Trim(slugInvalidChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(slug) > 100 {
+		slug = slug[:100]
+	}
+	return slug
+}
+
+// preferConfiguredTags keeps the tags of the config given by slug, rather than the names NetBox returns
+func preferConfiguredTags(config *Config, names []string, configured []string) []string {
+	assigned := make(map[string]bool, len(names))
+	for _, name := range names {
+		assigned[name] = true
+	}
+	bySlug := make(map[string]string)
+	for _, tag := range configured {
+		if assigned[tag] {
+			continue
+		}
+		if found, err := getExtrasTag(config, tag); err == nil && assigned[*found.Name] {
+			bySlug[*found.Name] = tag
+		}
+	}
+	tags := make([]string, 0, len(names))
+	for _, name := range names {
+		if slug, ok := bySlug[name]; ok {
+			name = slug
+		}
+		tags = append(tags, name)
+	}
+	return tags
+}
+
+// validateTags fails the plan of a prefix with tags which don't exist, unless they're created on apply.
+// The slug of a netbox_tag resource is unknown until it's created or renamed, the prefixes referring
+// to it aren't validated until then.
+func validateTags(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	config, ok := meta.(*Config)
+	if !ok || config == nil || config.extras == nil || config.AutoCreateTags || !d.NewValueKnown("tags") {
+		return nil
+	}
+	if !config.apiVersion.atLeast(netboxNestedTagsVersion) {
+		return nil
+	}
+	var missing []string
+	for _, tag := range convertStringSet(d.Get("tags").(*schema.Set)) {
+		_, err := getExtrasTag(config, tag)
+		if errors.Is(err, errTagNotFound) {
+			missing = append(missing, tag)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Tags %s don't exist in NetBox, create them with netbox_tag resources the prefix refers to, e.g. netbox_tag.<name>.slug, or set auto_create_tags", strings.Join(missing, ", "))
+	}
+	return nil
+}
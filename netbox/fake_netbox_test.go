@@ -0,0 +1,1438 @@
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+
+	"github.com/fenglyu/go-netbox/netbox/models"
+)
+
+// Set NETBOX_FAKE_SERVER to run the acceptance tests against an in-process fake NetBox,
+// which needs no NetBox, nor docker.
+var fakeNetboxEnvVars = []string{
+	"NETBOX_FAKE_SERVER",
+}
+
+var testAccFakeNetbox *fakeNetbox
+
+const (
+	fakeNetboxToken    = "0123456789abcdef0123456789abcdef01234567"
+	fakeNetboxPageSize = 50
+	fakeNetboxMaxPage  = 1000
+)
+
+var (
+	fakeNetboxPrefixStatuses = map[string]int64{
+		"container": 0, "active": 1, "reserved": 2, "deprecated": 3,
+	}
+	fakeNetboxCustomFields = []string{
+		"helpers", "ipv4_acl_in", "ipv4_acl_out",
+	}
+	fakeNetboxSlugInvalidChars = regexp.MustCompile(`[^-a-z0-9_]+`)
+)
+
+// fakeNetbox is an in-memory NetBox 2.8 API which implements the endpoints the provider uses,
+// with the same allocation semantics, pagination and error bodies as NetBox.
+type fakeNetbox struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	nextID   int64
+	requests map[string]int
+
+	// The API-Version header, the fake speaks the nested tags and the formats of the newer versions too
+	apiVersion     string
+	hideAPIVersion bool
+
+	prefixes    map[int64]*fakePrefix
+	ipAddresses map[int64]*fakeIPAddress
+	tags        map[int64]*models.Tag
+	sites       []*fakeObject
+	vrfs        []*fakeObject
+	vlans       []*fakeObject
+	roles       []*fakeObject
+	tenants     []*fakeObject
+
+	// The seeded parent prefixes the acceptance tests allocate under
+	parentPrefixID        int64
+	parentPrefixWithVrfID int64
+}
+
+type fakePrefix struct {
+	id           int64
+	network      *net.IPNet
+	vrf          *fakeObject
+	site         *fakeObject
+	tenant       *fakeObject
+	vlan         *fakeObject
+	role         *fakeObject
+	status       string
+	isPool       bool
+	description  string
+	tags         []string
+	customFields map[string]interface{}
+	created      time.Time
+	lastUpdated  time.Time
+}
+
+type fakeIPAddress struct {
+	id          int64
+	address     *net.IPNet
+	vrf         *fakeObject
+	description string
+	tags        []string
+}
+
+// fakeObject is a site, vrf, vlan, role or tenant
+type fakeObject struct {
+	id     int64
+	name   string
+	slug   string
+	vid    int64
+	rd     string
+	tenant *fakeObject
+}
+
+// newFakeNetbox starts a fake NetBox seeded with the objects the acceptance tests refer to
+func newFakeNetbox() *fakeNetbox {
+	f := &fakeNetbox{
+		apiVersion:  "2.8",
+		requests:    make(map[string]int),
+		prefixes:    make(map[int64]*fakePrefix),
+		ipAddresses: make(map[int64]*fakeIPAddress),
+		tags:        make(map[int64]*models.Tag),
+	}
+
+	tenant := f.newObject(&f.tenants, "cloud")
+	site := f.newObject(&f.sites, "se1")
+	site.tenant = tenant
+	vrf := f.newObject(&f.vrfs, "activision")
+	vrf.rd = "65000:1"
+	vlan := f.newObject(&f.vlans, "gcp")
+	vlan.vid = 100
+	f.newObject(&f.roles, "gcp")
+
+	f.parentPrefixID = f.seedPrefix("10.0.0.0/12", nil, "container").id
+	f.parentPrefixWithVrfID = f.seedPrefix("172.16.0.0/12", vrf, "container").id
+
+	f.Server = httptest.NewServer(f)
+	return f
+}
+
+// fakeNetboxForAcc points the acceptance tests at a fake NetBox, which lives as long as the test binary
+func fakeNetboxForAcc() *fakeNetbox {
+	f := newFakeNetbox()
+	os.Setenv(netboxHostEnvVars[0], f.host())
+	os.Setenv(netboxApiTokenEnvVars[0], fakeNetboxToken)
+	os.Setenv(netboxBasePathEnvVars[0], "/api")
+	os.Setenv(netboxParentPrefixIdForTestingVars[0], strconv.FormatInt(f.parentPrefixID, 10))
+	os.Setenv(netboxParentPrefixWithVrfIdForTestingVars[0], strconv.FormatInt(f.parentPrefixWithVrfID, 10))
+	return f
+}
+
+// host returns the address of the server the way the provider expects it, which picks http for localhost
+func (f *fakeNetbox) host() string {
+	return strings.Replace(f.URL, "http://127.0.0.1", "localhost", 1)
+}
+
+// config returns a provider config talking to the fake NetBox
+func (f *fakeNetbox) config() (*Config, error) {
+	config := &Config{
+		ApiToken: fakeNetboxToken,
+		Host:     f.host(),
+		BasePath: "/api",
+	}
+	if err := config.LoadAndValidate(context.Background()); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// requestCount returns how many requests were made, e.g. requestCount("GET", "/ipam/prefixes/{id}/")
+func (f *fakeNetbox) requestCount(method, pattern string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.requests[method+" "+pattern]
+}
+
+func (f *fakeNetbox) newID() int64 {
+	f.nextID++
+	return f.nextID
+}
+
+func (f *fakeNetbox) newObject(objects *[]*fakeObject, name string) *fakeObject {
+	o := &fakeObject{id: f.newID(), name: name, slug: fakeNetboxSlugify(name)}
+	*objects = append(*objects, o)
+	return o
+}
+
+func (f *fakeNetbox) seedPrefix(cidr string, vrf *fakeObject, status string) *fakePrefix {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	p := &fakePrefix{
+		id:           f.newID(),
+		network:      network,
+		vrf:          vrf,
+		status:       status,
+		customFields: map[string]interface{}{},
+		created:      time.Now(),
+		lastUpdated:  time.Now(),
+	}
+	f.prefixes[p.id] = p
+	return p
+}
+
+// seedIPAddress assigns an IP address, e.g. 10.0.0.1/24
+func (f *fakeNetbox) seedIPAddress(address string, vrf *fakeObject, description string) *fakeIPAddress {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ip, network, err := net.ParseCIDR(address)
+	if err != nil {
+		panic(err)
+	}
+	a := &fakeIPAddress{
+		id:          f.newID(),
+		address:     &net.IPNet{IP: ip, Mask: network.Mask},
+		vrf:         vrf,
+		description: description,
+	}
+	f.ipAddresses[a.id] = a
+	return a
+}
+
+var fakeNetboxIDSegment = regexp.MustCompile(`/\d+/`)
+
+func (f *fakeNetbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.hideAPIVersion {
+		w.Header().Set("API-Version", f.apiVersion)
+	}
+	version := f.version()
+	if !version.atLeast(netboxNestedTagsVersion) {
+		f.serve(w, r)
+		return
+	}
+
+	if version.atLeast(netboxRepeatedIDsVersion) {
+		// NetBox ignores the filters it doesn't know
+		q := r.URL.Query()
+		q.Del("id__in")
+		r.URL.RawQuery = q.Encode()
+	}
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			fakeNetboxError(w, http.StatusBadRequest, map[string]interface{}{"detail": err.Error()})
+			return
+		}
+		if body, err = f.unnestTags(body); err != nil {
+			fakeNetboxFieldError(w, "tags", err.Error())
+			return
+		}
+		r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+	}
+
+	rec := httptest.NewRecorder()
+	f.serve(rec, r)
+	for key, values := range rec.Header() {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(rec.Code)
+	var data interface{}
+	if json.Unmarshal(rec.Body.Bytes(), &data) != nil {
+		w.Write(rec.Body.Bytes())
+		return
+	}
+	f.nestTags(data, version)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (f *fakeNetbox) version() netboxVersion {
+	version, err := parseNetboxVersion(f.apiVersion)
+	if err != nil {
+		panic(err)
+	}
+	return version
+}
+
+// unnestTags looks the nested tags of a request up by name or slug, like NetBox 2.9 and later
+func (f *fakeNetbox) unnestTags(body []byte) ([]byte, error) {
+	data := make(map[string]interface{})
+	if json.Unmarshal(body, &data) != nil {
+		return body, nil
+	}
+	tags, ok := data["tags"].([]interface{})
+	if !ok {
+		return body, nil
+	}
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		attrs, ok := tag.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid data. Expected a dictionary, but got %T.", tag)
+		}
+		var found *models.Tag
+		for _, t := range f.tags {
+			if attrs["name"] == *t.Name || attrs["slug"] == *t.Slug {
+				found = t
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("Related object not found using the provided attributes: %v", attrs)
+		}
+		names = append(names, *found.Name)
+	}
+	data["tags"] = names
+	return json.Marshal(data)
+}
+
+// nestTags nests the tags of a response, and sends the created date times of NetBox 3.1 and later
+func (f *fakeNetbox) nestTags(data interface{}, version netboxVersion) {
+	switch v := data.(type) {
+	case []interface{}:
+		for _, item := range v {
+			f.nestTags(item, version)
+		}
+	case map[string]interface{}:
+		for key, value := range v {
+			switch key {
+			case "tags":
+				tags, _ := value.([]interface{})
+				for i, name := range tags {
+					for _, t := range f.tags {
+						if name == *t.Name {
+							tags[i] = map[string]interface{}{"id": t.ID, "name": *t.Name, "slug": *t.Slug, "color": t.Color}
+						}
+					}
+				}
+			case "created":
+				if created, ok := value.(string); ok && version.atLeast(netboxCreatedDateTimeVersion) {
+					v[key] = created + "T09:30:00.123456Z"
+				}
+			case "custom_fields":
+			default:
+				f.nestTags(value, version)
+			}
+		}
+	}
+}
+
+func (f *fakeNetbox) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api")
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	f.requests[r.Method+" "+fakeNetboxIDSegment.ReplaceAllString(path, "/{id}/")]++
+
+	switch auth := r.Header.Get("Authorization"); {
+	case auth == "":
+		fakeNetboxError(w, http.StatusForbidden, map[string]interface{}{"detail": "Authentication credentials were not provided."})
+		return
+	case auth != "Token "+fakeNetboxToken:
+		fakeNetboxError(w, http.StatusForbidden, map[string]interface{}{"detail": "Invalid token"})
+		return
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if path == "/" {
+		segments = nil
+	}
+	var id int64
+	if len(segments) >= 3 {
+		var err error
+		if id, err = strconv.ParseInt(segments[2], 10, 64); err != nil {
+			fakeNetboxNotFound(w)
+			return
+		}
+	}
+
+	switch {
+	case len(segments) == 0:
+		fakeNetboxJSON(w, http.StatusOK, map[string]string{
+			"dcim":    f.url("/api/dcim/"),
+			"extras":  f.url("/api/extras/"),
+			"ipam":    f.url("/api/ipam/"),
+			"tenancy": f.url("/api/tenancy/"),
+		})
+	case path == "/status/" && r.Method == http.MethodGet && f.version().atLeast(netboxVersion{2, 10}):
+		fakeNetboxJSON(w, http.StatusOK, map[string]interface{}{
+			"django-version": "3.2.16",
+			"netbox-version": f.apiVersion + ".1",
+			"python-version": "3.10.6",
+		})
+	case path == "/ipam/prefixes/" && r.Method == http.MethodGet:
+		f.listPrefixes(w, r)
+	case path == "/ipam/prefixes/" && r.Method == http.MethodPost:
+		f.createPrefix(w, r)
+	case len(segments) == 3 && segments[1] == "prefixes":
+		f.prefix(w, r, id)
+	case len(segments) == 4 && segments[1] == "prefixes" && segments[3] == "available-prefixes":
+		f.availablePrefixes(w, r, id)
+	case path == "/ipam/ip-addresses/" && r.Method == http.MethodGet:
+		f.listIPAddresses(w, r)
+	case len(segments) == 3 && segments[1] == "ip-addresses" && r.Method == http.MethodDelete:
+		if _, ok := f.ipAddresses[id]; !ok {
+			fakeNetboxNotFound(w)
+			return
+		}
+		delete(f.ipAddresses, id)
+		w.WriteHeader(http.StatusNoContent)
+	case path == "/dcim/sites/" && r.Method == http.MethodGet:
+		f.listObjects(w, r, f.sites, f.siteModel)
+	case path == "/ipam/vrfs/" && r.Method == http.MethodGet:
+		f.listObjects(w, r, f.vrfs, f.vrfModel)
+	case path == "/ipam/vlans/" && r.Method == http.MethodGet:
+		f.listObjects(w, r, f.vlans, f.vlanModel)
+	case path == "/ipam/roles/" && r.Method == http.MethodGet:
+		f.listObjects(w, r, f.roles, f.roleModel)
+	case path == "/tenancy/tenants/" && r.Method == http.MethodGet:
+		f.listObjects(w, r, f.tenants, f.tenantModel)
+	case path == "/extras/tags/" && r.Method == http.MethodGet:
+		f.listTags(w, r)
+	case path == "/extras/tags/" && r.Method == http.MethodPost:
+		f.createTag(w, r)
+	case len(segments) == 3 && segments[1] == "tags":
+		f.tag(w, r, id)
+	default:
+		fakeNetboxNotFound(w)
+	}
+}
+
+func (f *fakeNetbox) url(path string) string {
+	return f.URL + path
+}
+
+func fakeNetboxJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func fakeNetboxError(w http.ResponseWriter, code int, body map[string]interface{}) {
+	fakeNetboxJSON(w, code, body)
+}
+
+func fakeNetboxNotFound(w http.ResponseWriter) {
+	fakeNetboxError(w, http.StatusNotFound, map[string]interface{}{"detail": "Not found."})
+}
+
+func fakeNetboxFieldError(w http.ResponseWriter, field, message string) {
+	fakeNetboxError(w, http.StatusBadRequest, map[string]interface{}{field: []string{message}})
+}
+
+// fakeNetboxPage paginates the results the same way as NetBox, a limit of 0 being the maximum page size
+func (f *fakeNetbox) page(w http.ResponseWriter, r *http.Request, results []interface{}) {
+	q := r.URL.Query()
+	limit := fakeNetboxPageSize
+	if v := q.Get("limit"); v != "" {
+		if l, err := strconv.Atoi(v); err == nil {
+			limit = l
+		}
+	}
+	if limit <= 0 || limit > fakeNetboxMaxPage {
+		limit = fakeNetboxMaxPage
+	}
+	offset := 0
+	if v := q.Get("offset"); v != "" {
+		if o, err := strconv.Atoi(v); err == nil && o > 0 {
+			offset = o
+		}
+	}
+
+	pageURL := func(offset int) interface{} {
+		u := *r.URL
+		query := u.Query()
+		query.Set("limit", strconv.Itoa(limit))
+		query.Set("offset", strconv.Itoa(offset))
+		if offset == 0 {
+			query.Del("offset")
+		}
+		u.RawQuery = query.Encode()
+		return f.URL + u.RequestURI()
+	}
+
+	body := map[string]interface{}{
+		"count":    len(results),
+		"next":     nil,
+		"previous": nil,
+		"results":  []interface{}{},
+	}
+	if offset < len(results) {
+		end := offset + limit
+		if end > len(results) {
+			end = len(results)
+		}
+		body["results"] = results[offset:end]
+		if end < len(results) {
+			body["next"] = pageURL(end)
+		}
+	}
+	if offset > 0 {
+		previous := offset - limit
+		if previous < 0 {
+			previous = 0
+		}
+		body["previous"] = pageURL(previous)
+	}
+	fakeNetboxJSON(w, http.StatusOK, body)
+}
+
+// sortedPrefixes orders the prefixes the same way as NetBox: by VRF, global first, then by network
+func (f *fakeNetbox) sortedPrefixes() []*fakePrefix {
+	prefixes := make([]*fakePrefix, 0, len(f.prefixes))
+	for _, p := range f.prefixes {
+		prefixes = append(prefixes, p)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		a, b := prefixes[i], prefixes[j]
+		if av, bv := a.vrfID(), b.vrfID(); av != bv {
+			return av < bv
+		}
+		if c := compareNetworks(a.network, b.network); c != 0 {
+			return c < 0
+		}
+		return a.id < b.id
+	})
+	return prefixes
+}
+
+func compareNetworks(a, b *net.IPNet) int {
+	ao, abits := a.Mask.Size()
+	bo, bbits := b.Mask.Size()
+	if abits != bbits {
+		return abits - bbits
+	}
+	if c := ipToInt(a.IP, abits).Cmp(ipToInt(b.IP, bbits)); c != 0 {
+		return c
+	}
+	return ao - bo
+}
+
+func (p *fakePrefix) vrfID() int64 {
+	if p.vrf == nil {
+		return 0
+	}
+	return p.vrf.id
+}
+
+// netContains tells whether inner lies within outer, inner being outer itself included
+func netContains(outer, inner *net.IPNet) bool {
+	oo, obits := outer.Mask.Size()
+	io, ibits := inner.Mask.Size()
+	return obits == ibits && oo <= io && outer.Contains(inner.IP)
+}
+
+func (f *fakeNetbox) listPrefixes(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	// netbox validates the tag filter against the existing tags, whether any prefix matches or not
+	for _, slug := range fakeNetboxValues(q, "tag") {
+		if f.tagBySlug(slug) == nil {
+			fakeNetboxFieldError(w, "tag", fmt.Sprintf("Select a valid choice. %s is not one of the available choices.", slug))
+			return
+		}
+	}
+	results := make([]interface{}, 0)
+	for _, p := range f.sortedPrefixes() {
+		ok, err := f.matchPrefix(p, q)
+		if err != nil {
+			fakeNetboxError(w, http.StatusBadRequest, map[string]interface{}{"detail": err.Error()})
+			return
+		}
+		if ok {
+			results = append(results, f.prefixModel(p))
+		}
+	}
+	f.page(w, r, results)
+}
+
+// fakeNetboxValues splits repeated and comma separated values
+func fakeNetboxValues(q url.Values, key string) []string {
+	var values []string
+	for _, v := range q[key] {
+		for _, s := range strings.Split(v, ",") {
+			if s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+func fakeNetboxParseNet(v string) (*net.IPNet, error) {
+	if !strings.Contains(v, "/") {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid IP address: %s", v)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(v)
+	return network, err
+}
+
+func (f *fakeNetbox) matchPrefix(p *fakePrefix, q url.Values) (bool, error) {
+	ones, bits := p.network.Mask.Size()
+	for key := range q {
+		values := fakeNetboxValues(q, key)
+		if len(values) == 0 {
+			// netbox ignores empty filters
+			continue
+		}
+		v := values[0]
+		switch key {
+		case "id", "id__in":
+			if !fakeNetboxContainsString(values, strconv.FormatInt(p.id, 10)) {
+				return false, nil
+			}
+		case "prefix":
+			network, err := fakeNetboxParseNet(v)
+			if err != nil {
+				return false, err
+			}
+			if network.String() != p.network.String() {
+				return false, nil
+			}
+		case "within", "within_include", "contains":
+			network, err := fakeNetboxParseNet(v)
+			if err != nil {
+				return false, err
+			}
+			no, _ := network.Mask.Size()
+			switch key {
+			case "within":
+				if !netContains(network, p.network) || no == ones {
+					return false, nil
+				}
+			case "within_include":
+				if !netContains(network, p.network) {
+					return false, nil
+				}
+			case "contains":
+				if !netContains(p.network, network) {
+					return false, nil
+				}
+			}
+		case "mask_length":
+			l, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return false, err
+			}
+			if int(l) != ones {
+				return false, nil
+			}
+		case "family":
+			family, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return false, err
+			}
+			if int(family) != familyOfBits(bits) {
+				return false, nil
+			}
+		case "vrf_id":
+			if !fakeNetboxMatchID(values, p.vrf) {
+				return false, nil
+			}
+		case "vrf":
+			if v == "null" && p.vrf != nil || v != "null" && (p.vrf == nil || p.vrf.rd != v) {
+				return false, nil
+			}
+		case "status":
+			if !fakeNetboxContainsString(values, p.status) {
+				return false, nil
+			}
+		case "tag":
+			found := false
+			for _, t := range p.tags {
+				if fakeNetboxContainsString(values, fakeNetboxSlugify(t)) {
+					found = true
+				}
+			}
+			if !found {
+				return false, nil
+			}
+		case "is_pool":
+			if strconv.FormatBool(p.isPool) != strings.ToLower(v) {
+				return false, nil
+			}
+		case "site_id":
+			if !fakeNetboxMatchID(values, p.site) {
+				return false, nil
+			}
+		case "site":
+			if p.site == nil || !fakeNetboxContainsString(values, p.site.slug) {
+				return false, nil
+			}
+		case "role_id":
+			if !fakeNetboxMatchID(values, p.role) {
+				return false, nil
+			}
+		case "role":
+			if p.role == nil || !fakeNetboxContainsString(values, p.role.slug) {
+				return false, nil
+			}
+		case "tenant_id":
+			if !fakeNetboxMatchID(values, p.tenant) {
+				return false, nil
+			}
+		case "tenant":
+			if p.tenant == nil || !fakeNetboxContainsString(values, p.tenant.slug) {
+				return false, nil
+			}
+		case "vlan_id":
+			if !fakeNetboxMatchID(values, p.vlan) {
+				return false, nil
+			}
+		case "vlan_vid":
+			if p.vlan == nil || !fakeNetboxContainsString(values, strconv.FormatInt(p.vlan.vid, 10)) {
+				return false, nil
+			}
+		case "q":
+			query := strings.ToLower(v)
+			if !strings.Contains(p.network.String(), query) && !strings.Contains(strings.ToLower(p.description), query) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+func fakeNetboxContainsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeNetboxMatchID matches an object by its ID, null matching no object
+func fakeNetboxMatchID(values []string, o *fakeObject) bool {
+	for _, v := range values {
+		if v == "null" && o == nil {
+			return true
+		}
+		if o != nil && v == strconv.FormatInt(o.id, 10) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeNetbox) prefix(w http.ResponseWriter, r *http.Request, id int64) {
+	p, ok := f.prefixes[id]
+	if !ok {
+		fakeNetboxNotFound(w)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		fakeNetboxJSON(w, http.StatusOK, f.prefixModel(p))
+	case http.MethodPatch, http.MethodPut:
+		data, ok := fakeNetboxDecodeObject(w, r)
+		if !ok {
+			return
+		}
+		updated := *p
+		if !f.applyPrefix(w, &updated, data) || !f.validatePrefix(w, &updated) {
+			return
+		}
+		updated.lastUpdated = time.Now()
+		*p = updated
+		fakeNetboxJSON(w, http.StatusOK, f.prefixModel(p))
+	case http.MethodDelete:
+		delete(f.prefixes, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeNetboxError(w, http.StatusMethodNotAllowed, map[string]interface{}{"detail": fmt.Sprintf("Method \"%s\" not allowed.", r.Method)})
+	}
+}
+
+func (f *fakeNetbox) createPrefix(w http.ResponseWriter, r *http.Request) {
+	data, ok := fakeNetboxDecodeObject(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := data["prefix"]; !ok {
+		fakeNetboxFieldError(w, "prefix", "This field is required.")
+		return
+	}
+	p := &fakePrefix{status: "active", customFields: map[string]interface{}{}}
+	if !f.applyPrefix(w, p, data) || !f.validatePrefix(w, p) {
+		return
+	}
+	f.addPrefix(p)
+	fakeNetboxJSON(w, http.StatusCreated, f.prefixModel(p))
+}
+
+func (f *fakeNetbox) addPrefix(p *fakePrefix) {
+	p.id = f.newID()
+	p.created = time.Now()
+	p.lastUpdated = p.created
+	f.prefixes[p.id] = p
+}
+
+func fakeNetboxDecodeObject(w http.ResponseWriter, r *http.Request) (map[string]json.RawMessage, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fakeNetboxError(w, http.StatusBadRequest, map[string]interface{}{"detail": err.Error()})
+		return nil, false
+	}
+	data := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(body, &data); err != nil {
+		fakeNetboxError(w, http.StatusBadRequest, map[string]interface{}{"detail": fmt.Sprintf("JSON parse error - %v", err)})
+		return nil, false
+	}
+	return data, true
+}
+
+// applyPrefix sets the writable fields of a prefix, and answers with the field errors of netbox
+func (f *fakeNetbox) applyPrefix(w http.ResponseWriter, p *fakePrefix, data map[string]json.RawMessage) bool {
+	related := map[string]struct {
+		objects []*fakeObject
+		field   **fakeObject
+	}{
+		"site":   {f.sites, &p.site},
+		"vrf":    {f.vrfs, &p.vrf},
+		"tenant": {f.tenants, &p.tenant},
+		"vlan":   {f.vlans, &p.vlan},
+		"role":   {f.roles, &p.role},
+	}
+
+	for key, raw := range data {
+		switch key {
+		case "prefix":
+			var cidr string
+			if err := json.Unmarshal(raw, &cidr); err != nil || cidr == "" {
+				fakeNetboxFieldError(w, key, "This field may not be null.")
+				return false
+			}
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				fakeNetboxFieldError(w, key, fmt.Sprintf("invalid IPNetwork %s", cidr))
+				return false
+			}
+			p.network = network
+		case "site", "vrf", "tenant", "vlan", "role":
+			var id *int64
+			if err := json.Unmarshal(raw, &id); err != nil {
+				fakeNetboxFieldError(w, key, fmt.Sprintf("Incorrect type. Expected pk value, received %s.", raw))
+				return false
+			}
+			rel := related[key]
+			if id == nil {
+				*rel.field = nil
+				continue
+			}
+			var found *fakeObject
+			for _, o := range rel.objects {
+				if o.id == *id {
+					found = o
+				}
+			}
+			if found == nil {
+				fakeNetboxFieldError(w, key, fmt.Sprintf("Invalid pk \"%d\" - object does not exist.", *id))
+				return false
+			}
+			*rel.field = found
+		case "status":
+			var status string
+			if err := json.Unmarshal(raw, &status); err != nil {
+				fakeNetboxFieldError(w, key, fmt.Sprintf("%s is not a valid choice.", raw))
+				return false
+			}
+			if _, ok := fakeNetboxPrefixStatuses[status]; !ok {
+				fakeNetboxFieldError(w, key, fmt.Sprintf("\"%s\" is not a valid choice.", status))
+				return false
+			}
+			p.status = status
+		case "is_pool":
+			if err := json.Unmarshal(raw, &p.isPool); err != nil {
+				fakeNetboxFieldError(w, key, "Must be a valid boolean.")
+				return false
+			}
+		case "description":
+			if err := json.Unmarshal(raw, &p.description); err != nil {
+				fakeNetboxFieldError(w, key, "Not a valid string.")
+				return false
+			}
+			if len(p.description) > 200 {
+				fakeNetboxFieldError(w, key, "Ensure this field has no more than 200 characters.")
+				return false
+			}
+		case "tags":
+			var tags []string
+			if err := json.Unmarshal(raw, &tags); err != nil {
+				fakeNetboxFieldError(w, key, "Expected a list of items.")
+				return false
+			}
+			for _, t := range tags {
+				f.ensureTag(t)
+			}
+			p.tags = tags
+		case "custom_fields":
+			var cfs map[string]interface{}
+			if err := json.Unmarshal(raw, &cfs); err != nil {
+				fakeNetboxFieldError(w, key, "Invalid data. Expected a dictionary.")
+				return false
+			}
+			for name, value := range cfs {
+				if !fakeNetboxContainsString(fakeNetboxCustomFields, name) {
+					fakeNetboxFieldError(w, key, fmt.Sprintf("Invalid custom field for prefix objects: %s", name))
+					return false
+				}
+				p.customFields[name] = value
+			}
+		}
+	}
+	return true
+}
+
+// validatePrefix enforces the unique prefixes of a VRF
+func (f *fakeNetbox) validatePrefix(w http.ResponseWriter, p *fakePrefix) bool {
+	if p.vrf == nil {
+		return true
+	}
+	for _, other := range f.prefixes {
+		if other.id != p.id && other.vrfID() == p.vrfID() && other.network.String() == p.network.String() {
+			fakeNetboxFieldError(w, "prefix", fmt.Sprintf("Duplicate prefix found in VRF %s: %s", p.vrf.name, other.network))
+			return false
+		}
+	}
+	return true
+}
+
+// freeBlocks returns the available prefixes within the parent the same way as Prefix.get_available_prefixes(),
+// a global container spans every VRF.
+func (f *fakeNetbox) freeBlocks(parent *fakePrefix) []*net.IPNet {
+	_, bits := parent.network.Mask.Size()
+	var children []*net.IPNet
+	for _, p := range f.prefixes {
+		if p.id == parent.id || !netContains(parent.network, p.network) || p.network.String() == parent.network.String() {
+			continue
+		}
+		if parent.vrf != nil || parent.status != "container" {
+			if p.vrfID() != parent.vrfID() {
+				continue
+			}
+		}
+		children = append(children, p.network)
+	}
+	children = collapseCIDRs(children)
+	sort.Slice(children, func(i, j int) bool { return compareNetworks(children[i], children[j]) < 0 })
+
+	var free []*net.IPNet
+	start := ipToInt(parent.network.IP, bits)
+	end := new(big.Int).Add(start, cidrSize(parent.network))
+	for _, c := range children {
+		childStart := ipToInt(c.IP, bits)
+		free = append(free, rangeToCIDRs(start, childStart, bits)...)
+		start = new(big.Int).Add(childStart, cidrSize(c))
+	}
+	return append(free, rangeToCIDRs(start, end, bits)...)
+}
+
+// rangeToCIDRs splits the addresses from start up to end, excluded, into the largest aligned networks
+func rangeToCIDRs(start, end *big.Int, bits int) []*net.IPNet {
+	var cidrs []*net.IPNet
+	start = new(big.Int).Set(start)
+	for start.Cmp(end) < 0 {
+		hostBits := new(big.Int).Sub(end, start).BitLen() - 1
+		if start.Sign() > 0 && int(start.TrailingZeroBits()) < hostBits {
+			hostBits = int(start.TrailingZeroBits())
+		}
+		cidrs = append(cidrs, &net.IPNet{
+			IP:   intToIP(start, bits),
+			Mask: net.CIDRMask(bits-hostBits, bits),
+		})
+		start.Add(start, new(big.Int).Lsh(big.NewInt(1), uint(hostBits)))
+	}
+	return cidrs
+}
+
+func (f *fakeNetbox) availablePrefixes(w http.ResponseWriter, r *http.Request, id int64) {
+	parent, ok := f.prefixes[id]
+	if !ok {
+		fakeNetboxNotFound(w)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		results := make([]*models.AvailablePrefix, 0)
+		for _, block := range f.freeBlocks(parent) {
+			_, bits := block.Mask.Size()
+			results = append(results, &models.AvailablePrefix{
+				Family: int64(familyOfBits(bits)),
+				Prefix: block.String(),
+				Vrf:    f.nestedVRF(parent.vrf),
+			})
+		}
+		fakeNetboxJSON(w, http.StatusOK, results)
+	case http.MethodPost:
+		data, ok := fakeNetboxDecodeObject(w, r)
+		if !ok {
+			return
+		}
+		var length int
+		if raw, ok := data["prefix_length"]; !ok || json.Unmarshal(raw, &length) != nil {
+			fakeNetboxFieldError(w, "prefix_length", "This field is required.")
+			return
+		}
+		delete(data, "prefix_length")
+		delete(data, "prefix")
+		delete(data, "vrf")
+
+		var allocated *net.IPNet
+		for _, block := range f.freeBlocks(parent) {
+			ones, bits := block.Mask.Size()
+			if ones <= length && length <= bits {
+				allocated = firstSubnet(block, length)
+				break
+			}
+		}
+		if allocated == nil {
+			// netbox answers an insufficient space with a 204 carrying a body
+			fakeNetboxJSON(w, http.StatusNoContent, map[string]interface{}{
+				"detail": "Insufficient space is available to accommodate the requested prefix size(s)",
+			})
+			return
+		}
+
+		p := &fakePrefix{network: allocated, vrf: parent.vrf, status: "active", customFields: map[string]interface{}{}}
+		if !f.applyPrefix(w, p, data) {
+			return
+		}
+		f.addPrefix(p)
+		fakeNetboxJSON(w, http.StatusCreated, f.prefixModel(p))
+	default:
+		fakeNetboxError(w, http.StatusMethodNotAllowed, map[string]interface{}{"detail": fmt.Sprintf("Method \"%s\" not allowed.", r.Method)})
+	}
+}
+
+func (f *fakeNetbox) listIPAddresses(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ids := make([]int64, 0, len(f.ipAddresses))
+	for id := range f.ipAddresses {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	results := make([]interface{}, 0)
+	for _, id := range ids {
+		a := f.ipAddresses[id]
+		if v := q.Get("parent"); v != "" {
+			parent, err := fakeNetboxParseNet(v)
+			if err != nil {
+				fakeNetboxError(w, http.StatusBadRequest, map[string]interface{}{"detail": err.Error()})
+				return
+			}
+			if !parent.Contains(a.address.IP) {
+				continue
+			}
+		}
+		if values := fakeNetboxValues(q, "vrf_id"); len(values) > 0 && !fakeNetboxMatchID(values, a.vrf) {
+			continue
+		}
+		address := a.address.String()
+		results = append(results, &models.IPAddress{
+			ID:          a.id,
+			Address:     &address,
+			Vrf:         f.nestedVRF(a.vrf),
+			Description: a.description,
+			Tags:        a.tags,
+		})
+	}
+	f.page(w, r, results)
+}
+
+func (f *fakeNetbox) listObjects(w http.ResponseWriter, r *http.Request, objects []*fakeObject, model func(*fakeObject) interface{}) {
+	q := r.URL.Query()
+	results := make([]interface{}, 0)
+	for _, o := range objects {
+		if v := q.Get("name"); v != "" && v != o.name {
+			continue
+		}
+		if v := q.Get("slug"); v != "" && v != o.slug {
+			continue
+		}
+		if values := fakeNetboxValues(q, "id"); len(values) > 0 && !fakeNetboxMatchID(values, o) {
+			continue
+		}
+		if v := q.Get("vid"); v != "" && v != strconv.FormatInt(o.vid, 10) {
+			continue
+		}
+		if v := q.Get("q"); v != "" && !strings.Contains(strings.ToLower(o.name), strings.ToLower(v)) {
+			continue
+		}
+		results = append(results, model(o))
+	}
+	f.page(w, r, results)
+}
+
+func (f *fakeNetbox) listTags(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ids := make([]int64, 0, len(f.tags))
+	for id := range f.tags {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return *f.tags[ids[i]].Name < *f.tags[ids[j]].Name })
+
+	results := make([]interface{}, 0)
+	for _, id := range ids {
+		t := f.tags[id]
+		if v := q.Get("name"); v != "" && v != *t.Name {
+			continue
+		}
+		if v := q.Get("slug"); v != "" && v != *t.Slug {
+			continue
+		}
+		if v := q.Get("q"); v != "" && !strings.Contains(strings.ToLower(*t.Name), strings.ToLower(v)) {
+			continue
+		}
+		tag := *t
+		tag.TaggedItems = f.taggedItems(*t.Name)
+		results = append(results, &tag)
+	}
+	f.page(w, r, results)
+}
+
+func (f *fakeNetbox) taggedItems(name string) int64 {
+	var count int64
+	for _, p := range f.prefixes {
+		if fakeNetboxContainsString(p.tags, name) {
+			count++
+		}
+	}
+	return count
+}
+
+// ensureTag creates a tag assigned by its name, like django-taggit does
+func (f *fakeNetbox) ensureTag(name string) {
+	for _, t := range f.tags {
+		if *t.Name == name {
+			return
+		}
+	}
+	slug := fakeNetboxSlugify(name)
+	tag := &models.Tag{ID: f.newID(), Name: &name, Slug: &slug, Color: "9e9e9e"}
+	f.tags[tag.ID] = tag
+}
+
+func (f *fakeNetbox) tagBySlug(slug string) *models.Tag {
+	for _, t := range f.tags {
+		if *t.Slug == slug {
+			return t
+		}
+	}
+	return nil
+}
+
+func (f *fakeNetbox) createTag(w http.ResponseWriter, r *http.Request) {
+	tag := &models.Tag{}
+	if !f.decodeTag(w, r, tag) {
+		return
+	}
+	tag.ID = f.newID()
+	f.tags[tag.ID] = tag
+	fakeNetboxJSON(w, http.StatusCreated, tag)
+}
+
+func (f *fakeNetbox) decodeTag(w http.ResponseWriter, r *http.Request, tag *models.Tag) bool {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fakeNetboxError(w, http.StatusBadRequest, map[string]interface{}{"detail": err.Error()})
+		return false
+	}
+	if err := json.Unmarshal(body, tag); err != nil {
+		fakeNetboxError(w, http.StatusBadRequest, map[string]interface{}{"detail": fmt.Sprintf("JSON parse error - %v", err)})
+		return false
+	}
+	if tag.Name == nil || *tag.Name == "" {
+		fakeNetboxFieldError(w, "name", "This field is required.")
+		return false
+	}
+	if tag.Slug == nil || *tag.Slug == "" {
+		fakeNetboxFieldError(w, "slug", "This field is required.")
+		return false
+	}
+	if fakeNetboxSlugify(*tag.Slug) != *tag.Slug {
+		fakeNetboxFieldError(w, "slug", "Enter a valid \"slug\" consisting of letters, numbers, underscores or hyphens.")
+		return false
+	}
+	for id, other := range f.tags {
+		if id == tag.ID {
+			continue
+		}
+		if *other.Name == *tag.Name {
+			fakeNetboxFieldError(w, "name", "tag with this name already exists.")
+			return false
+		}
+		if *other.Slug == *tag.Slug {
+			fakeNetboxFieldError(w, "slug", "tag with this slug already exists.")
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeNetbox) tag(w http.ResponseWriter, r *http.Request, id int64) {
+	t, ok := f.tags[id]
+	if !ok {
+		fakeNetboxNotFound(w)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		fakeNetboxJSON(w, http.StatusOK, t)
+	case http.MethodPatch, http.MethodPut:
+		updated := *t
+		if !f.decodeTag(w, r, &updated) {
+			return
+		}
+		updated.ID = id
+		for _, p := range f.prefixes {
+			for i, name := range p.tags {
+				if name == *t.Name {
+					p.tags[i] = *updated.Name
+				}
+			}
+		}
+		*t = updated
+		fakeNetboxJSON(w, http.StatusOK, t)
+	case http.MethodDelete:
+		for _, p := range f.prefixes {
+			tags := make([]string, 0, len(p.tags))
+			for _, name := range p.tags {
+				if name != *t.Name {
+					tags = append(tags, name)
+				}
+			}
+			p.tags = tags
+		}
+		delete(f.tags, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeNetboxError(w, http.StatusMethodNotAllowed, map[string]interface{}{"detail": fmt.Sprintf("Method \"%s\" not allowed.", r.Method)})
+	}
+}
+
+func fakeNetboxSlugify(name string) string {
+	return strings.Trim(fakeNetboxSlugInvalidChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func (f *fakeNetbox) prefixModel(p *fakePrefix) *models.Prefix {
+	cidr := p.network.String()
+	ones, bits := p.network.Mask.Size()
+	family := int64(familyOfBits(bits))
+	familyLabel := fmt.Sprintf("IPv%d", family)
+	status := p.status
+	statusLabel := strings.Title(status)
+	isPool := p.isPool
+
+	customFields := make(map[string]interface{}, len(fakeNetboxCustomFields))
+	for _, name := range fakeNetboxCustomFields {
+		customFields[name] = p.customFields[name]
+	}
+	tags := append([]string{}, p.tags...)
+	sort.Strings(tags)
+
+	prefix := &models.Prefix{
+		ID:           p.id,
+		Prefix:       &cidr,
+		PrefixLength: int64(ones),
+		Family:       &models.PrefixFamily{Label: &familyLabel, Value: &family},
+		Status:       &models.PrefixStatus{Label: &statusLabel, Value: &status},
+		IsPool:       &isPool,
+		Description:  p.description,
+		Tags:         tags,
+		CustomFields: customFields,
+		Created:      strfmt.Date(p.created),
+		LastUpdated:  strfmt.DateTime(p.lastUpdated),
+		Vrf:          f.nestedVRF(p.vrf),
+	}
+	if p.site != nil {
+		prefix.Site = &models.NestedSite{ID: p.site.id, Name: &p.site.name, Slug: &p.site.slug, URL: strfmt.URI(f.url(fmt.Sprintf("/api/dcim/sites/%d/", p.site.id)))}
+	}
+	if p.tenant != nil {
+		prefix.Tenant = f.nestedTenant(p.tenant)
+	}
+	if p.vlan != nil {
+		vid := p.vlan.vid
+		prefix.Vlan = &models.NestedVLAN{ID: p.vlan.id, Name: &p.vlan.name, Vid: &vid, DisplayName: fmt.Sprintf("%s (%d)", p.vlan.name, vid)}
+	}
+	if p.role != nil {
+		prefix.Role = &models.NestedRole{ID: p.role.id, Name: &p.role.name, Slug: &p.role.slug}
+	}
+	return prefix
+}
+
+func (f *fakeNetbox) nestedVRF(vrf *fakeObject) *models.NestedVRF {
+	if vrf == nil {
+		return nil
+	}
+	return &models.NestedVRF{ID: vrf.id, Name: &vrf.name, Rd: &vrf.rd, URL: strfmt.URI(f.url(fmt.Sprintf("/api/ipam/vrfs/%d/", vrf.id)))}
+}
+
+func (f *fakeNetbox) nestedTenant(tenant *fakeObject) *models.NestedTenant {
+	if tenant == nil {
+		return nil
+	}
+	return &models.NestedTenant{ID: tenant.id, Name: &tenant.name, Slug: &tenant.slug}
+}
+
+func (f *fakeNetbox) siteModel(o *fakeObject) interface{} {
+	return &models.Site{ID: o.id, Name: &o.name, Slug: &o.slug, Tenant: f.nestedTenant(o.tenant)}
+}
+
+func (f *fakeNetbox) vrfModel(o *fakeObject) interface{} {
+	enforceUnique := true
+	return &models.VRF{ID: o.id, Name: &o.name, Rd: &o.rd, EnforceUnique: &enforceUnique}
+}
+
+func (f *fakeNetbox) vlanModel(o *fakeObject) interface{} {
+	vid := o.vid
+	return &models.VLAN{ID: o.id, Name: &o.name, Vid: &vid}
+}
+
+func (f *fakeNetbox) roleModel(o *fakeObject) interface{} {
+	return &models.Role{ID: o.id, Name: &o.name, Slug: &o.slug}
+}
+
+func (f *fakeNetbox) tenantModel(o *fakeObject) interface{} {
+	return &models.Tenant{ID: o.id, Name: &o.name, Slug: &o.slug}
+}
+
+func TestFakeNetboxErrorBodies(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+
+	cases := map[string]struct {
+		path     string
+		token    string
+		code     int
+		expected string
+	}{
+		"no token":      {path: "/api/ipam/prefixes/", code: http.StatusForbidden, expected: `{"detail":"Authentication credentials were not provided."}`},
+		"invalid token": {path: "/api/ipam/prefixes/", token: "nope", code: http.StatusForbidden, expected: `{"detail":"Invalid token"}`},
+		"not found":     {path: "/api/ipam/prefixes/9999/", token: fakeNetboxToken, code: http.StatusNotFound, expected: `{"detail":"Not found."}`},
+		"unknown path":  {path: "/api/ipam/aggregates/", token: fakeNetboxToken, code: http.StatusNotFound, expected: `{"detail":"Not found."}`},
+	}
+	for tn, tc := range cases {
+		req, _ := http.NewRequest(http.MethodGet, f.URL+tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Token "+tc.token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tn, err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tc.code || strings.TrimSpace(string(body)) != tc.expected {
+			t.Fatalf("%s: expected %d %s, got %d %s", tn, tc.code, tc.expected, res.StatusCode, body)
+		}
+	}
+}
+
+func TestFakeNetboxPagination(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	for i := 0; i < 120; i++ {
+		f.seedPrefix(fmt.Sprintf("10.0.%d.0/24", i), nil, "active")
+	}
+
+	get := func(query string) map[string]interface{} {
+		req, _ := http.NewRequest(http.MethodGet, f.URL+"/api/ipam/prefixes/?within=10.0.0.0/12"+query, nil)
+		req.Header.Set("Authorization", "Token "+fakeNetboxToken)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer res.Body.Close()
+		page := make(map[string]interface{})
+		if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return page
+	}
+
+	cases := map[string]struct {
+		query    string
+		results  int
+		next     bool
+		previous bool
+	}{
+		"default limit": {query: "", results: 50, next: true},
+		"middle page":   {query: "&limit=50&offset=50", results: 50, next: true, previous: true},
+		"last page":     {query: "&limit=50&offset=100", results: 20, previous: true},
+		"no limit":      {query: "&limit=0", results: 120},
+		"past the end":  {query: "&offset=200", results: 0, previous: true},
+	}
+	for tn, tc := range cases {
+		page := get(tc.query)
+		if page["count"].(float64) != 120 {
+			t.Fatalf("%s: expected a count of 120, got %v", tn, page["count"])
+		}
+		if results := len(page["results"].([]interface{})); results != tc.results {
+			t.Fatalf("%s: expected %d results, got %d", tn, tc.results, results)
+		}
+		if (page["next"] != nil) != tc.next || (page["previous"] != nil) != tc.previous {
+			t.Fatalf("%s: expected next %t and previous %t, got %v and %v", tn, tc.next, tc.previous, page["next"], page["previous"])
+		}
+	}
+
+	first := get("&limit=1")["results"].([]interface{})[0].(map[string]interface{})
+	if first["prefix"] != "10.0.0.0/24" {
+		t.Fatalf("expected the prefixes ordered by network, got %v first", first["prefix"])
+	}
+}
+
+func TestRangeToCIDRs(t *testing.T) {
+	cases := map[string]struct {
+		start, end string
+		expected   []string
+	}{
+		"aligned":   {start: "10.0.0.0", end: "10.0.1.0", expected: []string{"10.0.0.0/24"}},
+		"unaligned": {start: "10.0.0.64", end: "10.0.2.0", expected: []string{"10.0.0.64/26", "10.0.0.128/25", "10.0.1.0/24"}},
+		"ragged":    {start: "10.0.0.0", end: "10.0.0.3", expected: []string{"10.0.0.0/31", "10.0.0.2/32"}},
+		"empty":     {start: "10.0.0.0", end: "10.0.0.0"},
+	}
+	for tn, tc := range cases {
+		start := ipToInt(net.ParseIP(tc.start).To4(), 32)
+		end := ipToInt(net.ParseIP(tc.end).To4(), 32)
+		var cidrs []string
+		for _, c := range rangeToCIDRs(start, end, 32) {
+			cidrs = append(cidrs, c.String())
+		}
+		if strings.Join(cidrs, ",") != strings.Join(tc.expected, ",") {
+			t.Fatalf("%s: expected %v, got %v", tn, tc.expected, cidrs)
+		}
+	}
+}
@@ -1,17 +1,333 @@
 package netbox
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/fenglyu/go-netbox/netbox/client/ipam"
+	"github.com/fenglyu/go-netbox/netbox/models"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 )
 
+// Test fixtures are described as testAccXxx, or tagged like AvailablePrefix-acc<random suffix>-01
+const testAccDescriptionPrefix = "testAcc"
+
+var testAccTagRegexp = regexp.MustCompile(`-acc[a-z0-9]{10}-\d{2}$`)
+
 func TestMain(m *testing.M) {
 	resource.TestMain(m)
 }
 
-// sharedClientForRegion returns a common provider client configured for the specified region
+func init() {
+	resource.AddTestSweepers("netbox_ip_addresses", &resource.Sweeper{
+		Name: "netbox_ip_addresses",
+		F:    testSweepIpamIPAddresses,
+	})
+	// The VLANs are found through the prefixes assigned them, so they're swept first
+	resource.AddTestSweepers("netbox_vlans", &resource.Sweeper{
+		Name: "netbox_vlans",
+		F:    testSweepIpamVlans,
+	})
+	resource.AddTestSweepers("netbox_available_prefixes", &resource.Sweeper{
+		Name:         "netbox_available_prefixes",
+		F:            testSweepIpamPrefixes,
+		Dependencies: []string{"netbox_ip_addresses", "netbox_vlans"},
+	})
+}
+
+// sharedClientForRegion returns a common provider client configured for the specified region,
+// netbox has no regions, so it's the same client built from the env vars as the acceptance tests use
 func sharedClientForRegion(region string) (interface{}, error) {
+	config := &Config{
+		ApiToken: multiEnvSearch(netboxApiTokenEnvVars),
+		Host:     multiEnvSearch(netboxHostEnvVars),
+		BasePath: multiEnvSearch(netboxBasePathEnvVars),
+	}
+	if config.ApiToken == "" {
+		return nil, fmt.Errorf("One of %s must be set for sweepers", strings.Join(netboxApiTokenEnvVars, ", "))
+	}
+	if config.Host == "" {
+		return nil, fmt.Errorf("One of %s must be set for sweepers", strings.Join(netboxHostEnvVars, ", "))
+	}
+
+	if err := config.LoadAndValidate(context.Background()); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// isTestAccFixture tells whether an object was made by an acceptance test
+func isTestAccFixture(description string, tags []string) bool {
+	if strings.HasPrefix(description, testAccDescriptionPrefix) {
+		return true
+	}
+	for _, t := range tags {
+		if testAccTagRegexp.MatchString(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// testSweepParentPrefixes returns the parent prefixes the acceptance tests allocate under
+func testSweepParentPrefixes(config *Config) ([]string, error) {
+	var parents []string
+	for _, envs := range [][]string{netboxParentPrefixIdForTestingVars, netboxParentPrefixWithVrfIdForTestingVars} {
+		v := multiEnvSearch(envs)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a prefix ID: %v", strings.Join(envs, ", "), err)
+		}
+		parent, err := getIpamPrefixByID(config, id)
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, *parent.Prefix)
+	}
+	if len(parents) == 0 {
+		return nil, fmt.Errorf("One of %s must be set for sweepers", strings.Join(append(netboxParentPrefixIdForTestingVars, netboxParentPrefixWithVrfIdForTestingVars...), ", "))
+	}
+	return parents, nil
+}
+
+// testSweepListPrefixes lists the prefixes within the parent, pageSize at a time, netbox caps the page size
+func testSweepListPrefixes(config *Config, parent string, pageSize int64) ([]*models.Prefix, error) {
+	params := ipam.IpamPrefixesListParams{
+		Within:  &parent,
+		Limit:   &pageSize,
+		Context: context.Background(),
+	}
+	var prefixes []*models.Prefix
+	for {
+		res, err := config.ipam.IpamPrefixesList(&params, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot list the prefixes within %s: %v", parent, err)
+		}
+		if res == nil || res.Payload == nil {
+			break
+		}
+		prefixes = append(prefixes, res.Payload.Results...)
+		if len(res.Payload.Results) == 0 || res.Payload.Next == nil || *res.Payload.Next == "" {
+			break
+		}
+		offset := int64(len(prefixes))
+		params.Offset = &offset
+	}
+	return prefixes, nil
+}
+
+// testSweepListIPAddresses lists the IP addresses within the parent, pageSize at a time
+func testSweepListIPAddresses(config *Config, parent string, pageSize int64) ([]*models.IPAddress, error) {
+	params := ipam.IpamIPAddressesListParams{
+		Parent:  &parent,
+		Limit:   &pageSize,
+		Context: context.Background(),
+	}
+	var addresses []*models.IPAddress
+	for {
+		res, err := config.ipam.IpamIPAddressesList(&params, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot list the IP addresses within %s: %v", parent, err)
+		}
+		if res == nil || res.Payload == nil {
+			break
+		}
+		addresses = append(addresses, res.Payload.Results...)
+		if len(res.Payload.Results) == 0 || res.Payload.Next == nil || *res.Payload.Next == "" {
+			break
+		}
+		offset := int64(len(addresses))
+		params.Offset = &offset
+	}
+	return addresses, nil
+}
+
+func testSweepIpamPrefixes(region string) error {
+	c, err := sharedClientForRegion(region)
+	if err != nil {
+		return err
+	}
+	config := c.(*Config)
+
+	parents, err := testSweepParentPrefixes(config)
+	if err != nil {
+		return err
+	}
+
+	for _, parent := range parents {
+		prefixes, err := testSweepListPrefixes(config, parent, NetboxApiGeneralQueryLimit)
+		if err != nil {
+			return err
+		}
+		for _, p := range prefixes {
+			if !isTestAccFixture(p.Description, p.Tags) {
+				continue
+			}
+			log.Printf("[INFO] Sweeping prefix %s with ID %d", *p.Prefix, p.ID)
+			if err := DeletePrefix(config, p.ID); err != nil {
+				log.Printf("[ERROR] %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func testSweepIpamIPAddresses(region string) error {
+	c, err := sharedClientForRegion(region)
+	if err != nil {
+		return err
+	}
+	config := c.(*Config)
+
+	parents, err := testSweepParentPrefixes(config)
+	if err != nil {
+		return err
+	}
+
+	for _, parent := range parents {
+		addresses, err := testSweepListIPAddresses(config, parent, NetboxApiGeneralQueryLimit)
+		if err != nil {
+			return err
+		}
+		for _, ip := range addresses {
+			if !isTestAccFixture(ip.Description, ip.Tags) {
+				continue
+			}
+			log.Printf("[INFO] Sweeping IP address %s with ID %d", *ip.Address, ip.ID)
+			deleteParams := ipam.IpamIPAddressesDeleteParams{
+				ID:      ip.ID,
+				Context: context.Background(),
+			}
+			if _, err := config.ipam.IpamIPAddressesDelete(&deleteParams, nil); err != nil && !isNotFound(err) {
+				log.Printf("[ERROR] Cannot delete IP address %d: %v", ip.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// testSweepIpamVlans sweeps the VLANs assigned to the prefixes within the test parents
+func testSweepIpamVlans(region string) error {
+	c, err := sharedClientForRegion(region)
+	if err != nil {
+		return err
+	}
+	config := c.(*Config)
+
+	parents, err := testSweepParentPrefixes(config)
+	if err != nil {
+		return err
+	}
+
+	vlanIDs := make(map[int64]bool)
+	for _, parent := range parents {
+		prefixes, err := testSweepListPrefixes(config, parent, NetboxApiGeneralQueryLimit)
+		if err != nil {
+			return err
+		}
+		for _, p := range prefixes {
+			if p.Vlan != nil {
+				vlanIDs[p.Vlan.ID] = true
+			}
+		}
+	}
+
+	for id := range vlanIDs {
+		vlanID := strconv.FormatInt(id, 10)
+		params := ipam.IpamVlansListParams{
+			ID:      &vlanID,
+			Context: context.Background(),
+		}
+		res, err := config.ipam.IpamVlansList(&params, nil)
+		if err != nil {
+			return fmt.Errorf("Cannot look up the VLAN %d: %v", id, err)
+		}
+		for _, vlan := range res.Payload.Results {
+			if !isTestAccFixture(vlan.Description, vlan.Tags) {
+				continue
+			}
+			log.Printf("[INFO] Sweeping VLAN %s with ID %d", *vlan.Name, vlan.ID)
+			deleteParams := ipam.IpamVlansDeleteParams{
+				ID:      vlan.ID,
+				Context: context.Background(),
+			}
+			if _, err := config.ipam.IpamVlansDelete(&deleteParams, nil); err != nil && !isNotFound(err) {
+				log.Printf("[ERROR] Cannot delete VLAN %d: %v", vlan.ID, err)
+			}
+		}
+	}
+	config.lookups.invalidate(lookupVlan)
+	return nil
+}
+
+func TestIsTestAccFixture(t *testing.T) {
+	cases := map[string]struct {
+		description string
+		tags        []string
+		expected    bool
+	}{
+		"described":           {description: "testAccDataSourcePrefixConfigById description", expected: true},
+		"random suffix tag":   {tags: []string{"prod", "AvailablePrefix-acczq3k81mf0a-01"}, expected: true},
+		"datasource tag":      {tags: []string{"datasource-AvailablePrefix-acc4kd0s91xbe-07"}, expected: true},
+		"fixed tag":           {tags: []string{"core-acc01", "datasource-AvailablePrefix-accTag07"}, expected: false},
+		"real description":    {description: "uplinks of the testAcc lab", expected: false},
+		"real tag":            {tags: []string{"prod-access", "gcp-acc"}, expected: false},
+		"reservation markers": {tags: []string{"tf-reserved-0123456789abcdef", "tf-workspace-default"}, expected: false},
+	}
+	for tn, tc := range cases {
+		if fixture := isTestAccFixture(tc.description, tc.tags); fixture != tc.expected {
+			t.Fatalf("%s: expected %t, got %t", tn, tc.expected, fixture)
+		}
+	}
+}
+
+// The sweepers page through the fixtures, netbox caps the size of a page
+func TestTestSweepListPaginates(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	f.lock.Lock()
+	for _, cidr := range []string{"10.48.0.0/24", "10.48.1.0/24", "10.48.2.0/24"} {
+		f.seedPrefix(cidr, nil, "active")
+	}
+	f.lock.Unlock()
+	for _, address := range []string{"10.48.0.1/24", "10.48.0.2/24", "10.48.0.3/24"} {
+		f.seedIPAddress(address, nil, testAccDescriptionPrefix)
+	}
+
+	lists := f.requestCount("GET", "/ipam/prefixes/")
+	prefixes, err := testSweepListPrefixes(config, "10.48.0.0/16", 1)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(prefixes) != 3 {
+		t.Fatalf("expected the 3 prefixes, got %d", len(prefixes))
+	}
+	if requests := f.requestCount("GET", "/ipam/prefixes/") - lists; requests < 3 {
+		t.Fatalf("expected the prefixes listed over several pages, got %d requests", requests)
+	}
 
-	return nil, nil
+	lists = f.requestCount("GET", "/ipam/ip-addresses/")
+	addresses, err := testSweepListIPAddresses(config, "10.48.0.0/16", 1)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(addresses) != 3 {
+		t.Fatalf("expected the 3 IP addresses, got %d", len(addresses))
+	}
+	if requests := f.requestCount("GET", "/ipam/ip-addresses/") - lists; requests < 3 {
+		t.Fatalf("expected the IP addresses listed over several pages, got %d requests", requests)
+	}
 }
@@ -0,0 +1,280 @@
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"sort"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/fenglyu/go-netbox/netbox/client/ipam"
+	"github.com/fenglyu/go-netbox/netbox/models"
+)
+
+const (
+	allocationStrategyFirstFit = "first_fit"
+	allocationStrategyLastFit  = "last_fit"
+	allocationStrategyBestFit  = "best_fit"
+	allocationStrategyAligned  = "aligned"
+)
+
+var allocationStrategies = []string{
+	allocationStrategyFirstFit,
+	allocationStrategyLastFit,
+	allocationStrategyBestFit,
+	allocationStrategyAligned,
+}
+
+// cidrSubnet works the same way as the cidrsubnet() function of terraform,
+// it returns the netnum-th subnet of base extended by newbits bits.
+func cidrSubnet(base *net.IPNet, newbits int, netnum *big.Int) (*net.IPNet, error) {
+	ones, bits := base.Mask.Size()
+	newOnes := ones + newbits
+	if newbits < 0 || newOnes > bits {
+		return nil, fmt.Errorf("Cannot extend %s with %d bits, the prefix length must be between %d and %d", base, newbits, ones, bits)
+	}
+
+	maxNum := new(big.Int).Lsh(big.NewInt(1), uint(newbits))
+	if netnum.Sign() < 0 || netnum.Cmp(maxNum) >= 0 {
+		return nil, fmt.Errorf("%s has %s subnets of length /%d, subnet %s does not exist", base, maxNum, newOnes, netnum)
+	}
+
+	ip := ipToInt(base.IP, bits)
+	ip.Or(ip, new(big.Int).Lsh(netnum, uint(bits-newOnes)))
+
+	return &net.IPNet{
+		IP:   intToIP(ip, bits),
+		Mask: net.CIDRMask(newOnes, bits),
+	}, nil
+}
+
+// subnetIndex is the inverse of cidrSubnet, it tells the n-th subnet of its length under base the subnet is.
+// It's false if the subnet isn't within base, or the index doesn't fit an int.
+func subnetIndex(base, subnet *net.IPNet) (int, bool) {
+	ones, bits := base.Mask.Size()
+	subnetOnes, subnetBits := subnet.Mask.Size()
+	if bits != subnetBits || subnetOnes < ones || !base.Contains(subnet.IP) {
+		return 0, false
+	}
+
+	index := new(big.Int).Sub(ipToInt(subnet.IP, bits), ipToInt(base.IP.Mask(base.Mask), bits))
+	index.Rsh(index, uint(bits-subnetOnes))
+	if !index.IsInt64() || index.Int64() > int64(math.MaxInt32) {
+		return 0, false
+	}
+	return int(index.Int64()), true
+}
+
+// isCIDRFree tells whether the candidate lies entirely within one of the free blocks
+func isCIDRFree(candidate *net.IPNet, free []*net.IPNet) bool {
+	candidateOnes, candidateBits := candidate.Mask.Size()
+	for _, f := range free {
+		ones, bits := f.Mask.Size()
+		if bits == candidateBits && ones <= candidateOnes && f.Contains(candidate.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAlignment fails the plan of an aligned prefix without an alignment between 1 and its length,
+// rather than its creation
+func validateAlignment(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if d.Get("allocation_strategy").(string) != allocationStrategyAligned || !d.NewValueKnown("alignment") || !d.NewValueKnown("prefix_length") {
+		return nil
+	}
+	alignment := d.Get("alignment").(int)
+	length := d.Get("prefix_length").(int)
+	if alignment < 1 || length != 0 && alignment > length {
+		return fmt.Errorf("The aligned allocation strategy needs an alignment between 1 and the prefix length %d, got %d", length, alignment)
+	}
+	return nil
+}
+
+// selectFreePrefix picks a block of the given length among the free blocks:
+// first_fit takes the lowest block, last_fit the highest one, best_fit the start of the
+// smallest free block that fits, and aligned the start of the lowest free block of at least /alignment.
+func selectFreePrefix(free []*net.IPNet, length int, strategy string, alignment int) (*net.IPNet, error) {
+	if strategy == allocationStrategyAligned && (alignment < 1 || alignment > length) {
+		return nil, fmt.Errorf("The aligned allocation strategy needs an alignment between 1 and the prefix length %d, got %d", length, alignment)
+	}
+
+	sorted := make([]*net.IPNet, len(free))
+	copy(sorted, free)
+	sort.Slice(sorted, func(i, j int) bool {
+		_, bi := sorted[i].Mask.Size()
+		_, bj := sorted[j].Mask.Size()
+		if bi != bj {
+			return bi < bj
+		}
+		return ipToInt(sorted[i].IP, bi).Cmp(ipToInt(sorted[j].IP, bj)) < 0
+	})
+
+	var chosen *net.IPNet
+	chosenOnes := -1
+	for _, f := range sorted {
+		ones, bits := f.Mask.Size()
+		if length < ones || length > bits {
+			continue
+		}
+		switch strategy {
+		case allocationStrategyLastFit:
+			chosen = f
+		case allocationStrategyBestFit:
+			if ones > chosenOnes {
+				chosen, chosenOnes = f, ones
+			}
+		case allocationStrategyAligned:
+			if ones <= alignment {
+				return firstSubnet(f, length), nil
+			}
+		default:
+			return firstSubnet(f, length), nil
+		}
+	}
+
+	if chosen == nil {
+		if strategy == allocationStrategyAligned {
+			return nil, &insufficientSpaceError{Length: int64(length), Alignment: alignment}
+		}
+		return nil, &insufficientSpaceError{Length: int64(length)}
+	}
+
+	if strategy == allocationStrategyLastFit {
+		ones, _ := chosen.Mask.Size()
+		last := new(big.Int).Lsh(big.NewInt(1), uint(length-ones))
+		return cidrSubnet(chosen, length-ones, last.Sub(last, big.NewInt(1)))
+	}
+	return firstSubnet(chosen, length), nil
+}
+
+// firstSubnet returns the lowest subnet of the given length within the block
+func firstSubnet(block *net.IPNet, length int) *net.IPNet {
+	_, bits := block.Mask.Size()
+	return &net.IPNet{
+		IP:   block.IP.Mask(block.Mask),
+		Mask: net.CIDRMask(length, bits),
+	}
+}
+
+func parseAvailablePrefixes(available []*models.AvailablePrefix) ([]*net.IPNet, error) {
+	free := make([]*net.IPNet, 0, len(available))
+	for _, a := range available {
+		_, ipnet, err := net.ParseCIDR(a.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing available prefix %s: %v", a.Prefix, err)
+		}
+		free = append(free, ipnet)
+	}
+	return free, nil
+}
+
+func ipToInt(ip net.IP, bits int) *big.Int {
+	if bits == 32 {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+	return new(big.Int).SetBytes(ip)
+}
+
+func intToIP(i *big.Int, bits int) net.IP {
+	b := i.Bytes()
+	ip := make(net.IP, bits/8)
+	copy(ip[len(ip)-len(b):], b)
+	return ip
+}
+
+// getSubnetIndex returns the configured subnet_index, 0 being a valid index
+func getSubnetIndex(d *schema.ResourceData) (int, bool) {
+	raw := d.GetRawConfig()
+	if raw.IsNull() || !raw.IsKnown() {
+		return 0, false
+	}
+	if v := raw.GetAttr("subnet_index"); v.IsNull() || !v.IsKnown() {
+		return 0, false
+	}
+	return d.Get("subnet_index").(int), true
+}
+
+// createIpamPrefixAtIndex creates the index-th subnet of the given length under the parent as a static prefix,
+// after checking that the whole block is still free. Callers must hold the lock of the parent prefix.
+func createIpamPrefixAtIndex(config *Config, parentID int64, index int, wPrefix *models.WritablePrefix) (*models.Prefix, error) {
+	parent, err := getIpamPrefixByID(config, parentID)
+	if err != nil {
+		return nil, err
+	}
+	_, parentNet, err := net.ParseCIDR(*parent.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	ones, _ := parentNet.Mask.Size()
+
+	candidate, err := cidrSubnet(parentNet, int(wPrefix.PrefixLength)-ones, big.NewInt(int64(index)))
+	if err != nil {
+		return nil, err
+	}
+
+	available, err := getIpamAvailablePrefixes(config, parentID)
+	if err != nil {
+		return nil, err
+	}
+	free, err := parseAvailablePrefixes(available)
+	if err != nil {
+		return nil, err
+	}
+	if !isCIDRFree(candidate, free) {
+		return nil, fmt.Errorf("Prefix %s, the subnet %d of %s, is not available", candidate, index, parentNet)
+	}
+
+	return createIpamStaticPrefix(config, candidate, parent, wPrefix)
+}
+
+// createIpamPrefixWithStrategy evaluates the free blocks of the parent and creates a prefix of the
+// requested length where the strategy places it. Callers must hold the lock of the parent prefix.
+func createIpamPrefixWithStrategy(config *Config, parentID int64, strategy string, alignment int, wPrefix *models.WritablePrefix) (*models.Prefix, error) {
+	parent, err := getIpamPrefixByID(config, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	available, err := getIpamAvailablePrefixes(config, parentID)
+	if err != nil {
+		return nil, err
+	}
+	free, err := parseAvailablePrefixes(available)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := selectFreePrefix(free, int(wPrefix.PrefixLength), strategy, alignment)
+	if err != nil {
+		return nil, err
+	}
+
+	return createIpamStaticPrefix(config, block, parent, wPrefix)
+}
+
+// createIpamStaticPrefix creates the given block as a prefix within the parent's VRF, unless a VRF is set
+// POST: /ipam/prefixes/
+func createIpamStaticPrefix(config *Config, block *net.IPNet, parent *models.Prefix, wPrefix *models.WritablePrefix) (*models.Prefix, error) {
+	cidr := block.String()
+	wPrefix.Prefix = &cidr
+	if wPrefix.Vrf == nil && parent.Vrf != nil {
+		vrfID := parent.Vrf.ID
+		wPrefix.Vrf = &vrfID
+	}
+
+	params := ipam.IpamPrefixesCreateParams{
+		Data:    wPrefix,
+		Context: context.Background(),
+	}
+	res, err := config.ipam.IpamPrefixesCreate(&params, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create prefix %s: %w", cidr, err)
+	}
+	return res.GetPayload(), nil
+}
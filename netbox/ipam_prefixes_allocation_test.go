@@ -0,0 +1,242 @@
+package netbox
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestCidrSubnet(t *testing.T) {
+	cases := map[string]struct {
+		base     string
+		newbits  int
+		netnum   int64
+		expected string
+		err      bool
+	}{
+		"first ipv4 subnet": {
+			base: "10.0.0.0/16", newbits: 8, netnum: 0, expected: "10.0.0.0/24",
+		},
+		"ipv4 subnet": {
+			base: "10.0.0.0/16", newbits: 8, netnum: 5, expected: "10.0.5.0/24",
+		},
+		"last ipv4 subnet": {
+			base: "10.0.0.0/16", newbits: 12, netnum: 4095, expected: "10.0.255.240/28",
+		},
+		"same length": {
+			base: "192.168.0.0/24", newbits: 0, netnum: 0, expected: "192.168.0.0/24",
+		},
+		"ipv6 subnet": {
+			base: "2001:db8::/32", newbits: 32, netnum: 258, expected: "2001:db8:0:102::/64",
+		},
+		"index out of range": {
+			base: "10.0.0.0/16", newbits: 8, netnum: 256, err: true,
+		},
+		"negative index": {
+			base: "10.0.0.0/16", newbits: 8, netnum: -1, err: true,
+		},
+		"shorter than base": {
+			base: "10.0.0.0/16", newbits: -1, netnum: 0, err: true,
+		},
+		"longer than address": {
+			base: "10.0.0.0/16", newbits: 17, netnum: 0, err: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		_, base, err := net.ParseCIDR(tc.base)
+		if err != nil {
+			t.Fatalf("%s: error parsing %s: %v", tn, tc.base, err)
+		}
+		subnet, err := cidrSubnet(base, tc.newbits, big.NewInt(tc.netnum))
+		if tc.err {
+			if err == nil {
+				t.Fatalf("%s: expected an error, got %s", tn, subnet)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tn, err)
+		}
+		if subnet.String() != tc.expected {
+			t.Fatalf("%s: expected %s, got %s", tn, tc.expected, subnet)
+		}
+	}
+}
+
+func TestIsCIDRFree(t *testing.T) {
+	free := mustParseCIDRs(t, "10.0.1.0/24", "10.0.4.0/22", "2001:db8::/48")
+
+	cases := map[string]struct {
+		candidate string
+		expected  bool
+	}{
+		"free block itself":      {candidate: "10.0.1.0/24", expected: true},
+		"within a free block":    {candidate: "10.0.6.128/25", expected: true},
+		"larger than free block": {candidate: "10.0.0.0/23", expected: false},
+		"allocated":              {candidate: "10.0.2.0/24", expected: false},
+		"ipv6 within":            {candidate: "2001:db8:0:1::/64", expected: true},
+		"ipv6 outside":           {candidate: "2001:db8:1::/64", expected: false},
+	}
+
+	for tn, tc := range cases {
+		candidate := mustParseCIDRs(t, tc.candidate)[0]
+		if free := isCIDRFree(candidate, free); free != tc.expected {
+			t.Fatalf("%s: expected %t, got %t", tn, tc.expected, free)
+		}
+	}
+}
+
+func TestSelectFreePrefix(t *testing.T) {
+	// Out of order on purpose, netbox lists them sorted but the selection must not depend on it
+	free := mustParseCIDRs(t, "10.0.8.0/21", "10.0.1.0/24", "10.0.4.0/23", "10.0.2.128/25")
+
+	cases := map[string]struct {
+		length    int
+		strategy  string
+		alignment int
+		expected  string
+		err       bool
+	}{
+		"first fit":                 {length: 26, strategy: allocationStrategyFirstFit, expected: "10.0.1.0/26"},
+		"default is first fit":      {length: 26, strategy: "", expected: "10.0.1.0/26"},
+		"first fit skips small":     {length: 23, strategy: allocationStrategyFirstFit, expected: "10.0.4.0/23"},
+		"last fit":                  {length: 26, strategy: allocationStrategyLastFit, expected: "10.0.15.192/26"},
+		"last fit whole block":      {length: 21, strategy: allocationStrategyLastFit, expected: "10.0.8.0/21"},
+		"best fit":                  {length: 26, strategy: allocationStrategyBestFit, expected: "10.0.2.128/26"},
+		"best fit larger prefix":    {length: 24, strategy: allocationStrategyBestFit, expected: "10.0.1.0/24"},
+		"best fit exact":            {length: 23, strategy: allocationStrategyBestFit, expected: "10.0.4.0/23"},
+		"aligned":                   {length: 26, strategy: allocationStrategyAligned, alignment: 23, expected: "10.0.4.0/26"},
+		"aligned to itself":         {length: 26, strategy: allocationStrategyAligned, alignment: 26, expected: "10.0.1.0/26"},
+		"aligned to larger block":   {length: 28, strategy: allocationStrategyAligned, alignment: 22, expected: "10.0.8.0/28"},
+		"aligned without room":      {length: 28, strategy: allocationStrategyAligned, alignment: 20, err: true},
+		"aligned without alignment": {length: 28, strategy: allocationStrategyAligned, err: true},
+		"alignment too long":        {length: 24, strategy: allocationStrategyAligned, alignment: 25, err: true},
+		"no room":                   {length: 20, strategy: allocationStrategyFirstFit, err: true},
+		"no room for best fit":      {length: 20, strategy: allocationStrategyBestFit, err: true},
+		"no room for last fit":      {length: 20, strategy: allocationStrategyLastFit, err: true},
+		"longer than address":       {length: 33, strategy: allocationStrategyLastFit, err: true},
+	}
+
+	for tn, tc := range cases {
+		selected, err := selectFreePrefix(free, tc.length, tc.strategy, tc.alignment)
+		if tc.err {
+			if err == nil {
+				t.Fatalf("%s: expected an error, got %s", tn, selected)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tn, err)
+		}
+		if selected.String() != tc.expected {
+			t.Fatalf("%s: expected %s, got %s", tn, tc.expected, selected)
+		}
+	}
+}
+
+func TestValidateAlignment(t *testing.T) {
+	cases := map[string]struct {
+		raw map[string]interface{}
+		err bool
+	}{
+		"aligned":                 {raw: map[string]interface{}{"allocation_strategy": "aligned", "alignment": 24}},
+		"aligned to itself":       {raw: map[string]interface{}{"allocation_strategy": "aligned", "alignment": 28}},
+		"without alignment":       {raw: map[string]interface{}{"allocation_strategy": "aligned"}, err: true},
+		"alignment out of range":  {raw: map[string]interface{}{"allocation_strategy": "aligned", "alignment": 29}, err: true},
+		"other strategy":          {raw: map[string]interface{}{"allocation_strategy": "best_fit"}},
+		"alignment not evaluated": {raw: map[string]interface{}{"allocation_strategy": "last_fit", "alignment": 30}},
+	}
+
+	r := resourceIpamAvailablePrefixes()
+	for tn, tc := range cases {
+		raw := map[string]interface{}{
+			"parent_prefix_id": 10,
+			"prefix_length":    28,
+			"custom_fields":    []interface{}{map[string]interface{}{}},
+		}
+		for k, v := range tc.raw {
+			raw[k] = v
+		}
+		_, err := r.Diff(context.Background(), nil, terraform.NewResourceConfigRaw(raw), nil)
+		if tc.err {
+			if err == nil || !strings.Contains(err.Error(), "needs an alignment between 1 and the prefix length 28") {
+				t.Errorf("%s: expected an alignment error, got %v", tn, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tn, err)
+		}
+	}
+}
+
+// The allocation arguments only apply to the creation, changing them leaves the prefix alone
+func TestAllocationStrategyCreateOnly(t *testing.T) {
+	r := resourceIpamAvailablePrefixes()
+	state := &terraform.InstanceState{
+		ID: "20",
+		Attributes: map[string]string{
+			"id":                  "20",
+			"parent_prefix_id":    "10",
+			"prefix_length":       "28",
+			"prefix":              "10.0.0.0/28",
+			"allocation_strategy": "aligned",
+			"alignment":           "24",
+			"custom_fields.#":     "1",
+		},
+	}
+	unset := &terraform.InstanceState{
+		ID: "20",
+		Attributes: map[string]string{
+			"id":               "20",
+			"parent_prefix_id": "10",
+			"prefix_length":    "28",
+			"prefix":           "10.0.0.0/28",
+			"custom_fields.#":  "1",
+		},
+	}
+	imported := &terraform.InstanceState{
+		ID: "20",
+		Attributes: map[string]string{
+			"id":               "20",
+			"parent_prefix_id": "10",
+			"prefix_length":    "28",
+			"prefix":           "10.0.0.0/28",
+			"subnet_index":     "3",
+			"custom_fields.#":  "1",
+		},
+	}
+	cases := map[string]struct {
+		state *terraform.InstanceState
+		raw   map[string]interface{}
+	}{
+		"alignment changed": {state: state, raw: map[string]interface{}{"allocation_strategy": "aligned", "alignment": 26}},
+		"strategy changed":  {state: state, raw: map[string]interface{}{"allocation_strategy": "best_fit", "alignment": 24}},
+		"strategy removed":  {state: state, raw: map[string]interface{}{}},
+		"explicit default":  {state: unset, raw: map[string]interface{}{"allocation_strategy": "first_fit"}},
+		"fallbacks added":   {state: unset, raw: map[string]interface{}{"fallback_parent_prefix_ids": []interface{}{11, 12}}},
+		"index changed":     {state: imported, raw: map[string]interface{}{"subnet_index": 4}},
+		"index imported":    {state: imported, raw: map[string]interface{}{}},
+	}
+	for tn, tc := range cases {
+		tc.raw["parent_prefix_id"] = 10
+		tc.raw["prefix_length"] = 28
+		tc.raw["custom_fields"] = []interface{}{map[string]interface{}{}}
+		diff, err := r.Diff(context.Background(), tc.state, terraform.NewResourceConfigRaw(tc.raw), nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tn, err)
+			continue
+		}
+		if diff == nil {
+			continue
+		}
+		if diff.RequiresNew() || diff.Attributes["allocation_strategy"] != nil || diff.Attributes["alignment"] != nil || diff.Attributes["fallback_parent_prefix_ids.#"] != nil || diff.Attributes["subnet_index"] != nil {
+			t.Errorf("%s: expected the allocation arguments left alone, got %v", tn, diff.Attributes)
+		}
+	}
+}
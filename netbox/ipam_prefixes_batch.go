@@ -0,0 +1,403 @@
+package netbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fenglyu/go-netbox/netbox/client/ipam"
+	"github.com/fenglyu/go-netbox/netbox/models"
+)
+
+const (
+	// How long a read waits for others to share its request with
+	prefixBatchWindow = 10 * time.Millisecond
+	// A full batch is sent without waiting, netbox answers up to 1000 results by default
+	prefixBatchMaxSize = 100
+	// The parents of a group of prefixes are looked up around a network at most this many bits
+	// shorter than its longest prefix, beyond it the network holds too many unrelated prefixes
+	prefixBatchMaxCoveringBits = 8
+)
+
+// readBatcher merges the reads made within its window into a single call of flush
+type readBatcher struct {
+	window  time.Duration
+	maxSize int
+	// flush reads the items of a batch, keyed like they were passed to get
+	flush func(items map[interface{}]interface{}) map[interface{}]batchResult
+
+	lock    sync.Mutex
+	pending *readBatch
+}
+
+type readBatch struct {
+	items   map[interface{}]interface{}
+	sent    bool
+	done    chan struct{}
+	results map[interface{}]batchResult
+}
+
+type batchResult struct {
+	value interface{}
+	err   error
+}
+
+// get reads item along with the other items of the batch, the reads of the same key share their result
+func (b *readBatcher) get(key, item interface{}) (interface{}, error) {
+	b.lock.Lock()
+	batch := b.pending
+	if batch == nil {
+		batch = &readBatch{items: make(map[interface{}]interface{}), done: make(chan struct{})}
+		b.pending = batch
+		time.AfterFunc(b.window, func() { b.send(batch) })
+	}
+	batch.items[key] = item
+	if len(batch.items) >= b.maxSize {
+		b.pending = nil
+		go b.send(batch)
+	}
+	b.lock.Unlock()
+
+	<-batch.done
+	result := batch.results[key]
+	return result.value, result.err
+}
+
+// send flushes the batch once, be it full or its window elapsed
+func (b *readBatcher) send(batch *readBatch) {
+	b.lock.Lock()
+	if batch.sent {
+		b.lock.Unlock()
+		return
+	}
+	batch.sent = true
+	if b.pending == batch {
+		b.pending = nil
+	}
+	b.lock.Unlock()
+
+	batch.results = b.flush(batch.items)
+	close(batch.done)
+}
+
+// prefixReadBatcher merges the concurrent reads of prefixes and of their parents, as made by the
+// refresh of many netbox_available_prefixes, into a single id__in request and a single parent query.
+// A nil batcher reads every prefix on its own.
+type prefixReadBatcher struct {
+	prefixes *readBatcher
+	parents  *readBatcher
+}
+
+func newPrefixReadBatcher(config *Config) *prefixReadBatcher {
+	return &prefixReadBatcher{
+		prefixes: &readBatcher{
+			window:  prefixBatchWindow,
+			maxSize: prefixBatchMaxSize,
+			flush: func(items map[interface{}]interface{}) map[interface{}]batchResult {
+				return readIpamPrefixesByID(config, items)
+			},
+		},
+		parents: &readBatcher{
+			window:  prefixBatchWindow,
+			maxSize: prefixBatchMaxSize,
+			flush: func(items map[interface{}]interface{}) map[interface{}]batchResult {
+				return readIpamParentPrefixes(config, items)
+			},
+		},
+	}
+}
+
+func (b *prefixReadBatcher) prefix(config *Config, id int64) (*models.Prefix, error) {
+	if b == nil {
+		return getIpamPrefixByID(config, id)
+	}
+	prefix, err := b.prefixes.get(id, id)
+	if err != nil {
+		return nil, err
+	}
+	p, _ := prefix.(*models.Prefix)
+	return p, nil
+}
+
+func (b *prefixReadBatcher) parent(config *Config, prefix *models.Prefix) (*models.Prefix, error) {
+	if b == nil {
+		return getIpamParentPrefix(config, prefix)
+	}
+	parent, err := b.parents.get(prefix.ID, prefix)
+	if err != nil {
+		return nil, err
+	}
+	p, _ := parent.(*models.Prefix)
+	return p, nil
+}
+
+// readIpamPrefixesByID reads the prefixes of a batch with a single id__in request. A batch of one
+// is read like any other prefix. The prefixes missing from the list are reported not found.
+func readIpamPrefixesByID(config *Config, items map[interface{}]interface{}) map[interface{}]batchResult {
+	results := make(map[interface{}]batchResult, len(items))
+	ids := make([]string, 0, len(items))
+	for key := range items {
+		ids = append(ids, strconv.FormatInt(key.(int64), 10))
+	}
+	sort.Strings(ids)
+
+	if len(items) == 1 {
+		for key := range items {
+			prefix, err := getIpamPrefixByID(config, key.(int64))
+			results[key] = batchResult{value: prefix, err: err}
+		}
+		return results
+	}
+
+	idIn := strings.Join(ids, ",")
+	limit := int64(len(items))
+	params := ipam.IpamPrefixesListParams{
+		ID:      &idIn,
+		Limit:   &limit,
+		Context: context.Background(),
+	}
+	res, err := config.ipam.IpamPrefixesList(&params, nil, nil)
+	if err == nil && (res == nil || res.Payload == nil) {
+		err = fmt.Errorf("Cannot list the prefixes with ID %s", idIn)
+	}
+	if err != nil {
+		for key := range items {
+			results[key] = batchResult{err: fmt.Errorf("Cannot determine prefix with ID %d: %w", key.(int64), err)}
+		}
+		return results
+	}
+
+	for _, prefix := range res.Payload.Results {
+		results[prefix.ID] = batchResult{value: prefix}
+	}
+	for key := range items {
+		id := key.(int64)
+		if _, ok := results[id]; !ok {
+			notFound := &NetboxAPIError{
+				Method:     http.MethodGet,
+				Path:       fmt.Sprintf("%s/ipam/prefixes/%d/", config.BasePath, id),
+				StatusCode: http.StatusNotFound,
+				Detail:     "Not found.",
+			}
+			results[key] = batchResult{err: fmt.Errorf("Cannot determine prefix with ID %d: %w", id, notFound)}
+		}
+	}
+	return results
+}
+
+// readIpamParentPrefixes looks up the parents of the prefixes of a batch. The contains filter of netbox
+// takes a single prefix, so the nearby prefixes are grouped under a bounded covering network, and the
+// parents are picked out of the prefixes containing it, and of the ones within it. A prefix with no
+// other one nearby is looked up on its own.
+func readIpamParentPrefixes(config *Config, items map[interface{}]interface{}) map[interface{}]batchResult {
+	results := make(map[interface{}]batchResult, len(items))
+	if len(items) == 1 {
+		for key, item := range items {
+			parent, err := getIpamParentPrefix(config, item.(*models.Prefix))
+			results[key] = batchResult{value: parent, err: err}
+		}
+		return results
+	}
+
+	var networks []*net.IPNet
+	var keys []interface{}
+	for key, item := range items {
+		prefix := item.(*models.Prefix)
+		_, network, err := net.ParseCIDR(*prefix.Prefix)
+		if err != nil {
+			results[key] = batchResult{err: err}
+			continue
+		}
+		networks = append(networks, network)
+		keys = append(keys, key)
+	}
+
+	for _, g := range groupNetworks(networks, prefixBatchMaxCoveringBits) {
+		if len(g.members) == 1 {
+			// No other prefix nearby, a query around it would cost more than its own lookup
+			key := keys[g.members[0]]
+			parent, err := getIpamParentPrefix(config, items[key].(*models.Prefix))
+			results[key] = batchResult{value: parent, err: err}
+			continue
+		}
+		candidates, complete, err := listIpamPrefixesAround(config, g.covering)
+		for _, i := range g.members {
+			key := keys[i]
+			prefix := items[key].(*models.Prefix)
+			switch {
+			case err != nil:
+				results[key] = batchResult{err: err}
+			case !complete:
+				// Too many prefixes around the group, look its parents up one by one
+				parent, err := getIpamParentPrefix(config, prefix)
+				results[key] = batchResult{value: parent, err: err}
+			default:
+				results[key] = batchResult{value: selectParentPrefix(prefix, candidates)}
+			}
+		}
+	}
+	return results
+}
+
+// listIpamPrefixesAround lists the prefixes of any VRF containing the network, and the ones within it.
+// It tells whether netbox listed them all in one page.
+func listIpamPrefixesAround(config *Config, network *net.IPNet) ([]*models.Prefix, bool, error) {
+	cidr := network.String()
+	var candidates []*models.Prefix
+	for _, params := range []ipam.IpamPrefixesListParams{
+		{Contains: &cidr, Limit: &NetboxApiGeneralQueryLimit, Context: context.Background()},
+		{Within: &cidr, Limit: &NetboxApiGeneralQueryLimit, Context: context.Background()},
+	} {
+		params := params
+		res, err := config.ipam.IpamPrefixesList(&params, nil, nil)
+		if err != nil {
+			return nil, false, fmt.Errorf("Cannot look up the parents of the prefixes within %s: %w", cidr, err)
+		}
+		if res == nil || res.Payload == nil {
+			continue
+		}
+		if res.Payload.Count != nil && *res.Payload.Count > int64(len(res.Payload.Results)) {
+			return nil, false, nil
+		}
+		candidates = append(candidates, res.Payload.Results...)
+	}
+	return candidates, true, nil
+}
+
+// networkGroup is a set of networks, given by their index, and the smallest network covering them
+type networkGroup struct {
+	members  []int
+	covering *net.IPNet
+}
+
+// groupNetworks groups the networks of the same family in address order, as long as the network
+// covering a group is at most maxBits shorter than the longest network of the group
+func groupNetworks(networks []*net.IPNet, maxBits int) []networkGroup {
+	order := make([]int, len(networks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := networks[order[i]], networks[order[j]]
+		if len(a.IP) != len(b.IP) {
+			return len(a.IP) < len(b.IP)
+		}
+		if c := bytes.Compare(a.IP, b.IP); c != 0 {
+			return c < 0
+		}
+		aOnes, _ := a.Mask.Size()
+		bOnes, _ := b.Mask.Size()
+		return aOnes < bOnes
+	})
+
+	var groups []networkGroup
+	var members []*net.IPNet
+	for _, i := range order {
+		network := networks[i]
+		if len(groups) > 0 && len(members[0].IP) == len(network.IP) {
+			grown := append(append([]*net.IPNet{}, members...), network)
+			covering := coveringNetwork(grown)
+			if ones, _ := covering.Mask.Size(); ones >= longestMask(grown)-maxBits {
+				last := &groups[len(groups)-1]
+				last.members = append(last.members, i)
+				last.covering = covering
+				members = grown
+				continue
+			}
+		}
+		groups = append(groups, networkGroup{members: []int{i}, covering: network})
+		members = []*net.IPNet{network}
+	}
+	return groups
+}
+
+func longestMask(networks []*net.IPNet) int {
+	longest := 0
+	for _, network := range networks {
+		if ones, _ := network.Mask.Size(); ones > longest {
+			longest = ones
+		}
+	}
+	return longest
+}
+
+// coveringNetwork returns the smallest network containing all the networks, which are of the same family
+func coveringNetwork(networks []*net.IPNet) *net.IPNet {
+	bits := len(networks[0].IP) * 8
+	for ones, _ := networks[0].Mask.Size(); ones > 0; ones-- {
+		mask := net.CIDRMask(ones, bits)
+		covering := &net.IPNet{IP: networks[0].IP.Mask(mask), Mask: mask}
+		if coversNetworks(covering, networks) {
+			return covering
+		}
+	}
+	return &net.IPNet{IP: make(net.IP, len(networks[0].IP)), Mask: net.CIDRMask(0, bits)}
+}
+
+func coversNetworks(covering *net.IPNet, networks []*net.IPNet) bool {
+	ones, _ := covering.Mask.Size()
+	for _, network := range networks {
+		if o, _ := network.Mask.Size(); o < ones || !covering.Contains(network.IP) {
+			return false
+		}
+	}
+	return true
+}
+
+// selectParentPrefix returns the nearest of the candidates containing the prefix, or nil when the
+// prefix is a top level prefix. Like netbox does, a prefix of a VRF nests under the prefixes of the VRF
+// and of the global table, the VRF winning a tie, and a global prefix under the global ones only.
+func selectParentPrefix(prefix *models.Prefix, candidates []*models.Prefix) *models.Prefix {
+	_, network, err := net.ParseCIDR(*prefix.Prefix)
+	if err != nil {
+		return nil
+	}
+	ones, _ := network.Mask.Size()
+
+	var parent *models.Prefix
+	parentOnes := -1
+	for _, candidate := range candidates {
+		if candidate.Prefix == nil || candidate.ID == prefix.ID {
+			continue
+		}
+		if candidate.Vrf != nil && (prefix.Vrf == nil || candidate.Vrf.ID != prefix.Vrf.ID) {
+			continue
+		}
+		_, c, err := net.ParseCIDR(*candidate.Prefix)
+		if err != nil || len(c.IP) != len(network.IP) {
+			continue
+		}
+		cOnes, _ := c.Mask.Size()
+		if cOnes >= ones || !c.Contains(network.IP) {
+			continue
+		}
+		if cOnes > parentOnes || cOnes == parentOnes && candidate.Vrf != nil {
+			parent, parentOnes = candidate, cOnes
+		}
+	}
+	return parent
+}
+
+// netboxQueryTransport turns a comma separated id filter into id__in, which the generated client has no parameter for
+type netboxQueryTransport struct {
+	next http.RoundTripper
+}
+
+func (t *netboxQueryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	q := req.URL.Query()
+	if id := q.Get("id"); !strings.Contains(id, ",") {
+		return t.next.RoundTrip(req)
+	}
+	q.Set("id__in", q.Get("id"))
+	q.Del("id")
+	req = req.Clone(req.Context())
+	req.URL.RawQuery = q.Encode()
+	return t.next.RoundTrip(req)
+}
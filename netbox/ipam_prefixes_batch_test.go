@@ -0,0 +1,237 @@
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestPrefixReadBatcher_refresh(t *testing.T) {
+	fake := newFakeNetbox()
+	defer fake.Close()
+
+	fake.lock.Lock()
+	vrf := fake.vrfs[0]
+	container := fake.seedPrefix("10.1.0.0/16", nil, "container")
+	expected := map[int64]int64{
+		fake.seedPrefix("10.1.0.0/24", nil, "active").id:   container.id,
+		fake.seedPrefix("10.1.1.0/24", nil, "active").id:   container.id,
+		fake.seedPrefix("10.2.0.0/24", nil, "active").id:   fake.parentPrefixID,
+		fake.seedPrefix("10.3.0.0/26", nil, "active").id:   fake.parentPrefixID,
+		fake.seedPrefix("172.16.0.0/24", vrf, "active").id: fake.parentPrefixWithVrfID,
+		fake.seedPrefix("172.16.9.0/24", vrf, "active").id: fake.parentPrefixWithVrfID,
+	}
+	fake.lock.Unlock()
+
+	config, err := fake.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	parents := make(map[int64]int64)
+	for id := range expected {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			d := schema.TestResourceDataRaw(t, resourceIpamAvailablePrefixes().Schema, map[string]interface{}{
+				"parent_prefix_id": 1,
+				"prefix_length":    24,
+			})
+			d.SetId(strconv.FormatInt(id, 10))
+			if diags := resourceIpamAvailablePrefixesRead(context.Background(), d, config); diags.HasError() {
+				t.Errorf("prefix %d: %v", id, diags)
+				return
+			}
+			lock.Lock()
+			parents[id] = int64(d.Get("parent_prefix_id").(int))
+			lock.Unlock()
+		}(id)
+	}
+	wg.Wait()
+
+	for id, parentID := range expected {
+		if parents[id] != parentID {
+			t.Errorf("prefix %d: expected parent %d, got %d", id, parentID, parents[id])
+		}
+	}
+	if n := fake.requestCount(http.MethodGet, "/ipam/prefixes/{id}/"); n != 0 {
+		t.Errorf("expected no single prefix reads, got %d", n)
+	}
+	// One id__in request, the contains and within queries of the 10.1.0.0/23 and 172.16.0.0/20 groups,
+	// and the parents of 10.2.0.0/24 and 10.3.0.0/26 looked up on their own
+	if n := fake.requestCount(http.MethodGet, "/ipam/prefixes/"); n != 7 {
+		t.Errorf("expected 7 prefix lists, got %d", n)
+	}
+}
+
+func TestPrefixReadBatcher_notFound(t *testing.T) {
+	fake := newFakeNetbox()
+	defer fake.Close()
+	config, err := fake.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(map[int64]error)
+	var lock sync.Mutex
+	for _, id := range []int64{fake.parentPrefixID, 9999} {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			_, err := config.prefixReads.prefix(config, id)
+			lock.Lock()
+			errs[id] = err
+			lock.Unlock()
+		}(id)
+	}
+	wg.Wait()
+
+	if errs[fake.parentPrefixID] != nil {
+		t.Errorf("expected prefix %d, got %v", fake.parentPrefixID, errs[fake.parentPrefixID])
+	}
+	apiErr, ok := asNetboxAPIError(errs[9999])
+	if !ok || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a 404 for prefix 9999, got %v", errs[9999])
+	}
+	if n := fake.requestCount(http.MethodGet, "/ipam/prefixes/"); n != 1 {
+		t.Errorf("expected 1 prefix list, got %d", n)
+	}
+}
+
+func TestReadBatcher_maxSize(t *testing.T) {
+	var lock sync.Mutex
+	var batches []int
+	b := &readBatcher{
+		window:  prefixBatchWindow,
+		maxSize: 3,
+		flush: func(items map[interface{}]interface{}) map[interface{}]batchResult {
+			lock.Lock()
+			batches = append(batches, len(items))
+			lock.Unlock()
+			results := make(map[interface{}]batchResult, len(items))
+			for key, item := range items {
+				results[key] = batchResult{value: item}
+			}
+			return results
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 7; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if v, err := b.get(i, fmt.Sprintf("item %d", i)); err != nil || v != fmt.Sprintf("item %d", i) {
+				t.Errorf("%d: unexpected %v, %v", i, v, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, size := range batches {
+		if size > 3 {
+			t.Errorf("batch of %d items, expected at most 3", size)
+		}
+		total += size
+	}
+	if total != 7 || len(batches) < 3 {
+		t.Errorf("expected 7 items in at least 3 batches, got %v", batches)
+	}
+}
+
+func TestCoveringNetwork(t *testing.T) {
+	cases := map[string]struct {
+		networks []string
+		expected string
+	}{
+		"single":   {[]string{"10.1.0.0/24"}, "10.1.0.0/24"},
+		"siblings": {[]string{"10.1.0.0/24", "10.1.1.0/24"}, "10.1.0.0/23"},
+		"nested":   {[]string{"10.1.0.0/24", "10.1.0.0/16"}, "10.1.0.0/16"},
+		"apart":    {[]string{"10.1.0.0/24", "10.3.0.0/26"}, "10.0.0.0/14"},
+		"halves":   {[]string{"0.0.0.0/1", "128.0.0.0/1"}, "0.0.0.0/0"},
+		"ipv6":     {[]string{"2001:db8::/64", "2001:db8:0:1::/64"}, "2001:db8::/63"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var networks []*net.IPNet
+			for _, cidr := range c.networks {
+				_, network, _ := net.ParseCIDR(cidr)
+				networks = append(networks, network)
+			}
+			if covering := coveringNetwork(networks).String(); covering != c.expected {
+				t.Errorf("expected %s, got %s", c.expected, covering)
+			}
+		})
+	}
+}
+
+func TestGroupNetworks(t *testing.T) {
+	cases := map[string]struct {
+		networks []string
+		expected []string
+	}{
+		"siblings": {[]string{"10.1.1.0/24", "10.1.0.0/24"}, []string{"10.1.0.0/23"}},
+		"nested":   {[]string{"10.1.0.0/24", "10.1.0.0/16"}, []string{"10.1.0.0/16"}},
+		"apart":    {[]string{"10.1.0.0/24", "10.3.0.0/26"}, []string{"10.1.0.0/24", "10.3.0.0/26"}},
+		"spread":   {[]string{"10.1.0.0/24", "10.1.1.0/24", "172.16.0.0/24", "192.168.0.0/24"}, []string{"10.1.0.0/23", "172.16.0.0/24", "192.168.0.0/24"}},
+		"halves":   {[]string{"0.0.0.0/1", "128.0.0.0/1"}, []string{"0.0.0.0/0"}},
+		"families": {[]string{"2001:db8:0:1::/64", "10.1.0.0/24", "2001:db8::/64"}, []string{"10.1.0.0/24", "2001:db8::/63"}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var networks []*net.IPNet
+			for _, cidr := range c.networks {
+				_, network, _ := net.ParseCIDR(cidr)
+				networks = append(networks, network)
+			}
+			var coverings []string
+			members := 0
+			for _, g := range groupNetworks(networks, prefixBatchMaxCoveringBits) {
+				coverings = append(coverings, g.covering.String())
+				members += len(g.members)
+			}
+			if !reflect.DeepEqual(coverings, c.expected) {
+				t.Errorf("expected %v, got %v", c.expected, coverings)
+			}
+			if members != len(networks) {
+				t.Errorf("expected %d grouped networks, got %d", len(networks), members)
+			}
+		})
+	}
+}
+
+func TestNetboxQueryTransport(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &netboxQueryTransport{next: http.DefaultTransport}}
+	for _, query := range []string{"id=1%2C2%2C3&limit=3", "id=1", "contains=10.0.0.0%2F24"} {
+		res, err := client.Get(server.URL + "/api/ipam/prefixes/?" + query)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		res.Body.Close()
+	}
+
+	expected := []string{"id__in=1%2C2%2C3&limit=3", "id=1", "contains=10.0.0.0%2F24"}
+	for i := range expected {
+		if queries[i] != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], queries[i])
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+
+	"github.com/fenglyu/go-netbox/netbox/models"
+)
+
+// prefixFilters are the arguments of the netbox_prefixes data source which filter the prefixes listed.
+// id, limit and offset are left out, the prefixes are all paged through, and so are include_usage and the outputs.
+var prefixFilters = []string{
+	"contains",
+	"family",
+	"id_in",
+	"is_pool",
+	"mask_length",
+	"prefix",
+	"q",
+	"role",
+	"role_id",
+	"site",
+	"site_id",
+	"status",
+	"tag",
+	"tenant",
+	"tenant_id",
+	"vlan_id",
+	"vlan_vid",
+	"vrf",
+	"vrf_id",
+	"within",
+	"within_include",
+}
+
+// PrefixFilters returns the filters of the netbox_prefixes data source with their descriptions
+func PrefixFilters() map[string]string {
+	dsSchema := dataSourceIpamPrefixes().Schema
+	filters := make(map[string]string, len(prefixFilters))
+	for _, name := range prefixFilters {
+		filters[name] = dsSchema[name].Description
+	}
+	return filters
+}
+
+// WritePrefixImports writes an import block and a netbox_available_prefixes resource for every prefix
+// matching the filters, which are the ones of the netbox_prefixes data source, e.g. {"tag": "gke"}.
+// The prefixes are imported under their parent by ID, the top level prefixes are skipped.
+// It returns the number of prefixes written.
+func WritePrefixImports(config *Config, filters map[string]string, w io.Writer) (int, error) {
+	return writePrefixImports(config, filters, NetboxApiGeneralQueryLimit, w)
+}
+
+// writePrefixImports writes the imports of the prefixes, listed pageSize at a time
+func writePrefixImports(config *Config, filters map[string]string, pageSize int64, w io.Writer) (int, error) {
+	known := PrefixFilters()
+	attributes := make(map[string]string, len(filters))
+	for name, value := range filters {
+		if _, ok := known[name]; !ok {
+			return 0, fmt.Errorf("Unknown filter %s", name)
+		}
+		attributes[name] = value
+	}
+	d := dataSourceIpamPrefixes().Data(&terraform.InstanceState{Attributes: attributes})
+	params, err := expandIpamPrefixesListParams(d)
+	if err != nil {
+		return 0, err
+	}
+	params.Context = context.Background()
+
+	// Page through all the prefixes
+	params.Limit = &pageSize
+	var prefixes []*models.Prefix
+	for {
+		res, err := config.ipam.IpamPrefixesList(params, nil, nil)
+		if err != nil {
+			return 0, fmt.Errorf("Cannot list the prefixes: %w", err)
+		}
+		if res == nil || res.Payload == nil {
+			break
+		}
+		prefixes = append(prefixes, res.Payload.Results...)
+		if len(res.Payload.Results) == 0 || res.Payload.Next == nil || *res.Payload.Next == "" {
+			break
+		}
+		offset := int64(len(prefixes))
+		params.Offset = &offset
+	}
+
+	written := 0
+	for _, prefix := range prefixes {
+		if prefix.Prefix == nil {
+			continue
+		}
+		parent, err := getIpamParentPrefix(config, prefix)
+		if err != nil {
+			return written, err
+		}
+		if parent == nil {
+			if _, err := fmt.Fprintf(w, "# %s with ID %d has no parent prefix, skipped\n\n", *prefix.Prefix, prefix.ID); err != nil {
+				return written, err
+			}
+			continue
+		}
+		if _, err := io.WriteString(w, prefixImportHCL(prefix, parent)); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// prefixImportHCL renders the import block and the resource of a prefix carved under parent
+func prefixImportHCL(prefix, parent *models.Prefix) string {
+	name := fmt.Sprintf("prefix_%d", prefix.ID)
+	_, network, _ := net.ParseCIDR(*prefix.Prefix)
+	prefixLength, _ := network.Mask.Size()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "import {\n  to = netbox_available_prefixes.%s\n  id = %s\n}\n\n", name, hclString(fmt.Sprintf("%d/%d", prefix.ID, parent.ID)))
+	fmt.Fprintf(&b, "# %s\n", *prefix.Prefix)
+	fmt.Fprintf(&b, "resource \"netbox_available_prefixes\" %s {\n", hclString(name))
+	fmt.Fprintf(&b, "  parent_prefix_id = %d\n", parent.ID)
+	fmt.Fprintf(&b, "  prefix_length    = %d\n", prefixLength)
+	if prefix.Status != nil && prefix.Status.Value != nil {
+		fmt.Fprintf(&b, "  status           = %s\n", hclString(*prefix.Status.Value))
+	}
+	fmt.Fprintf(&b, "  is_pool          = %t\n", prefix.IsPool != nil && *prefix.IsPool)
+	if prefix.Description != "" {
+		fmt.Fprintf(&b, "  description      = %s\n", hclString(prefix.Description))
+	}
+
+	related := make(map[string]*string)
+	if prefix.Role != nil {
+		related["role"] = prefix.Role.Name
+	}
+	if prefix.Site != nil {
+		related["site"] = prefix.Site.Name
+	}
+	if prefix.Tenant != nil {
+		related["tenant"] = prefix.Tenant.Name
+	}
+	if prefix.Vlan != nil {
+		related["vlan"] = prefix.Vlan.Name
+	}
+	if prefix.Vrf != nil {
+		related["vrf"] = prefix.Vrf.Name
+	}
+	for _, key := range []string{"role", "site", "tenant", "vlan", "vrf"} {
+		if name := related[key]; name != nil {
+			fmt.Fprintf(&b, "  %-16s = %s\n", key, hclString(*name))
+		}
+	}
+
+	if tags := flattenTags(prefix.Tags); len(tags) > 0 {
+		quoted := make([]string, len(tags))
+		for i, t := range tags {
+			quoted[i] = hclString(t)
+		}
+		fmt.Fprintf(&b, "  tags             = [%s]\n", strings.Join(quoted, ", "))
+	}
+
+	// The block is always written, like the configs the provider is tested with
+	b.WriteString("\n  custom_fields {\n")
+	if cfs := flatterCustomFields(nil, prefix.CustomFields); len(cfs) == 1 {
+		names := make([]string, 0, len(cfs[0]))
+		for name, value := range cfs[0] {
+			if value != nil {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, "    %-12s = %s\n", name, hclString(fmt.Sprint(cfs[0][name])))
+		}
+	}
+	b.WriteString("  }\n}\n\n")
+	return b.String()
+}
+
+// hclString quotes s as an HCL string, which interpolates ${ and %{ sequences unless they are doubled
+func hclString(s string) string {
+	quoted := strconv.Quote(s)
+	quoted = strings.ReplaceAll(quoted, "${", "$${")
+	return strings.ReplaceAll(quoted, "%{", "%%{")
+}
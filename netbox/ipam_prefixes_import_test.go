@@ -0,0 +1,116 @@
+package netbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWritePrefixImports(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	f.lock.Lock()
+	f.ensureTag("gke")
+	f.ensureTag(ownershipTag("prod"))
+	described := f.seedPrefix("10.1.0.0/24", f.vrfs[0], "active")
+	described.site, described.tenant, described.role, described.vlan = f.sites[0], f.tenants[0], f.roles[0], f.vlans[0]
+	described.isPool = true
+	described.description = `uplinks of "${var.site}"`
+	described.tags = []string{"gke", ownershipTag("prod")}
+	described.customFields["helpers"] = "10.1.0.1"
+	plain := f.seedPrefix("10.2.0.0/24", nil, "reserved")
+	plain.tags = []string{"gke"}
+	topLevel := f.seedPrefix("192.168.0.0/16", nil, "container")
+	topLevel.tags = []string{"gke"}
+	f.seedPrefix("10.3.0.0/24", nil, "active")
+	f.lock.Unlock()
+
+	// Page by page
+	var out bytes.Buffer
+	written, err := writePrefixImports(config, map[string]string{"tag": "gke"}, 2, &out)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if written != 2 {
+		t.Errorf("expected 2 prefixes written, got %d", written)
+	}
+	// Two pages of the 3 tagged prefixes, and the parent lookup of each
+	if n := f.requestCount(http.MethodGet, "/ipam/prefixes/"); n != 5 {
+		t.Errorf("expected 5 prefix lists, got %d", n)
+	}
+
+	expected := fmt.Sprintf(`import {
+  to = netbox_available_prefixes.prefix_%[1]d
+  id = "%[1]d/%[2]d"
+}
+
+# 10.1.0.0/24
+resource "netbox_available_prefixes" "prefix_%[1]d" {
+  parent_prefix_id = %[2]d
+  prefix_length    = 24
+  status           = "active"
+  is_pool          = true
+  description      = "uplinks of \"$${var.site}\""
+  role             = "gcp"
+  site             = "se1"
+  tenant           = "cloud"
+  vlan             = "gcp"
+  vrf              = "activision"
+  tags             = ["gke"]
+
+  custom_fields {
+    helpers      = "10.1.0.1"
+  }
+}
+`, described.id, f.parentPrefixID)
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("expected\n%s\nin\n%s", expected, out.String())
+	}
+	if !strings.Contains(out.String(), fmt.Sprintf("id = \"%d/%d\"", plain.id, f.parentPrefixID)) {
+		t.Errorf("expected prefix %d imported, got\n%s", plain.id, out.String())
+	}
+	if !strings.Contains(out.String(), fmt.Sprintf("# 192.168.0.0/16 with ID %d has no parent prefix, skipped", topLevel.id)) {
+		t.Errorf("expected the top level prefix skipped, got\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "10.3.0.0/24") {
+		t.Errorf("expected the untagged prefix left out, got\n%s", out.String())
+	}
+
+	// The import ID resolves the same prefix and parent
+	d := resourceIpamAvailablePrefixes().Data(nil)
+	d.SetId(fmt.Sprintf("%d/%d", described.id, f.parentPrefixID))
+	imported, err := resourceIpamAvailablePrefixesImportState(context.Background(), d, config)
+	if err != nil || imported[0].Get("parent_prefix_id").(int) != int(f.parentPrefixID) {
+		t.Errorf("expected the prefix imported under %d, got %v", f.parentPrefixID, err)
+	}
+}
+
+func TestWritePrefixImportsUnknownFilter(t *testing.T) {
+	// Neither the paging, the usage nor the outputs of the data source filter the prefixes
+	for _, name := range []string{"workspace", "name", "id", "limit", "offset", "include_usage", "prefixes"} {
+		m := &mockNetbox{}
+		if _, err := WritePrefixImports(m.config(), map[string]string{name: "1"}, &bytes.Buffer{}); err == nil || err.Error() != "Unknown filter "+name {
+			t.Errorf("%s: expected an unknown filter, got %v", name, err)
+		}
+		if len(m.calls) != 0 {
+			t.Errorf("%s: expected no request, got %v", name, m.calls)
+		}
+	}
+}
+
+func TestPrefixFilters(t *testing.T) {
+	dsSchema := dataSourceIpamPrefixes().Schema
+	for name := range PrefixFilters() {
+		if s, ok := dsSchema[name]; !ok || !s.Optional || s.Computed || s.Default != nil {
+			t.Errorf("expected %s to be an optional argument of the data source", name)
+		}
+	}
+}
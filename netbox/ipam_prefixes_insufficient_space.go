@@ -0,0 +1,127 @@
+package netbox
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/go-openapi/runtime"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// insufficientSpaceError tells that a parent prefix has no free block for the requested prefix
+type insufficientSpaceError struct {
+	Length    int64
+	Alignment int
+}
+
+func (e *insufficientSpaceError) Error() string {
+	if e.Alignment > 0 {
+		return fmt.Sprintf("Insufficient space is available to accommodate the requested prefix size(s) \"/%d\" aligned to /%d", e.Length, e.Alignment)
+	}
+	return fmt.Sprintf("Insufficient space is available to accommodate the requested prefix size(s) \"/%d\"", e.Length)
+}
+
+// isInsufficientSpace tells whether netbox refused to allocate a prefix because its parent is full.
+// NetBox 2.8 answers the allocation with a 204 carrying {"detail": "Insufficient space ..."},
+// although a 204 response can't have a body, later versions answer with a 409.
+func isInsufficientSpace(err error) bool {
+	var spaceErr *insufficientSpaceError
+	if errors.As(err, &spaceErr) {
+		return true
+	}
+	if apiErr, ok := asNetboxAPIError(err); ok {
+		switch apiErr.StatusCode {
+		case http.StatusNoContent:
+			return true
+		case http.StatusConflict:
+			return strings.Contains(apiErr.Detail, "Insufficient space")
+		}
+		return false
+	}
+	// Without the error transport, the client reports the 204 as an unexpected success response
+	var clientErr *runtime.APIError
+	return errors.As(err, &clientErr) && clientErr.Code == http.StatusNoContent
+}
+
+// expandFallbackParentIDs returns the parent prefixes to try in order after the parent prefix
+func expandFallbackParentIDs(d *schema.ResourceData) []int64 {
+	var ids []int64
+	for _, v := range d.Get("fallback_parent_prefix_ids").([]interface{}) {
+		ids = append(ids, int64(v.(int)))
+	}
+	return ids
+}
+
+// uniqueParentIDs drops the parent prefixes listed more than once, keeping the first occurrence
+func uniqueParentIDs(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	unique := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+func isFallbackParentID(d *schema.ResourceData, id int64) bool {
+	for _, fallback := range expandFallbackParentIDs(d) {
+		if fallback == id {
+			return true
+		}
+	}
+	return false
+}
+
+// describeFreeSpace sums up the free blocks within a parent prefix, e.g.
+// "10.0.0.0/16 (ID 10): 384 addresses free in 2 block(s), the largest one is a /24"
+func describeFreeSpace(config *Config, parentID int64) string {
+	parent, err := getIpamPrefixByID(config, parentID)
+	if err != nil {
+		return fmt.Sprintf("prefix %d: the free space is unknown, %v", parentID, err)
+	}
+	available, err := getIpamAvailablePrefixes(config, parentID)
+	if err != nil {
+		return fmt.Sprintf("%s (ID %d): the free space is unknown, %v", *parent.Prefix, parentID, err)
+	}
+	free, err := parseAvailablePrefixes(available)
+	if err != nil {
+		return fmt.Sprintf("%s (ID %d): the free space is unknown, %v", *parent.Prefix, parentID, err)
+	}
+	if len(free) == 0 {
+		return fmt.Sprintf("%s (ID %d): no free space", *parent.Prefix, parentID)
+	}
+
+	total := new(big.Int)
+	largest := 128
+	for _, f := range free {
+		total.Add(total, cidrSize(f))
+		if ones, _ := f.Mask.Size(); ones < largest {
+			largest = ones
+		}
+	}
+	return fmt.Sprintf("%s (ID %d): %s addresses free in %d block(s), the largest one is a /%d", *parent.Prefix, parentID, total, len(free), largest)
+}
+
+// insufficientSpaceDiag reports that none of the parent prefixes had room for the prefix, with the free space of each one
+func insufficientSpaceDiag(config *Config, parentIDs []int64, err error) diag.Diagnostics {
+	details := make([]string, 0, len(parentIDs))
+	for _, id := range parentIDs {
+		details = append(details, describeFreeSpace(config, id))
+	}
+	summary := err.Error()
+	var spaceErr *insufficientSpaceError
+	if errors.As(err, &spaceErr) {
+		summary = spaceErr.Error()
+	}
+	return diag.Diagnostics{{
+		Severity: diag.Error,
+		Summary:  summary,
+		Detail:   "The free space of the parent prefixes tried:\n" + strings.Join(details, "\n"),
+	}}
+}
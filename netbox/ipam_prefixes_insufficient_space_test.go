@@ -0,0 +1,109 @@
+package netbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestIsInsufficientSpace(t *testing.T) {
+	cases := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"allocation":          {err: &insufficientSpaceError{Length: 24}, expected: true},
+		"wrapped allocation":  {err: fmt.Errorf("Cannot allocate: %w", &insufficientSpaceError{Length: 24, Alignment: 20}), expected: true},
+		"netbox 2.8":          {err: &NetboxAPIError{Method: http.MethodPost, StatusCode: http.StatusNoContent}, expected: true},
+		"netbox 2.9":          {err: &NetboxAPIError{StatusCode: http.StatusConflict, Detail: "Insufficient space is available to accommodate the requested prefix size(s)"}, expected: true},
+		"conflict":            {err: &NetboxAPIError{StatusCode: http.StatusConflict, Detail: "Object is locked"}},
+		"bad request":         {err: &NetboxAPIError{StatusCode: http.StatusBadRequest, FieldErrors: map[string][]string{"prefix_length": {"Ensure this value is less than or equal to 32."}}}},
+		"client 204":          {err: mockInsufficientSpaceError(), expected: true},
+		"client 404":          {err: mockAPIError("ipam_prefixes_read", http.StatusNotFound)},
+		"mentioning 204":      {err: errors.New("Cannot determine prefix with ID 204")},
+		"not found in 10.204": {err: errors.New("Unknow prefix 10.204.0.0/16 with ID , not found")},
+	}
+	for tn, tc := range cases {
+		if got := isInsufficientSpace(tc.err); got != tc.expected {
+			t.Fatalf("%s: expected %t, got %t", tn, tc.expected, got)
+		}
+	}
+}
+
+func TestAvailablePrefixes_fallbackParents(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	full := f.seedPrefix("192.168.0.0/24", nil, "container")
+	f.seedPrefix("192.168.0.0/25", nil, "active")
+	f.seedPrefix("192.168.0.128/25", nil, "active")
+	partial := f.seedPrefix("192.168.1.0/24", nil, "container")
+	f.seedPrefix("192.168.1.0/25", nil, "active")
+	f.seedPrefix("192.168.1.128/26", nil, "active")
+	f.seedPrefix("192.168.1.192/27", nil, "active")
+	empty := f.seedPrefix("192.168.2.0/24", nil, "container")
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	create := func(parents ...int64) (*schema.ResourceData, error) {
+		fallbacks := make([]interface{}, 0, len(parents)-1)
+		for _, id := range parents[1:] {
+			fallbacks = append(fallbacks, int(id))
+		}
+		d := testAvailablePrefixesData(t, "", map[string]interface{}{
+			"parent_prefix_id":           int(parents[0]),
+			"fallback_parent_prefix_ids": fallbacks,
+			"prefix_length":              26,
+		})
+		diags := resourceIpamAvailablePrefixesCreate(context.Background(), d, config)
+		if diags.HasError() {
+			return d, fmt.Errorf("%s\n%s", diags[0].Summary, diags[0].Detail)
+		}
+		return d, nil
+	}
+
+	d, err := create(full.id, partial.id, empty.id)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if prefix := d.Get("prefix").(string); prefix != "192.168.2.0/26" {
+		t.Fatalf("expected 192.168.2.0/26 under the last fallback, got %s", prefix)
+	}
+	if parent := d.Get("parent_prefix_id").(int); int64(parent) != full.id {
+		t.Fatalf("expected the parent prefix %d to be kept, got %d", full.id, parent)
+	}
+
+	// A parent prefix listed again as a fallback is only tried once
+	d, err = create(full.id, empty.id, full.id)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if prefix := d.Get("prefix").(string); prefix != "192.168.2.64/26" {
+		t.Fatalf("expected 192.168.2.64/26 under the fallback, got %s", prefix)
+	}
+
+	d, err = create(full.id, partial.id, full.id)
+	if err == nil {
+		t.Fatalf("expected an error, got %s", d.Get("prefix"))
+	}
+	for _, expected := range []string{
+		`Insufficient space is available to accommodate the requested prefix size(s) "/26"`,
+		fmt.Sprintf("192.168.0.0/24 (ID %d): no free space", full.id),
+		fmt.Sprintf("192.168.1.0/24 (ID %d): 32 addresses free in 1 block(s), the largest one is a /27", partial.id),
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected the error to contain %q, got %v", expected, err)
+		}
+	}
+	if n := strings.Count(err.Error(), fmt.Sprintf("(ID %d)", full.id)); n != 1 {
+		t.Fatalf("expected the parent prefix %d reported once, got %d times in %v", full.id, n, err)
+	}
+	if d.Id() != "" {
+		t.Fatalf("expected no ID, got %s", d.Id())
+	}
+}
@@ -0,0 +1,155 @@
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/fenglyu/go-netbox/netbox/client/ipam"
+)
+
+// Every prefix the provider creates within an explicit workspace is stamped with a tag recording it,
+// so the prefixes which no state tracks can be found later.
+const ownershipTagPrefix = "tf-workspace-"
+
+var slugInvalidChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// ownershipTags returns the tags stamped on the prefixes created within the workspace. Without a workspace
+// the prefixes aren't stamped, every configuration would share the tag and own the others' prefixes.
+func ownershipTags(workspace string) []string {
+	if workspace == "" {
+		return nil
+	}
+	return []string{ownershipTag(workspace)}
+}
+
+// ownershipTag returns the tag stamped on the prefixes created within the workspace
+func ownershipTag(workspace string) string {
+	return ownershipTagPrefix + slugInvalidChars.ReplaceAllString(strings.ToLower(workspace), "-")
+}
+
+// OrphanedPrefix is a prefix stamped by the provider which isn't tracked by the state
+type OrphanedPrefix struct {
+	ID          int64
+	Prefix      string
+	Status      string
+	Description string
+}
+
+// Deletable tells whether the prefix may be deleted, a reserved one may be allocated by a run in progress
+func (p *OrphanedPrefix) Deletable() bool {
+	return p.Status != prefixStatusReservedSlug
+}
+
+// ReadStatePrefixIDs returns the IDs of the netbox_available_prefixes recorded in a terraform state file
+func ReadStatePrefixIDs(r io.Reader) (map[int64]bool, error) {
+	var state struct {
+		Version   int `json:"version"`
+		Resources []struct {
+			Mode      string `json:"mode"`
+			Type      string `json:"type"`
+			Instances []struct {
+				Attributes struct {
+					ID string `json:"id"`
+				} `json:"attributes"`
+			} `json:"instances"`
+		} `json:"resources"`
+	}
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return nil, fmt.Errorf("Cannot read the state: %v", err)
+	}
+	if state.Version < 4 {
+		return nil, fmt.Errorf("State version %d is not supported, expected version 4 or above", state.Version)
+	}
+
+	ids := make(map[int64]bool)
+	for _, r := range state.Resources {
+		if r.Mode != "managed" || r.Type != "netbox_available_prefixes" {
+			continue
+		}
+		for _, i := range r.Instances {
+			var id int64
+			if _, err := fmt.Sscanf(i.Attributes.ID, "%d", &id); err != nil {
+				return nil, fmt.Errorf("Unexpected prefix ID %q in the state", i.Attributes.ID)
+			}
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
+
+// FindOrphanedPrefixes lists the prefixes stamped with the workspace whose IDs aren't tracked
+func FindOrphanedPrefixes(config *Config, workspace string, tracked map[int64]bool) ([]*OrphanedPrefix, error) {
+	return findOrphanedPrefixes(config, workspace, tracked, NetboxApiGeneralQueryLimit)
+}
+
+// findOrphanedPrefixes lists the orphaned prefixes, pageSize at a time
+func findOrphanedPrefixes(config *Config, workspace string, tracked map[int64]bool, pageSize int64) ([]*OrphanedPrefix, error) {
+	if workspace == "" {
+		return nil, fmt.Errorf("A workspace is required, the prefixes created without one aren't stamped")
+	}
+	tag := ownershipTag(workspace)
+	// NetBox rejects a tag filter naming a tag which doesn't exist
+	found, err := getTagBySlug(config, tag)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot look up the tag %s: %w", tag, err)
+	}
+	if found == nil {
+		return nil, nil
+	}
+
+	params := ipam.IpamPrefixesListParams{
+		Tag:     &tag,
+		Limit:   &pageSize,
+		Context: context.Background(),
+	}
+	orphans := make([]*OrphanedPrefix, 0)
+	var listed int64
+	for {
+		res, err := config.ipam.IpamPrefixesList(&params, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot list the prefixes tagged with %s: %w", tag, err)
+		}
+		if res == nil || res.Payload == nil {
+			break
+		}
+		for _, p := range res.Payload.Results {
+			if tracked[p.ID] {
+				continue
+			}
+			orphan := &OrphanedPrefix{
+				ID:          p.ID,
+				Description: p.Description,
+			}
+			if p.Prefix != nil {
+				orphan.Prefix = *p.Prefix
+			}
+			if p.Status != nil && p.Status.Value != nil {
+				orphan.Status = *p.Status.Value
+			}
+			orphans = append(orphans, orphan)
+		}
+		listed += int64(len(res.Payload.Results))
+		if len(res.Payload.Results) == 0 || res.Payload.Next == nil || *res.Payload.Next == "" {
+			break
+		}
+		offset := listed
+		params.Offset = &offset
+	}
+	return orphans, nil
+}
+
+// DeletePrefix deletes the prefix with the given ID, a prefix which is already gone is no error
+func DeletePrefix(config *Config, id int64) error {
+	params := ipam.IpamPrefixesDeleteParams{
+		ID:      id,
+		Context: context.Background(),
+	}
+	if _, err := config.ipam.IpamPrefixesDelete(&params, nil); err != nil && !isNotFound(err) {
+		return fmt.Errorf("Cannot delete prefix %d: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package netbox
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestOwnershipTag(t *testing.T) {
+	cases := map[string]string{
+		"default":         "tf-workspace-default",
+		"prod_eu-west":    "tf-workspace-prod_eu-west",
+		"Team A/Staging!": "tf-workspace-team-a-staging-",
+	}
+	for workspace, expected := range cases {
+		tag := ownershipTag(workspace)
+		if tag != expected {
+			t.Fatalf("%q: expected %s, got %s", workspace, expected, tag)
+		}
+		if !isManagedTag(tag) {
+			t.Fatalf("%q: expected %s to be managed", workspace, tag)
+		}
+	}
+}
+
+func TestReadStatePrefixIDs(t *testing.T) {
+	state := `{
+  "version": 4,
+  "terraform_version": "0.13.5",
+  "resources": [
+    {
+      "mode": "managed",
+      "type": "netbox_available_prefixes",
+      "name": "foo",
+      "provider": "provider[\"terraform.cloud.blizzard.net/cf/netbox\"]",
+      "instances": [
+        {"schema_version": 1, "attributes": {"id": "911", "prefix": "10.0.0.0/24"}},
+        {"index_key": 1, "schema_version": 1, "attributes": {"id": "912", "prefix": "10.0.1.0/24"}}
+      ]
+    },
+    {
+      "mode": "data",
+      "type": "netbox_prefix",
+      "name": "parent",
+      "instances": [
+        {"schema_version": 1, "attributes": {"id": "10", "prefix": "10.0.0.0/16"}}
+      ]
+    }
+  ]
+}`
+	ids, err := ReadStatePrefixIDs(strings.NewReader(state))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if expected := map[int64]bool{911: true, 912: true}; !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected %v, got %v", expected, ids)
+	}
+
+	if _, err := ReadStatePrefixIDs(strings.NewReader(`{"version": 3, "modules": []}`)); err == nil {
+		t.Fatalf("expected an error for a legacy state")
+	}
+}
+
+func TestFindOrphanedPrefixes(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	// Nothing is stamped without a workspace
+	if _, err := FindOrphanedPrefixes(config, "", nil); err == nil {
+		t.Fatalf("expected an error without a workspace")
+	}
+	// No prefix was ever stamped with the workspace
+	if orphans, err := FindOrphanedPrefixes(config, "prod", nil); err != nil || len(orphans) != 0 {
+		t.Fatalf("expected no orphans, got %v, %v", orphans, err)
+	}
+
+	create := func(workspace string) int64 {
+		config.Workspace = workspace
+		d := schema.TestResourceDataRaw(t, resourceIpamAvailablePrefixes().Schema, map[string]interface{}{
+			"parent_prefix_id": int(f.parentPrefixID),
+			"prefix_length":    28,
+			"custom_fields":    []interface{}{map[string]interface{}{}},
+		})
+		if diags := resourceIpamAvailablePrefixesCreate(context.Background(), d, config); diags.HasError() {
+			t.Fatalf("%q: unexpected error %v", workspace, diags)
+		}
+		id, _ := strconv.ParseInt(d.Id(), 10, 64)
+		return id
+	}
+	stamped := create("prod")
+	unstamped := create("")
+
+	f.lock.Lock()
+	if tags := f.prefixes[stamped].tags; !reflect.DeepEqual(tags, []string{"tf-workspace-prod"}) {
+		t.Errorf("expected the prefix stamped with the workspace, got %v", tags)
+	}
+	// The reservation marker is dropped even though the prefix has no other tag
+	if tags := f.prefixes[unstamped].tags; len(tags) != 0 {
+		t.Errorf("expected the prefix created without workspace left untagged, got %v", tags)
+	}
+	reserved := f.seedPrefix("10.1.0.0/28", nil, prefixStatusReservedSlug)
+	reserved.tags = []string{"tf-workspace-prod"}
+	f.lock.Unlock()
+
+	orphans, err := FindOrphanedPrefixes(config, "prod", map[int64]bool{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	deletable := make(map[int64]bool)
+	for _, o := range orphans {
+		deletable[o.ID] = o.Deletable()
+	}
+	// The prefix reserved by a run in progress is never deleted
+	if expected := map[int64]bool{stamped: true, reserved.id: false}; !reflect.DeepEqual(deletable, expected) {
+		t.Fatalf("expected the orphans %v, got %v", expected, deletable)
+	}
+
+	if orphans, err := FindOrphanedPrefixes(config, "prod", map[int64]bool{stamped: true, reserved.id: true}); err != nil || len(orphans) != 0 {
+		t.Fatalf("expected the tracked prefixes left alone, got %v, %v", orphans, err)
+	}
+
+	// The orphans beyond the first page are found too
+	lists := f.requestCount("GET", "/ipam/prefixes/")
+	orphans, err = findOrphanedPrefixes(config, "prod", map[int64]bool{}, 1)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(orphans) != 2 {
+		t.Fatalf("expected 2 orphans listed one at a time, got %v", orphans)
+	}
+	if requests := f.requestCount("GET", "/ipam/prefixes/") - lists; requests < 2 {
+		t.Fatalf("expected the prefixes listed over several pages, got %d requests", requests)
+	}
+}
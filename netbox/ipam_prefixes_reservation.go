@@ -0,0 +1,250 @@
+package netbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/fenglyu/go-netbox/netbox/client/extras"
+	"github.com/fenglyu/go-netbox/netbox/client/ipam"
+	"github.com/fenglyu/go-netbox/netbox/models"
+)
+
+// A prefix is allocated in two phases: it's created as reserved and tagged with a marker,
+// then flipped to the desired status, which drops the marker, before it's recorded in the state.
+// A run which failed in between leaves a reserved prefix behind, which belongs to no resource
+// yet, so the next one with the same config adopts it, under the lock of the parent prefix.
+const (
+	prefixStatusReservedSlug = "reserved"
+	reservationTagPrefix     = "tf-reserved-"
+)
+
+// isManagedTag tells the tags the provider puts on prefixes for its own use
+func isManagedTag(tag string) bool {
+	return strings.HasPrefix(tag, reservationTagPrefix) || strings.HasPrefix(tag, ownershipTagPrefix)
+}
+
+// flattenTags drops the tags managed by the provider, and the blank and duplicate ones, in sorted order
+func flattenTags(tags []string) []string {
+	flattened := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] || isManagedTag(t) {
+			continue
+		}
+		seen[t] = true
+		flattened = append(flattened, t)
+	}
+	sort.Strings(flattened)
+	return flattened
+}
+
+// reservationMarker derives the marker of an allocation from the parent and the arguments placing the prefix,
+// so the same config run again finds the prefix reserved for it, whatever else changed in between. Instances
+// with identical arguments share the marker, a reserved prefix is adopted by whichever of them takes the lock first.
+func reservationMarker(d *schema.ResourceData, parentID int64, wPrefix *models.WritablePrefix) (string, error) {
+	data, err := json.Marshal(struct {
+		ParentID           int64       `json:"parent_id"`
+		PrefixLength       int64       `json:"prefix_length"`
+		Vrf                *int64      `json:"vrf"`
+		SubnetIndex        interface{} `json:"subnet_index"`
+		AllocationStrategy string      `json:"allocation_strategy"`
+		Alignment          int         `json:"alignment"`
+	}{
+		ParentID:           parentID,
+		PrefixLength:       wPrefix.PrefixLength,
+		Vrf:                wPrefix.Vrf,
+		SubnetIndex:        subnetIndexOrNil(d),
+		AllocationStrategy: d.Get("allocation_strategy").(string),
+		Alignment:          d.Get("alignment").(int),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return reservationTagPrefix + hex.EncodeToString(sum[:8]), nil
+}
+
+func subnetIndexOrNil(d *schema.ResourceData) interface{} {
+	if index, ok := getSubnetIndex(d); ok {
+		return index
+	}
+	return nil
+}
+
+// reservePrefix turns the requested prefix into a reservation carrying the marker
+func reservePrefix(wPrefix *models.WritablePrefix, marker string) {
+	wPrefix.Status = prefixStatusReservedSlug
+	wPrefix.Tags = append(append([]string{}, wPrefix.Tags...), marker)
+}
+
+// findReservedPrefix returns the prefix still reserved with the marker by an earlier run, if any.
+// NetBox rejects a tag filter naming a tag which doesn't exist, and the marker tag only exists
+// while a prefix is reserved with it.
+func findReservedPrefix(ctx context.Context, config *Config, marker string) (*models.Prefix, error) {
+	tag, err := getTagBySlug(config, marker)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot look up the tag %s: %w", marker, err)
+	}
+	if tag == nil {
+		return nil, nil
+	}
+
+	status := prefixStatusReservedSlug
+	params := ipam.IpamPrefixesListParams{
+		Tag:     &marker,
+		Status:  &status,
+		Limit:   &NetboxApiGeneralQueryLimit,
+		Context: context.Background(),
+	}
+	res, err := config.ipam.IpamPrefixesList(&params, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot look up prefixes reserved with %s: %w", marker, err)
+	}
+	if res == nil || res.Payload == nil || len(res.Payload.Results) == 0 {
+		return nil, nil
+	}
+	if len(res.Payload.Results) > 1 {
+		tflog.SubsystemWarn(ctx, logSubsystemAvailablePrefixes, "Several prefixes are reserved with the marker, adopting the first one", map[string]interface{}{
+			"marker": marker,
+			"count":  len(res.Payload.Results),
+			"id":     res.Payload.Results[0].ID,
+		})
+	}
+	return res.Payload.Results[0], nil
+}
+
+// commitReservedPrefix flips a reserved prefix to the desired status and drops the marker. The other arguments
+// are applied too, an adopted prefix may have been reserved by a run with another description, tags and so on.
+func commitReservedPrefix(ctx context.Context, config *Config, prefix *models.Prefix, wPrefix models.WritablePrefix, marker string) error {
+	data := wPrefix
+	data.ID = 0
+	data.PrefixLength = 0
+	data.Prefix = prefix.Prefix
+	if data.Status == "" {
+		data.Status = "active"
+	}
+	if data.IsPool == nil {
+		isPool := false
+		data.IsPool = &isPool
+	}
+	// The arguments left out are cleared, the marker is dropped with the tags of the prefix, which may be none at all
+	cleared := make(map[string]interface{})
+	if data.Description == "" {
+		cleared["description"] = ""
+	}
+	if len(data.Tags) == 0 {
+		cleared["tags"] = []string{}
+	}
+	for key, id := range map[string]*int64{"site": data.Site, "tenant": data.Tenant, "vlan": data.Vlan, "role": data.Role} {
+		if id == nil {
+			cleared[key] = nil
+		}
+	}
+	status := data.Status
+	params := ipam.IpamPrefixesPartialUpdateParams{
+		ID:      prefix.ID,
+		Data:    &data,
+		Context: withClearedFields(context.Background(), cleared),
+	}
+	if _, err := config.ipam.IpamPrefixesPartialUpdate(&params, nil); err != nil {
+		return fmt.Errorf("Prefix %s is reserved but its status could not be set to %s: %w", *prefix.Prefix, status, err)
+	}
+
+	// The update drops the marker from the prefix, the tag itself is deleted so the markers don't pile up
+	if err := deleteTagBySlug(config, marker); err != nil {
+		tflog.SubsystemWarn(ctx, logSubsystemAvailablePrefixes, "Cannot delete the reservation tag", map[string]interface{}{
+			"tag":   marker,
+			"error": err.Error(),
+		})
+	}
+	return nil
+}
+
+// getTagBySlug returns the tag with the given slug, or nil if it doesn't exist
+func getTagBySlug(config *Config, slug string) (*models.Tag, error) {
+	params := extras.ExtrasTagsListParams{
+		Slug:    &slug,
+		Context: context.Background(),
+	}
+	res, err := config.extras.ExtrasTagsList(&params, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil || res.Payload == nil || len(res.Payload.Results) == 0 {
+		return nil, nil
+	}
+	return res.Payload.Results[0], nil
+}
+
+// deleteTagBySlug deletes the tag with the given slug, if it exists
+func deleteTagBySlug(config *Config, slug string) error {
+	listParams := extras.ExtrasTagsListParams{
+		Slug:    &slug,
+		Context: context.Background(),
+	}
+	res, err := config.extras.ExtrasTagsList(&listParams, nil)
+	if err != nil {
+		return err
+	}
+	if res == nil || res.Payload == nil {
+		return nil
+	}
+	for _, tag := range res.Payload.Results {
+		params := extras.ExtrasTagsDeleteParams{
+			ID:      tag.ID,
+			Context: context.Background(),
+		}
+		if _, err := config.extras.ExtrasTagsDelete(&params, nil); err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureManagedTags creates the tags the provider stamps on the prefixes which don't exist yet,
+// NetBox 2.9 and later only assign existing tags.
+func ensureManagedTags(config *Config, tags []string) error {
+	if !config.apiVersion.atLeast(netboxNestedTagsVersion) {
+		return nil
+	}
+	for _, tag := range tags {
+		if !isManagedTag(tag) {
+			continue
+		}
+		found, err := getTagBySlug(config, tag)
+		if err != nil {
+			return fmt.Errorf("Cannot look up the tag %s: %w", tag, err)
+		}
+		if found != nil {
+			continue
+		}
+
+		name, slug := tag, tag
+		params := extras.ExtrasTagsCreateParams{
+			Data: &models.Tag{
+				Name:  &name,
+				Slug:  &slug,
+				Color: lockTagColor,
+			},
+			Context: context.Background(),
+		}
+		if _, err := config.extras.ExtrasTagsCreate(&params, nil); err != nil {
+			// Created by a concurrent run in between
+			if found, lerr := getTagBySlug(config, tag); lerr == nil && found != nil {
+				continue
+			}
+			return fmt.Errorf("Cannot create the tag %s: %w", tag, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package netbox
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/fenglyu/go-netbox/netbox/client/extras"
+	"github.com/fenglyu/go-netbox/netbox/client/ipam"
+	"github.com/fenglyu/go-netbox/netbox/models"
+)
+
+func TestReservationMarker(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceIpamAvailablePrefixes().Schema, map[string]interface{}{
+		"parent_prefix_id": 10,
+		"prefix_length":    24,
+	})
+
+	marker := func(parentID int64, wPrefix models.WritablePrefix) string {
+		m, err := reservationMarker(d, parentID, &wPrefix)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return m
+	}
+
+	vrf := int64(3)
+	requested := models.WritablePrefix{PrefixLength: 24, Status: "active", Tags: []string{"a", "b"}}
+	edited := models.WritablePrefix{PrefixLength: 24, Status: "deprecated", Description: "uplinks", Tags: []string{"c"}}
+	longer := models.WritablePrefix{PrefixLength: 25, Status: "active", Tags: []string{"a", "b"}}
+	inVrf := models.WritablePrefix{PrefixLength: 24, Status: "active", Tags: []string{"a", "b"}, Vrf: &vrf}
+
+	m := marker(10, requested)
+	if !strings.HasPrefix(m, reservationTagPrefix) || !isManagedTag(m) {
+		t.Fatalf("expected a managed tag, got %s", m)
+	}
+	if m != marker(10, requested) {
+		t.Fatalf("expected the same request to get the same marker")
+	}
+	if m != marker(10, edited) {
+		t.Fatalf("expected the arguments applied on commit not to change the marker")
+	}
+	if m == marker(10, longer) {
+		t.Fatalf("expected another prefix length to change the marker")
+	}
+	if m == marker(10, inVrf) {
+		t.Fatalf("expected another VRF to change the marker")
+	}
+	if m == marker(11, requested) {
+		t.Fatalf("expected another parent to change the marker")
+	}
+	if !reflect.DeepEqual(requested.Tags, []string{"a", "b"}) {
+		t.Fatalf("expected the tags of the request to be left alone, got %v", requested.Tags)
+	}
+}
+
+func TestReservePrefix(t *testing.T) {
+	tags := []string{"a"}
+	wPrefix := &models.WritablePrefix{Status: "active", Tags: tags}
+	reservePrefix(wPrefix, "tf-reserved-0123")
+
+	if wPrefix.Status != prefixStatusReservedSlug {
+		t.Fatalf("expected status %s, got %s", prefixStatusReservedSlug, wPrefix.Status)
+	}
+	if !reflect.DeepEqual(wPrefix.Tags, []string{"a", "tf-reserved-0123"}) {
+		t.Fatalf("expected the marker to be added, got %v", wPrefix.Tags)
+	}
+	if !reflect.DeepEqual(flattenTags(wPrefix.Tags), tags) {
+		t.Fatalf("expected the marker to be dropped, got %v", flattenTags(wPrefix.Tags))
+	}
+}
+
+func TestFindReservedPrefix(t *testing.T) {
+	reserved := mockPrefix(20, "10.0.1.0/24", nil)
+	cases := map[string]struct {
+		tags     []string
+		expected *models.Prefix
+	}{
+		// NetBox rejects a tag filter naming a tag which doesn't exist
+		"no marker tag": {},
+		"marker tag":    {tags: []string{"tf-reserved-0123"}, expected: reserved},
+	}
+
+	for tn, tc := range cases {
+		m := &mockNetbox{}
+		m.mockTags(tc.tags...)
+		m.prefixesList = func(params *ipam.IpamPrefixesListParams) (*ipam.IpamPrefixesListOK, error) {
+			if params.Tag == nil || !reflect.DeepEqual(tc.tags, []string{*params.Tag}) {
+				return nil, mockAPIError("ipam_prefixes_list", http.StatusBadRequest)
+			}
+			return mockPrefixList(reserved), nil
+		}
+
+		found, err := findReservedPrefix(context.Background(), m.config(), "tf-reserved-0123")
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v, calls %v", tn, err, m.calls)
+		}
+		if found != tc.expected {
+			t.Fatalf("%s: expected %v, got %v", tn, tc.expected, found)
+		}
+	}
+}
+
+func TestReservationWithoutMarkerTag(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	// The first allocation looks the prefixes up by a marker nobody created yet
+	d := schema.TestResourceDataRaw(t, resourceIpamAvailablePrefixes().Schema, map[string]interface{}{
+		"parent_prefix_id": int(f.parentPrefixID),
+		"prefix_length":    28,
+		"custom_fields":    []interface{}{map[string]interface{}{}},
+	})
+	if diags := resourceIpamAvailablePrefixesCreate(context.Background(), d, config); diags.HasError() {
+		t.Fatalf("unexpected error %v", diags)
+	}
+	id, _ := strconv.ParseInt(d.Id(), 10, 64)
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if p, ok := f.prefixes[id]; !ok || p.status != "active" {
+		t.Fatalf("expected prefix %d committed as active, got %v", id, p)
+	}
+	for _, tag := range f.tags {
+		if strings.HasPrefix(*tag.Name, reservationTagPrefix) {
+			t.Errorf("expected the reservation tag deleted, got %s", *tag.Name)
+		}
+	}
+}
+
+func TestReservationAdoptedOnce(t *testing.T) {
+	parent := mockPrefix(10, "10.0.0.0/16", nil)
+	reserved := mockPrefix(20, "10.0.1.0/24", nil)
+	allocated := mockPrefix(21, "10.0.2.0/24", nil)
+
+	// A reservation left by a failed run, which both instances have the marker of
+	m := &mockNetbox{}
+	m.mockPrefixes(parent, reserved, allocated)
+	left := true
+	m.tagsList = func(params *extras.ExtrasTagsListParams) (*extras.ExtrasTagsListOK, error) {
+		if !left || params.Slug == nil || !strings.HasPrefix(*params.Slug, reservationTagPrefix) {
+			return &extras.ExtrasTagsListOK{Payload: &extras.ExtrasTagsListOKBody{}}, nil
+		}
+		return &extras.ExtrasTagsListOK{Payload: &extras.ExtrasTagsListOKBody{Results: []*models.Tag{{ID: 1, Name: params.Slug, Slug: params.Slug}}}}, nil
+	}
+	m.tagsDelete = func(*extras.ExtrasTagsDeleteParams) (*extras.ExtrasTagsDeleteNoContent, error) {
+		return &extras.ExtrasTagsDeleteNoContent{}, nil
+	}
+	list := m.prefixesList
+	m.prefixesList = func(params *ipam.IpamPrefixesListParams) (*ipam.IpamPrefixesListOK, error) {
+		if params.Tag != nil {
+			if left {
+				return mockPrefixList(reserved), nil
+			}
+			return mockPrefixList(), nil
+		}
+		return list(params)
+	}
+	var committed *models.WritablePrefix
+	m.prefixesPartialUpdate = func(params *ipam.IpamPrefixesPartialUpdateParams) (*ipam.IpamPrefixesPartialUpdateOK, error) {
+		// Committing drops the marker
+		if params.ID == reserved.ID {
+			left = false
+			committed = params.Data
+		}
+		return &ipam.IpamPrefixesPartialUpdateOK{}, nil
+	}
+	m.availablePrefixesCreate = func(*ipam.IpamPrefixesAvailablePrefixesCreateParams) (*ipam.IpamPrefixesAvailablePrefixesCreateCreated, error) {
+		return &ipam.IpamPrefixesAvailablePrefixesCreateCreated{Payload: allocated}, nil
+	}
+	config := m.config()
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		d := testAvailablePrefixesData(t, "", map[string]interface{}{"parent_prefix_id": 10, "prefix_length": 24, "description": "uplinks"})
+		if diags := resourceIpamAvailablePrefixesCreate(context.Background(), d, config); diags.HasError() {
+			t.Fatalf("unexpected error %v, calls %v", diags, m.calls)
+		}
+		ids = append(ids, d.Id())
+	}
+	if !reflect.DeepEqual(ids, []string{"20", "21"}) {
+		t.Fatalf("expected the reservation adopted by the first instance only, got %v", ids)
+	}
+	// The reservation was made by a run with other arguments, which are applied on commit
+	if committed == nil || committed.Status != "active" || committed.Description != "uplinks" || *committed.Prefix != *reserved.Prefix {
+		t.Fatalf("expected the adopted prefix committed with the arguments, got %+v", committed)
+	}
+}
@@ -0,0 +1,252 @@
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/fenglyu/go-netbox/netbox/client/ipam"
+	"github.com/fenglyu/go-netbox/netbox/models"
+)
+
+// prefixUsage sums up how much of a prefix is in use
+type prefixUsage struct {
+	Utilization       float64
+	ChildPrefixCount  int64
+	UsedIPCount       int64
+	AvailablePrefixes []string
+}
+
+// prefixUsageSchema returns the computed attributes describing the usage of a prefix, shared by the prefix data sources
+func prefixUsageSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"utilization": {
+			Type:        schema.TypeFloat,
+			Computed:    true,
+			Description: "Utilization of the prefix in percent",
+		},
+		"child_prefix_count": {
+			Type:        schema.TypeInt,
+			Computed:    true,
+			Description: "Number of prefixes within the prefix",
+		},
+		"used_ip_count": {
+			Type:        schema.TypeInt,
+			Computed:    true,
+			Description: "Number of IP addresses assigned within a pool prefix",
+		},
+		"available_prefixes": {
+			Type:        schema.TypeList,
+			Elem:        &schema.Schema{Type: schema.TypeString},
+			Computed:    true,
+			Description: "Free blocks within the prefix in CIDR notation",
+		},
+	}
+}
+
+func flattenPrefixUsage(prefix *models.Prefix, usage *prefixUsage) map[string]interface{} {
+	data := map[string]interface{}{
+		"utilization":        usage.Utilization,
+		"child_prefix_count": int(usage.ChildPrefixCount),
+		"available_prefixes": usage.AvailablePrefixes,
+	}
+	if prefix.IsPool != nil && *prefix.IsPool {
+		data["used_ip_count"] = int(usage.UsedIPCount)
+	}
+	return data
+}
+
+// getIpamPrefixUsage computes the utilization the same way as Prefix.get_utilization() of netbox:
+// https://github.com/netbox-community/netbox/blob/v2.8.9/netbox/ipam/models.py#L583-L605
+// A container is measured by the space its child prefixes cover, any other
+// prefix by the number of IP addresses assigned within it.
+func getIpamPrefixUsage(config *Config, prefix *models.Prefix) (*prefixUsage, error) {
+	if prefix == nil || prefix.Prefix == nil {
+		return nil, fmt.Errorf("Cannot determine utilization of an empty prefix")
+	}
+	_, ipnet, err := net.ParseCIDR(*prefix.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	size := cidrSize(ipnet)
+	usage := &prefixUsage{}
+
+	children, count, err := getIpamChildPrefixes(config, prefix)
+	if err != nil {
+		return nil, err
+	}
+	usage.ChildPrefixCount = count
+
+	usage.UsedIPCount, err = getIpamPrefixIPCount(config, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	available, err := getIpamAvailablePrefixes(config, prefix.ID)
+	if err != nil {
+		return nil, err
+	}
+	usage.AvailablePrefixes = make([]string, 0, len(available))
+	for _, a := range available {
+		usage.AvailablePrefixes = append(usage.AvailablePrefixes, a.Prefix)
+	}
+
+	if prefix.Status != nil && prefix.Status.Value != nil && *prefix.Status.Value == "container" {
+		used := new(big.Int)
+		for _, c := range collapseCIDRs(children) {
+			used.Add(used, cidrSize(c))
+		}
+		usage.Utilization = percentOf(used, size)
+		return usage, nil
+	}
+
+	// Ignore the network and broadcast addresses of a non-pool IPv4 prefix
+	ones, bits := ipnet.Mask.Size()
+	if bits == 32 && ones < 31 && (prefix.IsPool == nil || !*prefix.IsPool) {
+		size.Sub(size, big.NewInt(2))
+	}
+	usage.Utilization = percentOf(big.NewInt(usage.UsedIPCount), size)
+	return usage, nil
+}
+
+// getIpamChildPrefixes returns the networks of the prefixes within the given one, and how many there are.
+// A global container spans every VRF, the same as netbox does.
+func getIpamChildPrefixes(config *Config, prefix *models.Prefix) ([]*net.IPNet, int64, error) {
+	return listIpamChildPrefixes(config, prefix, NetboxApiGeneralQueryLimit)
+}
+
+// listIpamChildPrefixes lists the prefixes within the given one, pageSize at a time, netbox caps the page size
+func listIpamChildPrefixes(config *Config, prefix *models.Prefix, pageSize int64) ([]*net.IPNet, int64, error) {
+	param := ipam.IpamPrefixesListParams{
+		Within:  prefix.Prefix,
+		Limit:   &pageSize,
+		Context: context.Background(),
+	}
+	isContainer := prefix.Status != nil && prefix.Status.Value != nil && *prefix.Status.Value == "container"
+	if prefix.Vrf != nil {
+		vrfID := strconv.FormatInt(prefix.Vrf.ID, 10)
+		param.VrfID = &vrfID
+	} else if !isContainer {
+		vrfID := "null"
+		param.VrfID = &vrfID
+	}
+
+	children := make([]*net.IPNet, 0)
+	var listed int64
+	for {
+		ipamPrefixListBody, err := config.ipam.IpamPrefixesList(&param, nil, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ipamPrefixListBody == nil || ipamPrefixListBody.Payload == nil {
+			break
+		}
+		for _, p := range ipamPrefixListBody.Payload.Results {
+			if p.Prefix == nil {
+				continue
+			}
+			if _, c, err := net.ParseCIDR(*p.Prefix); err == nil {
+				children = append(children, c)
+			}
+		}
+		listed += int64(len(ipamPrefixListBody.Payload.Results))
+		if len(ipamPrefixListBody.Payload.Results) == 0 || ipamPrefixListBody.Payload.Next == nil || *ipamPrefixListBody.Payload.Next == "" {
+			break
+		}
+		offset := listed
+		param.Offset = &offset
+	}
+
+	return children, listed, nil
+}
+
+// getIpamPrefixIPCount counts the IP addresses assigned within the given prefix
+func getIpamPrefixIPCount(config *Config, prefix *models.Prefix) (int64, error) {
+	var limit int64 = 1
+	param := ipam.IpamIPAddressesListParams{
+		Parent:  prefix.Prefix,
+		Limit:   &limit,
+		Context: context.Background(),
+	}
+	var vrfID string
+	if prefix.Vrf != nil {
+		vrfID = strconv.FormatInt(prefix.Vrf.ID, 10)
+	} else {
+		vrfID = "null"
+	}
+	param.VrfID = &vrfID
+
+	ipAddressesListBody, err := config.ipam.IpamIPAddressesList(&param, nil)
+	if err != nil {
+		return 0, err
+	}
+	if ipAddressesListBody == nil || ipAddressesListBody.Payload == nil || ipAddressesListBody.Payload.Count == nil {
+		return 0, nil
+	}
+	return *ipAddressesListBody.Payload.Count, nil
+}
+
+// getIpamAvailablePrefixes lists the free blocks within the prefix with the given ID
+// GET: /ipam/prefixes/{id}/available-prefixes/
+func getIpamAvailablePrefixes(config *Config, id int64) ([]*models.AvailablePrefix, error) {
+	params := ipam.IpamPrefixesAvailablePrefixesReadParams{
+		ID:      id,
+		Context: context.Background(),
+	}
+	res, err := config.ipam.IpamPrefixesAvailablePrefixesRead(&params, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot determine available prefixes within prefix %d: %w", id, err)
+	}
+	if res == nil {
+		return nil, nil
+	}
+	return res.Payload, nil
+}
+
+// cidrSize returns the number of addresses within the network
+func cidrSize(ipnet *net.IPNet) *big.Int {
+	ones, bits := ipnet.Mask.Size()
+	return new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+}
+
+// collapseCIDRs drops every network which is covered by another one of the list
+func collapseCIDRs(nets []*net.IPNet) []*net.IPNet {
+	sorted := make([]*net.IPNet, len(nets))
+	copy(sorted, nets)
+	sort.Slice(sorted, func(i, j int) bool {
+		oi, _ := sorted[i].Mask.Size()
+		oj, _ := sorted[j].Mask.Size()
+		return oi < oj
+	})
+
+	collapsed := make([]*net.IPNet, 0, len(sorted))
+	for _, n := range sorted {
+		covered := false
+		for _, c := range collapsed {
+			if c.Contains(n.IP) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			collapsed = append(collapsed, n)
+		}
+	}
+	return collapsed
+}
+
+func percentOf(used, size *big.Int) float64 {
+	if size.Sign() <= 0 {
+		return 0
+	}
+	pct, _ := new(big.Float).Quo(
+		new(big.Float).Mul(new(big.Float).SetInt(used), big.NewFloat(100)),
+		new(big.Float).SetInt(size),
+	).Float64()
+	return pct
+}
@@ -0,0 +1,152 @@
+package netbox
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func mustParseCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			t.Fatalf("error parsing %s: %v", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func TestCollapseCIDRs(t *testing.T) {
+	cases := map[string]struct {
+		in       []string
+		expected []string
+	}{
+		"empty": {
+			in:       []string{},
+			expected: []string{},
+		},
+		"disjoint": {
+			in:       []string{"10.0.1.0/24", "10.0.0.0/24"},
+			expected: []string{"10.0.1.0/24", "10.0.0.0/24"},
+		},
+		"nested": {
+			in:       []string{"10.0.0.0/26", "10.0.0.0/24", "10.0.0.128/25", "10.0.1.0/24"},
+			expected: []string{"10.0.0.0/24", "10.0.1.0/24"},
+		},
+		"duplicated": {
+			in:       []string{"2001:db8::/64", "2001:db8::/64"},
+			expected: []string{"2001:db8::/64"},
+		},
+	}
+
+	for tn, tc := range cases {
+		collapsed := collapseCIDRs(mustParseCIDRs(t, tc.in...))
+		if len(collapsed) != len(tc.expected) {
+			t.Fatalf("%s: expected %v, got %v", tn, tc.expected, collapsed)
+		}
+		for i, c := range collapsed {
+			if c.String() != tc.expected[i] {
+				t.Fatalf("%s: expected %v, got %v", tn, tc.expected, collapsed)
+			}
+		}
+	}
+}
+
+func TestPercentOf(t *testing.T) {
+	cases := map[string]struct {
+		cidr     string
+		used     int64
+		expected float64
+	}{
+		"empty":      {cidr: "10.0.0.0/24", used: 0, expected: 0},
+		"quarter":    {cidr: "10.0.0.0/24", used: 64, expected: 25},
+		"full":       {cidr: "10.0.0.0/30", used: 4, expected: 100},
+		"ipv6 empty": {cidr: "2001:db8::/64", used: 0, expected: 0},
+	}
+
+	for tn, tc := range cases {
+		size := cidrSize(mustParseCIDRs(t, tc.cidr)[0])
+		if pct := percentOf(big.NewInt(tc.used), size); pct != tc.expected {
+			t.Fatalf("%s: expected %v, got %v", tn, tc.expected, pct)
+		}
+	}
+
+	_, n, _ := net.ParseCIDR("2001:db8::/64")
+	half := new(big.Int).Rsh(cidrSize(n), 1)
+	if pct := percentOf(half, cidrSize(n)); pct != 50 {
+		t.Fatalf("expected 50, got %v", pct)
+	}
+}
+
+// The usage of the listed prefixes costs requests per prefix, it's only computed when asked for
+func TestDataSourceIpamPrefixesIncludeUsage(t *testing.T) {
+	for _, includeUsage := range []bool{false, true} {
+		f := newFakeNetbox()
+		config, err := f.config()
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+
+		d := schema.TestResourceDataRaw(t, dataSourceIpamPrefixes().Schema, map[string]interface{}{
+			"name":          "prefix_lookup",
+			"q":             "/12",
+			"include_usage": includeUsage,
+		})
+		if diags := dataSourceIpamPrefixesRead(context.Background(), d, config); diags.HasError() {
+			t.Fatalf("include_usage %v: unexpected error %v", includeUsage, diags)
+		}
+		prefixes := d.Get("prefixes").([]interface{})
+		if len(prefixes) != 2 {
+			t.Fatalf("include_usage %v: expected the 2 parent prefixes, got %v", includeUsage, prefixes)
+		}
+
+		expected := 0
+		if includeUsage {
+			expected = len(prefixes)
+		}
+		if n := f.requestCount(http.MethodGet, "/ipam/prefixes/{id}/available-prefixes/"); n != expected {
+			t.Errorf("include_usage %v: expected %d requests of the available prefixes, got %d", includeUsage, expected, n)
+		}
+		available := prefixes[0].(map[string]interface{})["available_prefixes"].([]interface{})
+		if includeUsage != (len(available) > 0) {
+			t.Errorf("include_usage %v: unexpected available prefixes %v", includeUsage, available)
+		}
+		f.Close()
+	}
+}
+
+// The child prefixes are paged through, netbox caps the size of a page
+func TestListIpamChildPrefixes(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	f.lock.Lock()
+	container := f.seedPrefix("10.32.0.0/16", nil, "container")
+	for _, cidr := range []string{"10.32.0.0/24", "10.32.1.0/24", "10.32.2.0/24"} {
+		f.seedPrefix(cidr, nil, "active")
+	}
+	prefix := f.prefixModel(container)
+	f.lock.Unlock()
+
+	lists := f.requestCount(http.MethodGet, "/ipam/prefixes/")
+	children, count, err := listIpamChildPrefixes(config, prefix, 1)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(children) != 3 || count != 3 {
+		t.Fatalf("expected the 3 child prefixes, got %v, %d", children, count)
+	}
+	if requests := f.requestCount(http.MethodGet, "/ipam/prefixes/") - lists; requests < 3 {
+		t.Fatalf("expected the child prefixes listed over several pages, got %d requests", requests)
+	}
+}
@@ -0,0 +1,167 @@
+package netbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// Log subsystems, they can be filtered with TF_LOG_PROVIDER_<SUBSYSTEM>, e.g. TF_LOG_PROVIDER_NETBOX_HTTP=TRACE
+const (
+	logSubsystemHTTP                        = "netbox_http"
+	logSubsystemAvailablePrefixes           = "netbox_available_prefixes"
+	logSubsystemDataSourceAvailablePrefixes = "netbox_available_prefixes_data_source"
+	logSubsystemDataSourcePrefix            = "netbox_prefix_data_source"
+	logSubsystemDataSourcePrefixes          = "netbox_prefixes_data_source"
+	logSubsystemLock                        = "netbox_lock"
+	logSubsystemTag                         = "netbox_tag"
+
+	logMaskedValue = "***"
+)
+
+// newLogSubsystem returns ctx with the log subsystem, which masks the api token wherever it shows up
+func newLogSubsystem(ctx context.Context, config *Config, subsystem string) context.Context {
+	ctx = tflog.NewSubsystem(ctx, subsystem)
+	if config != nil && config.ApiToken != "" {
+		ctx = tflog.SubsystemMaskAllFieldValuesStrings(ctx, subsystem, config.ApiToken)
+		ctx = tflog.SubsystemMaskMessageStrings(ctx, subsystem, config.ApiToken)
+	}
+	return ctx
+}
+
+// netboxLoggingTransport logs every request to netbox and its response at TRACE level.
+// The requests of the go-openapi client carry a background context, the logger is the
+// one of the provider configuration instead.
+type netboxLoggingTransport struct {
+	next                  http.RoundTripper
+	ctx                   context.Context
+	sensitiveCustomFields map[string]bool
+}
+
+func newNetboxLoggingTransport(ctx context.Context, config *Config, next http.RoundTripper) *netboxLoggingTransport {
+	sensitive := make(map[string]bool, len(config.SensitiveCustomFields))
+	for _, field := range config.SensitiveCustomFields {
+		sensitive[field] = true
+	}
+	return &netboxLoggingTransport{
+		next:                  next,
+		ctx:                   newLogSubsystem(ctx, config, logSubsystemHTTP),
+		sensitiveCustomFields: sensitive,
+	}
+}
+
+func (t *netboxLoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// The body read for the log is sent with a clone, the request of the caller is left alone
+		req = req.Clone(req.Context())
+		setRequestBody(req, body)
+	}
+	tflog.SubsystemTrace(t.ctx, logSubsystemHTTP, "Sending request to NetBox", map[string]interface{}{
+		"method":  req.Method,
+		"url":     req.URL.String(),
+		"headers": redactHeaders(req.Header),
+		"body":    t.maskBody(body),
+	})
+
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		tflog.SubsystemTrace(t.ctx, logSubsystemHTTP, "Request to NetBox failed", map[string]interface{}{
+			"method": req.Method,
+			"url":    req.URL.String(),
+			"error":  err.Error(),
+		})
+		return res, err
+	}
+
+	body, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	tflog.SubsystemTrace(t.ctx, logSubsystemHTTP, "Received response from NetBox", map[string]interface{}{
+		"method":      req.Method,
+		"url":         req.URL.String(),
+		"status":      res.StatusCode,
+		"headers":     redactHeaders(res.Header),
+		"body":        t.maskBody(body),
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+	return res, nil
+}
+
+// redactHeaders flattens the headers for the log, without the value of the api token
+func redactHeaders(header http.Header) map[string]string {
+	redacted := make(map[string]string, len(header))
+	for name, values := range header {
+		if strings.EqualFold(name, AuthHeaderName) {
+			redacted[name] = "Token " + logMaskedValue
+			continue
+		}
+		redacted[name] = strings.Join(values, ", ")
+	}
+	return redacted
+}
+
+// maskBody masks the values of the sensitive custom fields in a JSON body, other bodies are logged as is
+func (t *netboxLoggingTransport) maskBody(body []byte) string {
+	if len(body) == 0 || len(t.sensitiveCustomFields) == 0 {
+		return string(body)
+	}
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return string(body)
+	}
+	if !maskCustomFields(data, t.sensitiveCustomFields) {
+		return string(body)
+	}
+	masked, err := json.Marshal(data)
+	if err != nil {
+		return string(body)
+	}
+	return string(masked)
+}
+
+// maskCustomFields replaces the values of the sensitive fields of every custom_fields object in data,
+// be it a prefix, a list of them or a validation error. It tells whether anything was masked.
+func maskCustomFields(data interface{}, sensitive map[string]bool) bool {
+	masked := false
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if cfs, ok := value.(map[string]interface{}); ok && key == "custom_fields" {
+				for field, fieldValue := range cfs {
+					if sensitive[field] && fieldValue != nil {
+						cfs[field] = logMaskedValue
+						masked = true
+					}
+				}
+				continue
+			}
+			if maskCustomFields(value, sensitive) {
+				masked = true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if maskCustomFields(value, sensitive) {
+				masked = true
+			}
+		}
+	}
+	return masked
+}
@@ -0,0 +1,153 @@
+package netbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-log/tflogtest"
+)
+
+func TestNetboxLoggingTransport(t *testing.T) {
+	fake := newFakeNetbox()
+	defer fake.Close()
+	fake.lock.Lock()
+	p := fake.seedPrefix("10.16.0.0/24", nil, "active")
+	p.customFields["helpers"] = "10.16.0.1 10.16.0.2"
+	p.customFields["ipv4_acl_in"] = "acl-in"
+	fake.lock.Unlock()
+
+	var output bytes.Buffer
+	ctx := tflogtest.RootLogger(context.Background(), &output)
+	config := &Config{
+		ApiToken:              fakeNetboxToken,
+		Host:                  fake.host(),
+		BasePath:              "/api",
+		SensitiveCustomFields: []string{"helpers"},
+	}
+	if err := config.LoadAndValidate(ctx); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if _, err := getIpamPrefixByID(config, p.id); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	logged := output.String()
+	if strings.Contains(logged, fakeNetboxToken) {
+		t.Errorf("the api token is logged:\n%s", logged)
+	}
+	if strings.Contains(logged, "10.16.0.1 10.16.0.2") {
+		t.Errorf("the sensitive custom field is logged:\n%s", logged)
+	}
+
+	entries, err := tflogtest.MultilineJSONDecode(&output)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	var request, response map[string]interface{}
+	for _, entry := range entries {
+		if entry["@module"] != "provider."+logSubsystemHTTP || entry["@level"] != "trace" {
+			continue
+		}
+		switch entry["@message"] {
+		case "Sending request to NetBox":
+			request = entry
+		case "Received response from NetBox":
+			response = entry
+		}
+	}
+	if request == nil || response == nil {
+		t.Fatalf("the request or the response is not logged at TRACE level:\n%v", entries)
+	}
+
+	headers, _ := request["headers"].(map[string]interface{})
+	if headers[AuthHeaderName] != "Token "+logMaskedValue {
+		t.Errorf("Authorization header: expected %q, got %v", "Token "+logMaskedValue, headers[AuthHeaderName])
+	}
+	if response["status"] != float64(200) {
+		t.Errorf("status: expected 200, got %v", response["status"])
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(response["body"].(string)), &body); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	cfs, _ := body["custom_fields"].(map[string]interface{})
+	if cfs["helpers"] != logMaskedValue {
+		t.Errorf("helpers: expected %q, got %v", logMaskedValue, cfs["helpers"])
+	}
+	if cfs["ipv4_acl_in"] != "acl-in" {
+		t.Errorf("ipv4_acl_in: expected %q, got %v", "acl-in", cfs["ipv4_acl_in"])
+	}
+}
+
+func TestMaskCustomFields(t *testing.T) {
+	sensitive := map[string]bool{"helpers": true}
+	cases := map[string]struct {
+		body     string
+		expected string
+	}{
+		"prefix": {
+			body:     `{"id":1,"custom_fields":{"helpers":"10.0.0.1","ipv4_acl_in":"in"}}`,
+			expected: `{"custom_fields":{"helpers":"***","ipv4_acl_in":"in"},"id":1}`,
+		},
+		"list": {
+			body:     `{"count":1,"results":[{"custom_fields":{"helpers":"10.0.0.1"}}]}`,
+			expected: `{"count":1,"results":[{"custom_fields":{"helpers":"***"}}]}`,
+		},
+		"null value": {
+			body:     `{"custom_fields":{"helpers":null}}`,
+			expected: `{"custom_fields":{"helpers":null}}`,
+		},
+		"not json": {
+			body:     `<html>Bad Gateway</html>`,
+			expected: `<html>Bad Gateway</html>`,
+		},
+	}
+	transport := &netboxLoggingTransport{sensitiveCustomFields: sensitive}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if masked := transport.maskBody([]byte(c.body)); masked != c.expected {
+				t.Errorf("expected %s, got %s", c.expected, masked)
+			}
+		})
+	}
+}
+
+type testRoundTripper func(*http.Request) (*http.Response, error)
+
+func (f testRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// The request of the caller isn't modified, the body logged is sent as is
+func TestNetboxLoggingTransportLeavesRequestAlone(t *testing.T) {
+	var sent []byte
+	next := testRoundTripper(func(req *http.Request) (*http.Response, error) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		sent = body
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("{}"))}, nil
+	})
+	transport := newNetboxLoggingTransport(context.Background(), &Config{}, next)
+
+	req, err := http.NewRequest(http.MethodPost, "http://netbox/api/ipam/prefixes/", strings.NewReader(`{"prefix":"10.0.0.0/24"}`))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	body := req.Body
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if string(sent) != `{"prefix":"10.0.0.0/24"}` {
+		t.Errorf("expected the body sent as is, got %s", sent)
+	}
+	if req.Body != body {
+		t.Errorf("expected the body of the caller's request left alone")
+	}
+}
@@ -0,0 +1,92 @@
+package netbox
+
+import (
+	"strings"
+	"sync"
+)
+
+// Kinds of the objects looked up by name
+const (
+	lookupSite   = "site"
+	lookupRole   = "role"
+	lookupVlan   = "vlan"
+	lookupVrf    = "vrf"
+	lookupTenant = "tenant"
+)
+
+// lookupCache keeps the sites, VRFs, VLANs, roles and tenants looked up by name for the life of the
+// provider instance, so a plan with hundreds of prefixes looks each of them up once. Concurrent
+// lookups of the same name share a single request. Failed lookups aren't kept, the object may be
+// created in the meantime. A nil cache looks everything up.
+type lookupCache struct {
+	lock    sync.Mutex
+	entries map[string]interface{}
+	calls   map[string]*lookupCall
+	// generations of the kinds, bumped on invalidation so the lookups in flight aren't kept
+	generations map[string]uint64
+}
+
+type lookupCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+func newLookupCache() *lookupCache {
+	return &lookupCache{
+		entries:     make(map[string]interface{}),
+		calls:       make(map[string]*lookupCall),
+		generations: make(map[string]uint64),
+	}
+}
+
+// get returns the cached result of the lookup of name, or waits for the lookup in flight, or looks it up with fetch
+func (c *lookupCache) get(kind, name string, fetch func() (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return fetch()
+	}
+	key := kind + "/" + name
+
+	c.lock.Lock()
+	if value, ok := c.entries[key]; ok {
+		c.lock.Unlock()
+		return value, nil
+	}
+	if call, ok := c.calls[key]; ok {
+		c.lock.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &lookupCall{done: make(chan struct{})}
+	c.calls[key] = call
+	generation := c.generations[kind]
+	c.lock.Unlock()
+
+	call.value, call.err = fetch()
+
+	c.lock.Lock()
+	delete(c.calls, key)
+	if call.err == nil && c.generations[kind] == generation {
+		c.entries[key] = call.value
+	}
+	c.lock.Unlock()
+	close(call.done)
+
+	return call.value, call.err
+}
+
+// invalidate forgets the lookups of a kind, it's called whenever the provider creates, renames or deletes such an object
+func (c *lookupCache) invalidate(kind string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	prefix := kind + "/"
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+	c.generations[kind]++
+}
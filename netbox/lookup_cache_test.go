@@ -0,0 +1,134 @@
+package netbox
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fenglyu/go-netbox/netbox/client/dcim"
+	"github.com/fenglyu/go-netbox/netbox/models"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestLookupCacheSingleFlight(t *testing.T) {
+	cache := newLookupCache()
+	var fetches int32
+	release := make(chan struct{})
+	fetch := func() (interface{}, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return int64(3), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, 20)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = cache.get(lookupSite, "se1", fetch)
+		}(i)
+	}
+	// Let the lookups pile up on the one in flight
+	for {
+		cache.lock.Lock()
+		_, inFlight := cache.calls[lookupSite+"/se1"]
+		cache.lock.Unlock()
+		if inFlight {
+			break
+		}
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	if fetches != 1 {
+		t.Errorf("expected 1 fetch, got %d", fetches)
+	}
+	for i, result := range results {
+		if result != int64(3) {
+			t.Errorf("lookup %d: expected 3, got %v", i, result)
+		}
+	}
+	if _, err := cache.get(lookupSite, "se1", fetch); err != nil || fetches != 1 {
+		t.Errorf("expected the cached site, got %d fetches and %v", fetches, err)
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	cache := newLookupCache()
+	fetches := 0
+	fetch := func(value interface{}, err error) func() (interface{}, error) {
+		return func() (interface{}, error) {
+			fetches++
+			return value, err
+		}
+	}
+
+	// Failures aren't kept
+	if _, err := cache.get(lookupVrf, "activision", fetch(nil, fmt.Errorf("Unknow vrf activision , not found"))); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if v, _ := cache.get(lookupVrf, "activision", fetch(int64(1), nil)); v != int64(1) || fetches != 2 {
+		t.Errorf("expected vrf 1 after 2 fetches, got %v after %d", v, fetches)
+	}
+	// Names of other kinds don't collide
+	if v, _ := cache.get(lookupRole, "activision", fetch(int64(2), nil)); v != int64(2) || fetches != 3 {
+		t.Errorf("expected role 2 after 3 fetches, got %v after %d", v, fetches)
+	}
+
+	cache.invalidate(lookupVrf)
+	if v, _ := cache.get(lookupVrf, "activision", fetch(int64(4), nil)); v != int64(4) || fetches != 4 {
+		t.Errorf("expected vrf 4 after 4 fetches, got %v after %d", v, fetches)
+	}
+	if v, _ := cache.get(lookupRole, "activision", fetch(int64(5), nil)); v != int64(2) || fetches != 4 {
+		t.Errorf("expected the cached role 2 after 4 fetches, got %v after %d", v, fetches)
+	}
+
+	// A lookup which was in flight during the invalidation isn't kept
+	cache.get(lookupTenant, "cloud", func() (interface{}, error) {
+		cache.invalidate(lookupTenant)
+		return int64(6), nil
+	})
+	if v, _ := cache.get(lookupTenant, "cloud", fetch(int64(7), nil)); v != int64(7) {
+		t.Errorf("expected tenant 7, got %v", v)
+	}
+
+	var nilCache *lookupCache
+	nilCache.invalidate(lookupVrf)
+	if v, _ := nilCache.get(lookupVrf, "activision", fetch(int64(8), nil)); v != int64(8) {
+		t.Errorf("expected vrf 8 from a nil cache, got %v", v)
+	}
+}
+
+func TestGetModelIdCached(t *testing.T) {
+	m := &mockNetbox{}
+	m.sitesList = func(params *dcim.DcimSitesListParams) (*dcim.DcimSitesListOK, error) {
+		if *params.Name != "se1" {
+			return nil, mockAPIError("DcimSitesList", http.StatusNotFound)
+		}
+		name, slug := "se1", "se1"
+		count := int64(1)
+		site := &models.Site{ID: 3, Name: &name, Slug: &slug}
+		return &dcim.DcimSitesListOK{Payload: &dcim.DcimSitesListOKBody{Count: &count, Results: []*models.Site{site}}}, nil
+	}
+	config := m.config()
+	config.lookups = newLookupCache()
+
+	for i := 0; i < 3; i++ {
+		d := schema.TestResourceDataRaw(t, resourceIpamAvailablePrefixes().Schema, map[string]interface{}{"site": "se1"})
+		id, err := getModelId(config, d, "site")
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if id != 3 {
+			t.Errorf("expected site 3, got %d", id)
+		}
+	}
+	if len(m.calls) != 1 {
+		t.Errorf("expected 1 lookup, got %v", m.calls)
+	}
+}
@@ -0,0 +1,288 @@
+package netbox
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-openapi/runtime"
+
+	"github.com/fenglyu/go-netbox/netbox/client/dcim"
+	"github.com/fenglyu/go-netbox/netbox/client/extras"
+	"github.com/fenglyu/go-netbox/netbox/client/ipam"
+	"github.com/fenglyu/go-netbox/netbox/client/tenancy"
+	"github.com/fenglyu/go-netbox/netbox/models"
+)
+
+// mockNetbox stands for the netbox api in unit tests, every call is recorded and answered by
+// the stub of the operation, the operations without a stub fail.
+type mockNetbox struct {
+	calls []string
+
+	ipAddressesDelete       func(*ipam.IpamIPAddressesDeleteParams) (*ipam.IpamIPAddressesDeleteNoContent, error)
+	ipAddressesList         func(*ipam.IpamIPAddressesListParams) (*ipam.IpamIPAddressesListOK, error)
+	availablePrefixesCreate func(*ipam.IpamPrefixesAvailablePrefixesCreateParams) (*ipam.IpamPrefixesAvailablePrefixesCreateCreated, error)
+	availablePrefixesRead   func(*ipam.IpamPrefixesAvailablePrefixesReadParams) (*ipam.IpamPrefixesAvailablePrefixesReadOK, error)
+	prefixesCreate          func(*ipam.IpamPrefixesCreateParams) (*ipam.IpamPrefixesCreateCreated, error)
+	prefixesDelete          func(*ipam.IpamPrefixesDeleteParams) (*ipam.IpamPrefixesDeleteNoContent, error)
+	prefixesList            func(*ipam.IpamPrefixesListParams) (*ipam.IpamPrefixesListOK, error)
+	prefixesPartialUpdate   func(*ipam.IpamPrefixesPartialUpdateParams) (*ipam.IpamPrefixesPartialUpdateOK, error)
+	prefixesRead            func(*ipam.IpamPrefixesReadParams) (*ipam.IpamPrefixesReadOK, error)
+	rolesList               func(*ipam.IpamRolesListParams) (*ipam.IpamRolesListOK, error)
+	vlansDelete             func(*ipam.IpamVlansDeleteParams) (*ipam.IpamVlansDeleteNoContent, error)
+	vlansList               func(*ipam.IpamVlansListParams) (*ipam.IpamVlansListOK, error)
+	vrfsList                func(*ipam.IpamVrfsListParams) (*ipam.IpamVrfsListOK, error)
+	sitesList               func(*dcim.DcimSitesListParams) (*dcim.DcimSitesListOK, error)
+	tenantsList             func(*tenancy.TenancyTenantsListParams) (*tenancy.TenancyTenantsListOK, error)
+	tagsCreate              func(*extras.ExtrasTagsCreateParams) (*extras.ExtrasTagsCreateCreated, error)
+	tagsDelete              func(*extras.ExtrasTagsDeleteParams) (*extras.ExtrasTagsDeleteNoContent, error)
+	tagsList                func(*extras.ExtrasTagsListParams) (*extras.ExtrasTagsListOK, error)
+	tagsPartialUpdate       func(*extras.ExtrasTagsPartialUpdateParams) (*extras.ExtrasTagsPartialUpdateOK, error)
+	tagsRead                func(*extras.ExtrasTagsReadParams) (*extras.ExtrasTagsReadOK, error)
+}
+
+// config returns a provider config talking to the mock
+func (m *mockNetbox) config() *Config {
+	return &Config{ipam: m, dcim: m, tenancy: m, extras: m}
+}
+
+func (m *mockNetbox) call(operation string, stubbed bool) error {
+	m.calls = append(m.calls, operation)
+	if !stubbed {
+		return fmt.Errorf("%s is not stubbed", operation)
+	}
+	return nil
+}
+
+// mockAPIError is what the client returns for an error response of netbox
+func mockAPIError(operation string, code int) error {
+	return runtime.NewAPIError(operation, nil, code)
+}
+
+// mockInsufficientSpaceError is what the client returns for the 204 netbox answers when a parent is full
+func mockInsufficientSpaceError() error {
+	return runtime.NewAPIError("unexpected success response: content available as default response in error", nil, http.StatusNoContent)
+}
+
+func mockPrefix(id int64, cidr string, vrf *models.NestedVRF) *models.Prefix {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	ones, bits := network.Mask.Size()
+	family := int64(familyOfBits(bits))
+	familyLabel := fmt.Sprintf("IPv%d", family)
+	status, statusLabel := "active", "Active"
+	isPool := false
+	return &models.Prefix{
+		ID:           id,
+		Prefix:       &cidr,
+		PrefixLength: int64(ones),
+		Family:       &models.PrefixFamily{Label: &familyLabel, Value: &family},
+		Status:       &models.PrefixStatus{Label: &statusLabel, Value: &status},
+		IsPool:       &isPool,
+		Vrf:          vrf,
+		Tags:         []string{ownershipTag("prod")},
+		CustomFields: map[string]interface{}{"helpers": nil, "ipv4_acl_in": nil, "ipv4_acl_out": nil},
+	}
+}
+
+func mockPrefixList(prefixes ...*models.Prefix) *ipam.IpamPrefixesListOK {
+	count := int64(len(prefixes))
+	return &ipam.IpamPrefixesListOK{Payload: &ipam.IpamPrefixesListOKBody{Count: &count, Results: prefixes}}
+}
+
+// mockTags stubs the tag lists with the tags named, whose slugs are their names in lower case
+func (m *mockNetbox) mockTags(names ...string) {
+	m.tagsList = func(params *extras.ExtrasTagsListParams) (*extras.ExtrasTagsListOK, error) {
+		var results []*models.Tag
+		for i, name := range names {
+			name, slug := name, strings.ToLower(name)
+			if params.Name != nil && *params.Name != name || params.Slug != nil && *params.Slug != slug {
+				continue
+			}
+			results = append(results, &models.Tag{ID: int64(i + 1), Name: &name, Slug: &slug, Color: defaultTagColor})
+		}
+		count := int64(len(results))
+		return &extras.ExtrasTagsListOK{Payload: &extras.ExtrasTagsListOKBody{Count: &count, Results: results}}, nil
+	}
+}
+
+// mockPrefixes stubs the prefix reads and lists with a static set of prefixes, listing the ones containing
+// the prefix looked up the same way as netbox does
+func (m *mockNetbox) mockPrefixes(prefixes ...*models.Prefix) {
+	m.prefixesRead = func(params *ipam.IpamPrefixesReadParams) (*ipam.IpamPrefixesReadOK, error) {
+		for _, p := range prefixes {
+			if p.ID == params.ID {
+				return &ipam.IpamPrefixesReadOK{Payload: p}, nil
+			}
+		}
+		return nil, mockAPIError("ipam_prefixes_read", http.StatusNotFound)
+	}
+	m.prefixesList = func(params *ipam.IpamPrefixesListParams) (*ipam.IpamPrefixesListOK, error) {
+		var results []*models.Prefix
+		for _, p := range prefixes {
+			if params.Tag != nil {
+				continue
+			}
+			if params.Contains != nil {
+				_, outer, _ := net.ParseCIDR(*p.Prefix)
+				inner, _, _ := net.ParseCIDR(*params.Contains)
+				if !outer.Contains(inner) {
+					continue
+				}
+			}
+			if params.Prefix != nil && *params.Prefix != *p.Prefix {
+				continue
+			}
+			if params.VrfID != nil && *params.VrfID != "" && (p.Vrf == nil || strconv.FormatInt(p.Vrf.ID, 10) != *params.VrfID) {
+				continue
+			}
+			results = append(results, p)
+		}
+		return mockPrefixList(results...), nil
+	}
+}
+
+func (m *mockNetbox) IpamIPAddressesDelete(params *ipam.IpamIPAddressesDeleteParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamIPAddressesDeleteNoContent, error) {
+	if err := m.call("IpamIPAddressesDelete", m.ipAddressesDelete != nil); err != nil {
+		return nil, err
+	}
+	return m.ipAddressesDelete(params)
+}
+
+func (m *mockNetbox) IpamIPAddressesList(params *ipam.IpamIPAddressesListParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamIPAddressesListOK, error) {
+	if err := m.call("IpamIPAddressesList", m.ipAddressesList != nil); err != nil {
+		return nil, err
+	}
+	return m.ipAddressesList(params)
+}
+
+func (m *mockNetbox) IpamPrefixesAvailablePrefixesCreate(params *ipam.IpamPrefixesAvailablePrefixesCreateParams, authInfo runtime.ClientAuthInfoWriter, writer io.Writer) (*ipam.IpamPrefixesAvailablePrefixesCreateCreated, error) {
+	if err := m.call("IpamPrefixesAvailablePrefixesCreate", m.availablePrefixesCreate != nil); err != nil {
+		return nil, err
+	}
+	return m.availablePrefixesCreate(params)
+}
+
+func (m *mockNetbox) IpamPrefixesAvailablePrefixesRead(params *ipam.IpamPrefixesAvailablePrefixesReadParams, authInfo runtime.ClientAuthInfoWriter, writer io.Writer) (*ipam.IpamPrefixesAvailablePrefixesReadOK, error) {
+	if err := m.call("IpamPrefixesAvailablePrefixesRead", m.availablePrefixesRead != nil); err != nil {
+		return nil, err
+	}
+	return m.availablePrefixesRead(params)
+}
+
+func (m *mockNetbox) IpamPrefixesCreate(params *ipam.IpamPrefixesCreateParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamPrefixesCreateCreated, error) {
+	if err := m.call("IpamPrefixesCreate", m.prefixesCreate != nil); err != nil {
+		return nil, err
+	}
+	return m.prefixesCreate(params)
+}
+
+func (m *mockNetbox) IpamPrefixesDelete(params *ipam.IpamPrefixesDeleteParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamPrefixesDeleteNoContent, error) {
+	if err := m.call("IpamPrefixesDelete", m.prefixesDelete != nil); err != nil {
+		return nil, err
+	}
+	return m.prefixesDelete(params)
+}
+
+func (m *mockNetbox) IpamPrefixesList(params *ipam.IpamPrefixesListParams, authInfo runtime.ClientAuthInfoWriter, writer io.Writer) (*ipam.IpamPrefixesListOK, error) {
+	if err := m.call("IpamPrefixesList", m.prefixesList != nil); err != nil {
+		return nil, err
+	}
+	return m.prefixesList(params)
+}
+
+func (m *mockNetbox) IpamPrefixesPartialUpdate(params *ipam.IpamPrefixesPartialUpdateParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamPrefixesPartialUpdateOK, error) {
+	if err := m.call("IpamPrefixesPartialUpdate", m.prefixesPartialUpdate != nil); err != nil {
+		return nil, err
+	}
+	return m.prefixesPartialUpdate(params)
+}
+
+func (m *mockNetbox) IpamPrefixesRead(params *ipam.IpamPrefixesReadParams, authInfo runtime.ClientAuthInfoWriter, writer io.Writer) (*ipam.IpamPrefixesReadOK, error) {
+	if err := m.call("IpamPrefixesRead", m.prefixesRead != nil); err != nil {
+		return nil, err
+	}
+	return m.prefixesRead(params)
+}
+
+func (m *mockNetbox) IpamRolesList(params *ipam.IpamRolesListParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamRolesListOK, error) {
+	if err := m.call("IpamRolesList", m.rolesList != nil); err != nil {
+		return nil, err
+	}
+	return m.rolesList(params)
+}
+
+func (m *mockNetbox) IpamVlansDelete(params *ipam.IpamVlansDeleteParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamVlansDeleteNoContent, error) {
+	if err := m.call("IpamVlansDelete", m.vlansDelete != nil); err != nil {
+		return nil, err
+	}
+	return m.vlansDelete(params)
+}
+
+func (m *mockNetbox) IpamVlansList(params *ipam.IpamVlansListParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamVlansListOK, error) {
+	if err := m.call("IpamVlansList", m.vlansList != nil); err != nil {
+		return nil, err
+	}
+	return m.vlansList(params)
+}
+
+func (m *mockNetbox) IpamVrfsList(params *ipam.IpamVrfsListParams, authInfo runtime.ClientAuthInfoWriter) (*ipam.IpamVrfsListOK, error) {
+	if err := m.call("IpamVrfsList", m.vrfsList != nil); err != nil {
+		return nil, err
+	}
+	return m.vrfsList(params)
+}
+
+func (m *mockNetbox) DcimSitesList(params *dcim.DcimSitesListParams, authInfo runtime.ClientAuthInfoWriter) (*dcim.DcimSitesListOK, error) {
+	if err := m.call("DcimSitesList", m.sitesList != nil); err != nil {
+		return nil, err
+	}
+	return m.sitesList(params)
+}
+
+func (m *mockNetbox) TenancyTenantsList(params *tenancy.TenancyTenantsListParams, authInfo runtime.ClientAuthInfoWriter) (*tenancy.TenancyTenantsListOK, error) {
+	if err := m.call("TenancyTenantsList", m.tenantsList != nil); err != nil {
+		return nil, err
+	}
+	return m.tenantsList(params)
+}
+
+func (m *mockNetbox) ExtrasTagsCreate(params *extras.ExtrasTagsCreateParams, authInfo runtime.ClientAuthInfoWriter) (*extras.ExtrasTagsCreateCreated, error) {
+	if err := m.call("ExtrasTagsCreate", m.tagsCreate != nil); err != nil {
+		return nil, err
+	}
+	return m.tagsCreate(params)
+}
+
+func (m *mockNetbox) ExtrasTagsDelete(params *extras.ExtrasTagsDeleteParams, authInfo runtime.ClientAuthInfoWriter) (*extras.ExtrasTagsDeleteNoContent, error) {
+	if err := m.call("ExtrasTagsDelete", m.tagsDelete != nil); err != nil {
+		return nil, err
+	}
+	return m.tagsDelete(params)
+}
+
+func (m *mockNetbox) ExtrasTagsList(params *extras.ExtrasTagsListParams, authInfo runtime.ClientAuthInfoWriter) (*extras.ExtrasTagsListOK, error) {
+	if err := m.call("ExtrasTagsList", m.tagsList != nil); err != nil {
+		return nil, err
+	}
+	return m.tagsList(params)
+}
+
+func (m *mockNetbox) ExtrasTagsPartialUpdate(params *extras.ExtrasTagsPartialUpdateParams, authInfo runtime.ClientAuthInfoWriter) (*extras.ExtrasTagsPartialUpdateOK, error) {
+	if err := m.call("ExtrasTagsPartialUpdate", m.tagsPartialUpdate != nil); err != nil {
+		return nil, err
+	}
+	return m.tagsPartialUpdate(params)
+}
+
+func (m *mockNetbox) ExtrasTagsRead(params *extras.ExtrasTagsReadParams, authInfo runtime.ClientAuthInfoWriter) (*extras.ExtrasTagsReadOK, error) {
+	if err := m.call("ExtrasTagsRead", m.tagsRead != nil); err != nil {
+		return nil, err
+	}
+	return m.tagsRead(params)
+}
@@ -0,0 +1,51 @@
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+type clearedFieldsKey struct{}
+
+// withClearedFields has the request made with ctx send the fields with the given empty values, null for
+// the foreign keys. The generated models omit empty fields, so a partial update couldn't clear them otherwise.
+func withClearedFields(ctx context.Context, fields map[string]interface{}) context.Context {
+	if len(fields) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, clearedFieldsKey{}, fields)
+}
+
+// netboxClearTransport adds the cleared fields of the request context to its JSON body
+type netboxClearTransport struct {
+	next http.RoundTripper
+}
+
+func (t *netboxClearTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fields, _ := req.Context().Value(clearedFieldsKey{}).(map[string]interface{})
+	if len(fields) == 0 || req.Body == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	for field, value := range fields {
+		data[field] = value
+	}
+	if body, err = json.Marshal(data); err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	setRequestBody(req, body)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,200 @@
+package netbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-openapi/runtime"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+// NetboxAPIError is an error response of netbox, e.g.
+// {"prefix": ["Duplicate prefix found in VRF activision: 10.0.0.0/24"], "custom_fields": {"helpers": ["..."]}}
+type NetboxAPIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Detail is the message of the errors not bound to a field, like {"detail": "Not found."}
+	Detail string
+	// FieldErrors are the validation errors keyed by field, nested fields are joined with dots
+	FieldErrors map[string][]string
+	Body        string
+}
+
+func (e *NetboxAPIError) Error() string {
+	var messages []string
+	if e.Detail != "" {
+		messages = append(messages, e.Detail)
+	}
+	for _, field := range e.fields() {
+		messages = append(messages, fmt.Sprintf("%s: %s", field, strings.Join(e.FieldErrors[field], " ")))
+	}
+	if len(messages) == 0 && e.Body != "" {
+		messages = append(messages, e.Body)
+	}
+	return fmt.Sprintf("NetBox answered %s %s with %d %s: %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode), strings.Join(messages, "; "))
+}
+
+func (e *NetboxAPIError) fields() []string {
+	fields := make([]string, 0, len(e.FieldErrors))
+	for field := range e.FieldErrors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// decodeNetboxAPIError reads the error response of netbox, which is JSON unless a proxy answered in its place
+func decodeNetboxAPIError(res *http.Response) *NetboxAPIError {
+	apiErr := &NetboxAPIError{
+		Method:      res.Request.Method,
+		Path:        res.Request.URL.Path,
+		StatusCode:  res.StatusCode,
+		FieldErrors: make(map[string][]string),
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		apiErr.Detail = fmt.Sprintf("cannot read the response body: %v", err)
+		return apiErr
+	}
+	apiErr.Body = strings.TrimSpace(string(body))
+
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return apiErr
+	}
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			switch key {
+			case "detail", "non_field_errors", "__all__":
+				messages := netboxErrorMessages(value)
+				if apiErr.Detail != "" {
+					messages = append([]string{apiErr.Detail}, messages...)
+				}
+				apiErr.Detail = strings.Join(messages, " ")
+			default:
+				collectFieldErrors(apiErr.FieldErrors, key, value)
+			}
+		}
+	default:
+		apiErr.Detail = strings.Join(netboxErrorMessages(v), " ")
+	}
+	return apiErr
+}
+
+// collectFieldErrors flattens the errors of nested fields, like custom fields, into dotted fields
+func collectFieldErrors(fieldErrors map[string][]string, field string, value interface{}) {
+	if nested, ok := value.(map[string]interface{}); ok {
+		for key, v := range nested {
+			collectFieldErrors(fieldErrors, field+"."+key, v)
+		}
+		return
+	}
+	fieldErrors[field] = append(fieldErrors[field], netboxErrorMessages(value)...)
+}
+
+func netboxErrorMessages(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var messages []string
+		for _, m := range v {
+			messages = append(messages, netboxErrorMessages(m)...)
+		}
+		return messages
+	case nil:
+		return nil
+	default:
+		data, _ := json.Marshal(v)
+		return []string{string(data)}
+	}
+}
+
+// netboxErrorTransport turns the error responses of netbox into a NetboxAPIError, which reaches the caller
+// through the go-openapi client as is. The client would otherwise only report the status of the response.
+// A creation answered with a 204 is an error too, that's how netbox 2.8 tells a parent prefix is full.
+type netboxErrorTransport struct {
+	next http.RoundTripper
+}
+
+func (t *netboxErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+	if res.StatusCode < http.StatusBadRequest && !(req.Method == http.MethodPost && res.StatusCode == http.StatusNoContent) {
+		return res, nil
+	}
+	defer res.Body.Close()
+	return nil, decodeNetboxAPIError(res)
+}
+
+// asNetboxAPIError returns the netbox error response err wraps, if any
+func asNetboxAPIError(err error) (*NetboxAPIError, bool) {
+	var apiErr *NetboxAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	return nil, false
+}
+
+// isNotFound tells whether err is netbox answering that the object doesn't exist, as opposed to any
+// other failure. The generated client reports the status alone when the error transport isn't in the chain.
+func isNotFound(err error) bool {
+	if apiErr, ok := asNetboxAPIError(err); ok {
+		return apiErr.StatusCode == http.StatusNotFound
+	}
+	var clientErr *runtime.APIError
+	if errors.As(err, &clientErr) {
+		return clientErr.Code == http.StatusNotFound
+	}
+	return false
+}
+
+// netboxDiag turns an error into diagnostics, one per field netbox rejected pointing at the matching attribute
+func netboxDiag(err error) diag.Diagnostics {
+	if err == nil {
+		return nil
+	}
+	apiErr, ok := asNetboxAPIError(err)
+	if !ok {
+		return diag.FromErr(err)
+	}
+
+	var diags diag.Diagnostics
+	if apiErr.Detail != "" || len(apiErr.FieldErrors) == 0 {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  apiErr.Error(),
+			Detail:   err.Error(),
+		})
+	}
+	for _, field := range apiErr.fields() {
+		diags = append(diags, diag.Diagnostic{
+			Severity:      diag.Error,
+			Summary:       fmt.Sprintf("Invalid %s: %s", field, strings.Join(apiErr.FieldErrors[field], " ")),
+			Detail:        err.Error(),
+			AttributePath: netboxAttributePath(field),
+		})
+	}
+	return diags
+}
+
+// netboxAttributePath returns the path of the attribute matching a netbox field,
+// custom fields being the single block of the custom_fields list
+func netboxAttributePath(field string) cty.Path {
+	parts := strings.Split(field, ".")
+	path := cty.GetAttrPath(parts[0])
+	if parts[0] == "custom_fields" && len(parts) > 1 {
+		path = path.IndexInt(0).GetAttr(parts[1])
+	}
+	return path
+}
@@ -0,0 +1,197 @@
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+func TestDecodeNetboxAPIError(t *testing.T) {
+	cases := map[string]struct {
+		code     int
+		body     string
+		detail   string
+		fields   map[string][]string
+		expected string
+	}{
+		"not found": {
+			code:     http.StatusNotFound,
+			body:     `{"detail": "Not found."}`,
+			detail:   "Not found.",
+			fields:   map[string][]string{},
+			expected: "NetBox answered PATCH /api/ipam/prefixes/20/ with 404 Not Found: Not found.",
+		},
+		"field errors": {
+			code: http.StatusBadRequest,
+			body: `{"prefix": ["Duplicate prefix found in VRF activision: 10.0.0.0/24"], "status": ["\"gone\" is not a valid choice."]}`,
+			fields: map[string][]string{
+				"prefix": {"Duplicate prefix found in VRF activision: 10.0.0.0/24"},
+				"status": {`"gone" is not a valid choice.`},
+			},
+			expected: `NetBox answered PATCH /api/ipam/prefixes/20/ with 400 Bad Request: prefix: Duplicate prefix found in VRF activision: 10.0.0.0/24; status: "gone" is not a valid choice.`,
+		},
+		"nested field errors": {
+			code:   http.StatusBadRequest,
+			body:   `{"custom_fields": {"helpers": ["Invalid value."]}, "non_field_errors": ["The fields prefix, vrf must make a unique set."]}`,
+			detail: "The fields prefix, vrf must make a unique set.",
+			fields: map[string][]string{
+				"custom_fields.helpers": {"Invalid value."},
+			},
+			expected: "NetBox answered PATCH /api/ipam/prefixes/20/ with 400 Bad Request: The fields prefix, vrf must make a unique set.; custom_fields.helpers: Invalid value.",
+		},
+		"proxy page": {
+			code:     http.StatusBadGateway,
+			body:     "<html><body>502 Bad Gateway</body></html>\n",
+			fields:   map[string][]string{},
+			expected: "NetBox answered PATCH /api/ipam/prefixes/20/ with 502 Bad Gateway: <html><body>502 Bad Gateway</body></html>",
+		},
+	}
+
+	for tn, tc := range cases {
+		req, _ := http.NewRequest(http.MethodPatch, "http://netbox.k8s.me/api/ipam/prefixes/20/", nil)
+		res := &http.Response{
+			StatusCode: tc.code,
+			Body:       ioutil.NopCloser(strings.NewReader(tc.body)),
+			Request:    req,
+		}
+		apiErr := decodeNetboxAPIError(res)
+		if apiErr.StatusCode != tc.code || apiErr.Detail != tc.detail || !reflect.DeepEqual(apiErr.FieldErrors, tc.fields) {
+			t.Fatalf("%s: unexpected error %#v", tn, apiErr)
+		}
+		if apiErr.Error() != tc.expected {
+			t.Fatalf("%s: expected %q, got %q", tn, tc.expected, apiErr.Error())
+		}
+	}
+}
+
+func TestNetboxDiag(t *testing.T) {
+	apiErr := &NetboxAPIError{
+		Method:     http.MethodPost,
+		Path:       "/api/ipam/prefixes/",
+		StatusCode: http.StatusBadRequest,
+		FieldErrors: map[string][]string{
+			"vrf":                   {`Invalid pk "9" - object does not exist.`},
+			"custom_fields.helpers": {"Invalid value."},
+		},
+	}
+	// the http client and the provider both wrap the error
+	err := fmt.Errorf("Cannot create prefix 10.0.0.0/24: %w", &url.Error{Op: "Post", URL: "http://netbox.k8s.me/api/ipam/prefixes/", Err: apiErr})
+
+	diags := netboxDiag(err)
+	if len(diags) != 2 {
+		t.Fatalf("expected a diagnostic per field, got %v", diags)
+	}
+	expected := []struct {
+		summary string
+		path    cty.Path
+	}{
+		{summary: "Invalid custom_fields.helpers: Invalid value.", path: cty.GetAttrPath("custom_fields").IndexInt(0).GetAttr("helpers")},
+		{summary: `Invalid vrf: Invalid pk "9" - object does not exist.`, path: cty.GetAttrPath("vrf")},
+	}
+	for i, e := range expected {
+		if diags[i].Severity != diag.Error || diags[i].Summary != e.summary || !diags[i].AttributePath.Equals(e.path) {
+			t.Fatalf("expected %q at %#v, got %q at %#v", e.summary, e.path, diags[i].Summary, diags[i].AttributePath)
+		}
+		if !strings.HasPrefix(diags[i].Detail, "Cannot create prefix 10.0.0.0/24") {
+			t.Fatalf("expected the detail to keep the context, got %q", diags[i].Detail)
+		}
+	}
+
+	if diags := netboxDiag(fmt.Errorf("Cannot determine prefix with ID 20")); len(diags) != 1 || diags[0].AttributePath != nil {
+		t.Fatalf("expected a single diagnostic, got %v", diags)
+	}
+	if diags := netboxDiag(nil); diags != nil {
+		t.Fatalf("expected no diagnostic, got %v", diags)
+	}
+}
+
+func TestNetboxErrorTransport(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	d := testAvailablePrefixesData(t, fmt.Sprintf("%d", f.parentPrefixID), map[string]interface{}{
+		"prefix":      "10.0.0.0/12",
+		"description": strings.Repeat("x", 201),
+	})
+	diags := resourceIpamAvailablePrefixesUpdate(context.Background(), d, config)
+	if len(diags) != 1 || !diags[0].AttributePath.Equals(cty.GetAttrPath("description")) {
+		t.Fatalf("expected a diagnostic on description, got %v", diags)
+	}
+	if diags[0].Summary != "Invalid description: Ensure this field has no more than 200 characters." {
+		t.Fatalf("unexpected summary %q", diags[0].Summary)
+	}
+
+	_, err = getIpamPrefixByID(config, 9999)
+	if apiErr, ok := asNetboxAPIError(err); !ok || apiErr.StatusCode != http.StatusNotFound || apiErr.Detail != "Not found." {
+		t.Fatalf("expected a 404 error, got %v", err)
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	cases := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"netbox 404":   {err: &NetboxAPIError{StatusCode: http.StatusNotFound}, expected: true},
+		"wrapped 404":  {err: fmt.Errorf("Cannot determine prefix with ID 20: %w", &NetboxAPIError{StatusCode: http.StatusNotFound}), expected: true},
+		"client 404":   {err: mockAPIError("ipam_prefixes_read", http.StatusNotFound), expected: true},
+		"netbox 403":   {err: &NetboxAPIError{StatusCode: http.StatusForbidden}},
+		"client 502":   {err: mockAPIError("ipam_prefixes_read", http.StatusBadGateway)},
+		"other errors": {err: fmt.Errorf("Cannot determine prefix with ID 20")},
+		"nil":          {},
+	}
+	for tn, tc := range cases {
+		if got := isNotFound(tc.err); got != tc.expected {
+			t.Errorf("%s: expected %t, got %t", tn, tc.expected, got)
+		}
+	}
+}
+
+func TestResourceIpamAvailablePrefixesDeletedOutOfBand(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	f.lock.Lock()
+	p := f.seedPrefix("10.0.0.0/24", nil, "active")
+	f.lock.Unlock()
+	d := testAvailablePrefixesData(t, fmt.Sprintf("%d", p.id), map[string]interface{}{
+		"parent_prefix_id": int(f.parentPrefixID),
+		"prefix":           "10.0.0.0/24",
+	})
+	if diags := resourceIpamAvailablePrefixesRead(context.Background(), d, config); diags.HasError() || d.Id() == "" {
+		t.Fatalf("expected the prefix read, got %v", diags)
+	}
+
+	// Deleted in the UI
+	f.lock.Lock()
+	delete(f.prefixes, p.id)
+	f.lock.Unlock()
+
+	if diags := resourceIpamAvailablePrefixesRead(context.Background(), d, config); diags.HasError() || d.Id() != "" {
+		t.Fatalf("expected the prefix removed from the state, got %v with ID %q", diags, d.Id())
+	}
+
+	d.SetId(fmt.Sprintf("%d", p.id))
+	if diags := resourceIpamAvailablePrefixesDelete(context.Background(), d, config); diags.HasError() || d.Id() != "" {
+		t.Fatalf("expected the delete to succeed, got %v with ID %q", diags, d.Id())
+	}
+	if err := DeletePrefix(config, p.id); err != nil {
+		t.Fatalf("expected the delete to succeed, got %v", err)
+	}
+}
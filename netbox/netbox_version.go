@@ -0,0 +1,206 @@
+package netbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// netboxVersion is the version of the NetBox API, e.g. 2.8
+type netboxVersion struct {
+	major int
+	minor int
+}
+
+var (
+	// The generated client speaks the API of NetBox 2.8, the newer ones are translated by netboxCompatTransport
+	netboxMinVersion = netboxVersion{2, 8}
+	// NetBox 4 replaced the site of the prefixes with a scope
+	netboxMaxVersion = netboxVersion{3, 7}
+
+	// The tags are nested objects, which must exist before they are assigned
+	netboxNestedTagsVersion = netboxVersion{2, 9}
+	// The id__in filter is gone, the ids are repeated
+	netboxRepeatedIDsVersion = netboxVersion{2, 10}
+	// created is a date time rather than a date
+	netboxCreatedDateTimeVersion = netboxVersion{3, 1}
+
+	netboxVersionRegexp = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+)
+
+// parseNetboxVersion reads the version sent in the API-Version header, or by the status endpoint, e.g. 3.4.2
+func parseNetboxVersion(s string) (netboxVersion, error) {
+	m := netboxVersionRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return netboxVersion{}, fmt.Errorf("Cannot read the NetBox API version %q", s)
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return netboxVersion{major, minor}, nil
+}
+
+func (v netboxVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+func (v netboxVersion) atLeast(o netboxVersion) bool {
+	return v.major > o.major || v.major == o.major && v.minor >= o.minor
+}
+
+// negotiateNetboxVersion picks the version the provider talks to NetBox with, the API version of NetBox
+// unless it's unknown, which is the case of the proxies dropping the API-Version header.
+func negotiateNetboxVersion(ctx context.Context, apiVersion string) (netboxVersion, error) {
+	if apiVersion == "" {
+		tflog.SubsystemWarn(ctx, logSubsystemHTTP, "Unknown NetBox API version, assuming the oldest one supported", map[string]interface{}{
+			"api_version": netboxMinVersion.String(),
+		})
+		return netboxMinVersion, nil
+	}
+	v, err := parseNetboxVersion(apiVersion)
+	if err != nil {
+		return netboxVersion{}, err
+	}
+	if !v.atLeast(netboxMinVersion) || v.atLeast(netboxVersion{netboxMaxVersion.major, netboxMaxVersion.minor + 1}) {
+		return netboxVersion{}, fmt.Errorf("NetBox API version %s is not supported, the provider supports NetBox %s to %s", apiVersion, netboxMinVersion, netboxMaxVersion)
+	}
+	return v, nil
+}
+
+// netboxCompatTransport translates the requests of the generated client, which are the ones of NetBox 2.8,
+// to the API version of NetBox, and the responses back.
+type netboxCompatTransport struct {
+	version netboxVersion
+	next    http.RoundTripper
+}
+
+func (t *netboxCompatTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.version.atLeast(netboxNestedTagsVersion) {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if t.version.atLeast(netboxRepeatedIDsVersion) {
+		q := req.URL.Query()
+		if ids := q.Get("id__in"); ids != "" {
+			q.Del("id__in")
+			for _, id := range strings.Split(ids, ",") {
+				q.Add("id", id)
+			}
+			req.URL.RawQuery = q.Encode()
+		}
+	}
+	if req.Body != nil && strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if body, err = t.request(body); err != nil {
+			return nil, err
+		}
+		setRequestBody(req, body)
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.Body == nil || !strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		return res, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if body, err = t.response(body); err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Del("Content-Length")
+	return res, nil
+}
+
+// request assigns the tags by their names, which NetBox looks the tag objects up with
+func (t *netboxCompatTransport) request(body []byte) ([]byte, error) {
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(body, &data); err != nil {
+		// Not an object, such as a bulk request, left as is
+		return body, nil
+	}
+	tags, ok := data["tags"].([]interface{})
+	if !ok {
+		return body, nil
+	}
+	nested := make([]interface{}, 0, len(tags))
+	for _, tag := range tags {
+		if name, ok := tag.(string); ok {
+			nested = append(nested, map[string]interface{}{"name": name})
+		} else {
+			nested = append(nested, tag)
+		}
+	}
+	data["tags"] = nested
+	return json.Marshal(data)
+}
+
+// response flattens the nested tags to their names and the created date times to dates
+func (t *netboxCompatTransport) response(body []byte) ([]byte, error) {
+	var data interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		// Left to the client to report
+		return body, nil
+	}
+	t.flatten(data)
+	return json.Marshal(data)
+}
+
+func (t *netboxCompatTransport) flatten(data interface{}) {
+	switch v := data.(type) {
+	case []interface{}:
+		for _, item := range v {
+			t.flatten(item)
+		}
+	case map[string]interface{}:
+		for key, value := range v {
+			switch {
+			case key == "tags":
+				if tags, ok := value.([]interface{}); ok {
+					for i, tag := range tags {
+						if nested, ok := tag.(map[string]interface{}); ok {
+							tags[i] = nested["name"]
+						}
+					}
+					continue
+				}
+			case key == "created" && t.version.atLeast(netboxCreatedDateTimeVersion):
+				if created, ok := value.(string); ok && len(created) > len("2006-01-02") {
+					v[key] = created[:len("2006-01-02")]
+					continue
+				}
+			case key == "custom_fields":
+				// Named by the users, they may be called tags
+				continue
+			}
+			t.flatten(value)
+		}
+	}
+}
+
+// setRequestBody replaces the body of a cloned request
+func setRequestBody(req *http.Request, body []byte) {
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+}
@@ -0,0 +1,212 @@
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestNegotiateNetboxVersion(t *testing.T) {
+	cases := map[string]struct {
+		expected netboxVersion
+		err      string
+	}{
+		"2.8":    {expected: netboxVersion{2, 8}},
+		"2.10":   {expected: netboxVersion{2, 10}},
+		"3.4.2":  {expected: netboxVersion{3, 4}},
+		"v3.7":   {expected: netboxVersion{3, 7}},
+		"":       {expected: netboxMinVersion},
+		"2.4.7":  {err: "NetBox API version 2.4.7 is not supported, the provider supports NetBox 2.8 to 3.7"},
+		"4.0":    {err: "NetBox API version 4.0 is not supported, the provider supports NetBox 2.8 to 3.7"},
+		"latest": {err: `Cannot read the NetBox API version "latest"`},
+	}
+
+	for apiVersion, tc := range cases {
+		v, err := negotiateNetboxVersion(context.Background(), apiVersion)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%q: expected error %q, got %v", apiVersion, tc.err, err)
+			}
+			continue
+		}
+		if err != nil || v != tc.expected {
+			t.Errorf("%q: expected version %s, got %s, %v", apiVersion, tc.expected, v, err)
+		}
+	}
+}
+
+func TestLoadAndValidateNetboxVersion(t *testing.T) {
+	cases := map[string]struct {
+		apiVersion     string
+		hideAPIVersion bool
+		expected       netboxVersion
+		err            string
+	}{
+		"header": {
+			apiVersion: "3.4",
+			expected:   netboxVersion{3, 4},
+		},
+		"status endpoint": {
+			apiVersion:     "3.2",
+			hideAPIVersion: true,
+			expected:       netboxVersion{3, 2},
+		},
+		"unknown": {
+			apiVersion:     "2.8",
+			hideAPIVersion: true,
+			expected:       netboxVersion{2, 8},
+		},
+		"too old": {
+			apiVersion: "2.4",
+			err:        "NetBox API version 2.4 is not supported",
+		},
+		"too recent": {
+			apiVersion:     "4.1",
+			hideAPIVersion: true,
+			err:            "NetBox API version 4.1.1 is not supported",
+		},
+	}
+
+	for tn, tc := range cases {
+		f := newFakeNetbox()
+		f.apiVersion, f.hideAPIVersion = tc.apiVersion, tc.hideAPIVersion
+		config, err := f.config()
+		f.Close()
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tn, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tn, err)
+		}
+		if config.apiVersion != tc.expected {
+			t.Errorf("%s: expected version %s, got %s", tn, tc.expected, config.apiVersion)
+		}
+	}
+}
+
+func TestNetboxCompatTransport(t *testing.T) {
+	var query string
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		body = nil
+		if data, _ := ioutil.ReadAll(r.Body); len(data) > 0 {
+			json.Unmarshal(data, &body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results": [{"id": 12345678901, "created": "2022-05-04T09:30:00.123456Z",
+			"tags": [{"id": 1, "name": "Gke", "slug": "gke"}], "custom_fields": {"tags": [{"name": "kept"}]}}]}`))
+	}))
+	defer server.Close()
+
+	cases := map[string]struct {
+		version  netboxVersion
+		query    string
+		tags     interface{}
+		response string
+	}{
+		"2.8": {
+			version:  netboxVersion{2, 8},
+			query:    "id__in=1%2C2",
+			tags:     []interface{}{"Gke"},
+			response: `{"results": [{"id": 12345678901, "created": "2022-05-04T09:30:00.123456Z",`,
+		},
+		"2.9": {
+			version:  netboxVersion{2, 9},
+			query:    "id__in=1%2C2",
+			tags:     []interface{}{map[string]interface{}{"name": "Gke"}},
+			response: `{"results":[{"created":"2022-05-04T09:30:00.123456Z","custom_fields":{"tags":[{"name":"kept"}]},"id":12345678901,"tags":["Gke"]}]}`,
+		},
+		"3.4": {
+			version:  netboxVersion{3, 4},
+			query:    "id=1&id=2",
+			tags:     []interface{}{map[string]interface{}{"name": "Gke"}},
+			response: `{"results":[{"created":"2022-05-04","custom_fields":{"tags":[{"name":"kept"}]},"id":12345678901,"tags":["Gke"]}]}`,
+		},
+	}
+
+	for tn, tc := range cases {
+		client := &http.Client{Transport: &netboxCompatTransport{version: tc.version, next: http.DefaultTransport}}
+		req, _ := http.NewRequest(http.MethodPatch, server.URL+"/api/ipam/prefixes/?id__in=1%2C2", strings.NewReader(`{"tags": ["Gke"], "description": "uplinks"}`))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tn, err)
+		}
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if query != tc.query {
+			t.Errorf("%s: expected query %s, got %s", tn, tc.query, query)
+		}
+		if !reflect.DeepEqual(body["tags"], tc.tags) || body["description"] != "uplinks" {
+			t.Errorf("%s: expected tags %v, got %v", tn, tc.tags, body)
+		}
+		if !strings.HasPrefix(string(data), tc.response) {
+			t.Errorf("%s: expected response %s, got %s", tn, tc.response, data)
+		}
+	}
+}
+
+func TestResourceIpamAvailablePrefixesNetboxVersions(t *testing.T) {
+	for _, apiVersion := range []string{"2.8", "2.10", "3.4"} {
+		f := newFakeNetbox()
+		f.apiVersion = apiVersion
+		f.lock.Lock()
+		f.ensureTag("gke")
+		f.lock.Unlock()
+		config, err := f.config()
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", apiVersion, err)
+		}
+
+		r := resourceIpamAvailablePrefixes()
+		raw := map[string]interface{}{
+			"parent_prefix_id": int(f.parentPrefixWithVrfID),
+			"prefix_length":    28,
+			"status":           "reserved",
+			"vrf":              "activision",
+			"tags":             []interface{}{"gke"},
+			"custom_fields":    []interface{}{map[string]interface{}{"helpers": "10.0.0.1"}},
+		}
+		d := schema.TestResourceDataRaw(t, r.Schema, raw)
+		if diags := resourceIpamAvailablePrefixesCreate(context.Background(), d, config); diags.HasError() {
+			t.Fatalf("%s: unexpected error %v", apiVersion, diags)
+		}
+		if diags := resourceIpamAvailablePrefixesRead(context.Background(), d, config); diags.HasError() {
+			t.Fatalf("%s: unexpected error %v", apiVersion, diags)
+		}
+		if tags := convertStringSet(d.Get("tags").(*schema.Set)); !reflect.DeepEqual(tags, []string{"gke"}) {
+			t.Errorf("%s: expected the tags [gke], got %v", apiVersion, tags)
+		}
+		if status, created := d.Get("status").(string), d.Get("created").(string); status != "reserved" || len(created) != len("2006-01-02") {
+			t.Errorf("%s: expected a reserved prefix created on a date, got %s, %s", apiVersion, status, created)
+		}
+
+		// The tags which don't exist are created
+		config.AutoCreateTags = true
+		d = schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+			"parent_prefix_id": int(f.parentPrefixID),
+			"prefix_length":    28,
+			"tags":             []interface{}{"gcp"},
+			"custom_fields":    []interface{}{map[string]interface{}{}},
+		})
+		if diags := resourceIpamAvailablePrefixesCreate(context.Background(), d, config); diags.HasError() {
+			t.Errorf("%s: unexpected error %v", apiVersion, diags)
+		}
+		if tags := convertStringSet(d.Get("tags").(*schema.Set)); !reflect.DeepEqual(tags, []string{"gcp"}) {
+			t.Errorf("%s: expected the tags [gcp], got %v", apiVersion, tags)
+		}
+		f.Close()
+	}
+}
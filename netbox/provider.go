@@ -42,10 +42,77 @@ func Provider() *schema.Provider {
 				Type:     schema.TypeString,
 				Optional: true,
 			},
+			"workspace": {
+				Type:     schema.TypeString,
+				Optional: true,
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{
+					"NETBOX_WORKSPACE",
+				}, ""),
+				Description: "Name unique to the state, stamped on the prefixes created so the ones no state tracks can be found",
+			},
+			"auto_create_tags": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{
+					"NETBOX_AUTO_CREATE_TAGS",
+				}, false),
+				Description: "Create the tags of the prefixes which don't exist, rather than failing the plan",
+			},
+			"lock_backend": {
+				Type:     schema.TypeString,
+				Optional: true,
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{
+					"NETBOX_LOCK_BACKEND",
+				}, lockBackendMemory),
+				ValidateDiagFunc: StringInSliceDiagFunc(lockBackends, false),
+				Description:      "How the allocations under a parent prefix are locked across runs, one of memory (within the run only), file or netbox_tag",
+			},
+			"lock_dir": {
+				Type:     schema.TypeString,
+				Optional: true,
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{
+					"NETBOX_LOCK_DIR",
+				}, nil),
+				Description: "The directory of the lock files of the file lock backend, on a filesystem shared by the runs",
+			},
+			"lock_ttl": {
+				Type:     schema.TypeString,
+				Optional: true,
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{
+					"NETBOX_LOCK_TTL",
+				}, nil),
+				ValidateDiagFunc: DurationAtLeastDiagFunc(time.Second),
+				Description:      "The lease of a lock, renewed while it is held, e.g. \"2m\", at least \"1s\". A run which crashed holds the lock no longer than this",
+			},
+			"lock_timeout": {
+				Type:     schema.TypeString,
+				Optional: true,
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{
+					"NETBOX_LOCK_TIMEOUT",
+				}, nil),
+				ValidateDiagFunc: DurationAtLeastDiagFunc(time.Second),
+				Description:      "How long to wait for a lock held by another run, e.g. \"10m\", at least \"1s\"",
+			},
+			"trace_requests": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{
+					"NETBOX_TRACE_REQUESTS",
+				}, false),
+				Description: "Stamp every request with a correlation ID and log it with its status and latency",
+			},
+			"sensitive_custom_fields": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Custom fields whose values are masked in the logs",
+			},
 		},
 
 		DataSourcesMap: map[string]*schema.Resource{
 			"netbox_available_prefixes": dataSourceIpamAvailablePrefixes(),
+			"netbox_prefix":             dataSourceIpamPrefix(),
+			"netbox_prefixes":           dataSourceIpamPrefixes(),
 		},
 
 		ResourcesMap: ResourceMap(),
@@ -58,7 +125,7 @@ func Provider() *schema.Provider {
 			// We can therefore assume that if it's missing it's 0.10 or 0.11
 			terraformVersion = "0.11+compatible"
 		}
-		return providerConfigure(d, provider, terraformVersion)
+		return providerConfigure(ctx, d, provider, terraformVersion)
 	}
 	return provider
 }
@@ -66,27 +133,53 @@ func Provider() *schema.Provider {
 func ResourceMap() map[string]*schema.Resource {
 	return map[string]*schema.Resource{
 		"netbox_available_prefixes": resourceIpamAvailablePrefixes(),
+		"netbox_tag":                resourceExtrasTag(),
 		//"ipam_prefixes_available_ips":
 	}
 }
 
-func providerConfigure(d *schema.ResourceData, p *schema.Provider, terraformVersion string) (interface{}, diag.Diagnostics) {
+func providerConfigure(ctx context.Context, d *schema.ResourceData, p *schema.Provider, terraformVersion string) (interface{}, diag.Diagnostics) {
 	config := Config{
 		ApiToken: d.Get("api_token").(string),
 		Host:     d.Get("host").(string),
 		BasePath: d.Get("base_path").(string),
+
+		Workspace:      d.Get("workspace").(string),
+		AutoCreateTags: d.Get("auto_create_tags").(bool),
+
+		LockBackend: d.Get("lock_backend").(string),
+		LockDir:     d.Get("lock_dir").(string),
+
+		SensitiveCustomFields: convertStringSet(d.Get("sensitive_custom_fields").(*schema.Set)),
+		TraceRequests:         d.Get("trace_requests").(bool),
 	}
 
 	if v, ok := d.GetOk("request_timeout"); ok {
 		var err error
 		config.RequestTimeout, err = time.ParseDuration(v.(string))
 		if err != nil {
-			return nil, diag.FromErr(err)
+			return nil, netboxDiag(err)
+		}
+	}
+
+	if v, ok := d.GetOk("lock_ttl"); ok {
+		var err error
+		config.LockTTL, err = time.ParseDuration(v.(string))
+		if err != nil {
+			return nil, netboxDiag(err)
+		}
+	}
+
+	if v, ok := d.GetOk("lock_timeout"); ok {
+		var err error
+		config.LockTimeout, err = time.ParseDuration(v.(string))
+		if err != nil {
+			return nil, netboxDiag(err)
 		}
 	}
 
-	if err := config.LoadAndValidate(context.Background()); err != nil {
-		return nil, diag.FromErr(err)
+	if err := config.LoadAndValidate(ctx); err != nil {
+		return nil, netboxDiag(err)
 	}
 
 	return &config, nil
@@ -137,6 +137,9 @@ func init() {
 			return testAccProvider, nil
 		},
 	}
+	if multiEnvSearch(fakeNetboxEnvVars) != "" {
+		testAccFakeNetbox = fakeNetboxForAcc()
+	}
 	// check the existance of two test parent prefix id
 	checkPrefixId()
 }
@@ -180,7 +183,7 @@ func testPrefixExistWithID(parentPrefixId int) error {
 		return err
 	}
 
-	ipamPrefixesReadOK, err := config.client.Ipam.IpamPrefixesRead(&params, nil)
+	ipamPrefixesReadOK, err := config.ipam.IpamPrefixesRead(&params, nil, nil)
 	//t.Log(fmt.Sprintf("testPrefixExistWithID %v", ipamPrefixesReadOK))
 	if err != nil || ipamPrefixesReadOK == nil {
 		return fmt.Errorf("Cannot determine prefix with ID %d", parentPrefixId)
@@ -228,7 +231,7 @@ func TestAccProviderBasePath_setBasePath(t *testing.T) {
 		"random_prefix_length": randIntRange(t, 16, 30),
 		"random_suffix":        randString(t, 10),
 		"basePath":             "/api",
-		"host":                 "localhost:8080",
+		"host":                 multiEnvSearch(netboxHostEnvVars),
 		"parent_prefix_id":     testNetboxParentPrefixId,
 	}
 
@@ -0,0 +1,228 @@
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/fenglyu/go-netbox/netbox/client/extras"
+	"github.com/fenglyu/go-netbox/netbox/models"
+)
+
+const defaultTagColor = "9e9e9e"
+
+var (
+	tagSlugRegexp  = regexp.MustCompile(`^[-a-zA-Z0-9_]+$`)
+	tagColorRegexp = regexp.MustCompile(`^[0-9a-f]{6}$`)
+)
+
+func resourceExtrasTag() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: resourceExtrasTagCreate,
+		ReadContext:   resourceExtrasTagRead,
+		UpdateContext: resourceExtrasTagUpdate,
+		DeleteContext: resourceExtrasTagDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceExtrasTagImportState,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:             schema.TypeString,
+				Required:         true,
+				ValidateDiagFunc: StringLenBetween(1, 100),
+				Description:      "Name of the tag, which the prefixes are tagged with",
+			},
+			"slug": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Computed:         true,
+				ValidateDiagFunc: StringMatchDiagFunc(tagSlugRegexp, 100),
+				Description:      "Slug of the tag, derived from the name unless it's given",
+			},
+			"color": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          defaultTagColor,
+				ValidateDiagFunc: StringMatchDiagFunc(tagColorRegexp, 6),
+				Description:      "Color of the tag, in RGB hexadecimal, e.g. 9e9e9e",
+			},
+			"description": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				ValidateDiagFunc: StringLenBetween(0, 200),
+				Description:      "Describe the purpose of this tag",
+			},
+			"tagged_items": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Number of the objects tagged with this tag",
+			},
+		},
+
+		CustomizeDiff: planExtrasTag,
+	}
+}
+
+// planExtrasTag plans the slug derived from the name of a renamed tag as unknown, like the one of a new tag,
+// so the prefixes referring to it wait for the apply to find it
+func planExtrasTag(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if raw := d.GetRawConfig(); d.Id() != "" && d.HasChange("name") && !raw.IsNull() && raw.GetAttr("slug").IsNull() {
+		return d.SetNewComputed("slug")
+	}
+	return nil
+}
+
+func resourceExtrasTagCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	ctx = newLogSubsystem(ctx, config, logSubsystemTag)
+
+	name := d.Get("name").(string)
+	slug := d.Get("slug").(string)
+	if slug == "" {
+		slug = tagSlug(name)
+	}
+	params := extras.ExtrasTagsCreateParams{
+		Data: &models.Tag{
+			Name:        &name,
+			Slug:        &slug,
+			Color:       d.Get("color").(string),
+			Description: d.Get("description").(string),
+		},
+		Context: context.Background(),
+	}
+	res, err := config.extras.ExtrasTagsCreate(&params, nil)
+	config.lookups.invalidate(lookupTag)
+	if err != nil {
+		return netboxDiag(fmt.Errorf("Cannot create the tag %s: %w", name, err))
+	}
+
+	tflog.SubsystemInfo(ctx, logSubsystemTag, "Created tag", map[string]interface{}{
+		"id":   res.GetPayload().ID,
+		"slug": slug,
+	})
+	d.SetId(strconv.FormatInt(res.GetPayload().ID, 10))
+	return resourceExtrasTagRead(ctx, d, m)
+}
+
+func resourceExtrasTagRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	ctx = newLogSubsystem(ctx, config, logSubsystemTag)
+
+	id, err := strconv.ParseInt(d.Id(), 10, 64)
+	if err != nil {
+		return diag.Errorf("Unexpected ID %q of a tag, expected a number", d.Id())
+	}
+	params := extras.ExtrasTagsReadParams{
+		ID:      id,
+		Context: context.Background(),
+	}
+	res, err := config.extras.ExtrasTagsRead(&params, nil)
+	if isNotFound(err) {
+		// Deleted out of band, terraform plans to create it again
+		tflog.SubsystemWarn(ctx, logSubsystemTag, "Tag not found, removing it from the state", map[string]interface{}{
+			"id":   d.Id(),
+			"name": d.Get("name").(string),
+		})
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return netboxDiag(err)
+	}
+
+	tag := res.GetPayload()
+	var name, slug string
+	if tag.Name != nil {
+		name = *tag.Name
+	}
+	if tag.Slug != nil {
+		slug = *tag.Slug
+	}
+	d.Set("name", name)
+	d.Set("slug", slug)
+	d.Set("color", tag.Color)
+	d.Set("description", tag.Description)
+	d.Set("tagged_items", int(tag.TaggedItems))
+	return nil
+}
+
+func resourceExtrasTagUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	ctx = newLogSubsystem(ctx, config, logSubsystemTag)
+
+	id, err := strconv.ParseInt(d.Id(), 10, 64)
+	if err != nil {
+		return diag.Errorf("Unexpected ID %q of a tag, expected a number", d.Id())
+	}
+	name := d.Get("name").(string)
+	slug := d.Get("slug").(string)
+	data := &models.Tag{
+		Name:        &name,
+		Slug:        &slug,
+		Color:       d.Get("color").(string),
+		Description: d.Get("description").(string),
+	}
+	cleared := make(map[string]interface{})
+	if data.Description == "" {
+		cleared["description"] = ""
+	}
+	params := extras.ExtrasTagsPartialUpdateParams{
+		ID:      id,
+		Data:    data,
+		Context: withClearedFields(context.Background(), cleared),
+	}
+	_, err = config.extras.ExtrasTagsPartialUpdate(&params, nil)
+	config.lookups.invalidate(lookupTag)
+	if err != nil {
+		return netboxDiag(fmt.Errorf("Cannot update the tag %s: %w", name, err))
+	}
+	return resourceExtrasTagRead(ctx, d, m)
+}
+
+func resourceExtrasTagDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	ctx = newLogSubsystem(ctx, config, logSubsystemTag)
+
+	id, err := strconv.ParseInt(d.Id(), 10, 64)
+	if err != nil {
+		return diag.Errorf("Unexpected ID %q of a tag, expected a number", d.Id())
+	}
+	params := extras.ExtrasTagsDeleteParams{
+		ID:      id,
+		Context: context.Background(),
+	}
+	_, err = config.extras.ExtrasTagsDelete(&params, nil)
+	config.lookups.invalidate(lookupTag)
+	if isNotFound(err) {
+		tflog.SubsystemWarn(ctx, logSubsystemTag, "Tag already deleted", map[string]interface{}{
+			"id": d.Id(),
+		})
+		return nil
+	}
+	if err != nil {
+		return netboxDiag(err)
+	}
+	d.SetId("")
+	return nil
+}
+
+// resourceExtrasTagImportState imports a tag by its ID, its slug or its name
+func resourceExtrasTagImportState(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	config := meta.(*Config)
+
+	if _, err := strconv.ParseInt(d.Id(), 10, 64); err == nil {
+		return []*schema.ResourceData{d}, nil
+	}
+	tag, err := getExtrasTag(config, d.Id())
+	if err != nil {
+		return nil, fmt.Errorf("Cannot import the tag %s: %w", d.Id(), err)
+	}
+	d.SetId(strconv.FormatInt(tag.ID, 10))
+	return []*schema.ResourceData{d}, nil
+}
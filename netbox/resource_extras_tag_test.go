@@ -0,0 +1,343 @@
+package netbox
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/go-cty/cty/msgpack"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestAccNetboxTag_basic(t *testing.T) {
+	context := map[string]interface{}{
+		"random_prefix_length": randIntRange(t, 16, 30),
+		"random_suffix":        randString(t, 10),
+		"parent_prefix_id":     testNetboxParentPrefixId,
+	}
+
+	resource.ParallelTest(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		CheckDestroy:      testAccCheckAvailablePrefixesDestroyProducer(t),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccNetboxTagExample(context),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("netbox_tag.gke", "slug", Nprintf("tag-acc%{random_suffix}", context)),
+					resource.TestCheckResourceAttr("netbox_available_prefixes.tagged", "tags.#", "1"),
+				),
+			},
+			{
+				ResourceName:      "netbox_tag.gke",
+				ImportState:       true,
+				ImportStateId:     Nprintf("tag-acc%{random_suffix}", context),
+				ImportStateVerify: true,
+			},
+		},
+	})
+}
+
+func testAccNetboxTagExample(context map[string]interface{}) string {
+	return Nprintf(`
+provider "netbox" {
+	auto_create_tags = false
+}
+
+resource "netbox_tag" "gke" {
+	name        = "Tag-acc%{random_suffix}"
+	description = "gke nodes"
+}
+
+resource "netbox_available_prefixes" "tagged" {
+	parent_prefix_id = %{parent_prefix_id}
+	prefix_length    = %{random_prefix_length}
+	tags             = [netbox_tag.gke.slug]
+	custom_fields {}
+}`, context)
+}
+
+func TestResourceExtrasTag(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	r := resourceExtrasTag()
+
+	raw := map[string]interface{}{
+		"name":        "Uplinks GCP",
+		"description": "uplinks",
+	}
+	cfg := terraform.NewResourceConfigRaw(raw)
+	diff, err := r.Diff(context.Background(), nil, cfg, config)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	state, diags := r.Apply(context.Background(), nil, diff, config)
+	if diags.HasError() {
+		t.Fatalf("unexpected error %v", diags)
+	}
+	if slug, color := state.Attributes["slug"], state.Attributes["color"]; slug != "uplinks-gcp" || color != defaultTagColor {
+		t.Fatalf("expected the slug uplinks-gcp and the default color, got %s, %s", slug, color)
+	}
+
+	// The description is cleared
+	delete(raw, "description")
+	raw["color"] = "aa1409"
+	cfg = terraform.NewResourceConfigRaw(raw)
+	if diff, err = r.Diff(context.Background(), state, cfg, config); err != nil || diff.Empty() || diff.RequiresNew() {
+		t.Fatalf("expected an in-place diff, got %v, %v", diff, err)
+	}
+	if state, diags = r.Apply(context.Background(), state, diff, config); diags.HasError() {
+		t.Fatalf("unexpected error %v", diags)
+	}
+	if diff, err := r.Diff(context.Background(), state, cfg, config); err != nil || !diff.Empty() {
+		t.Fatalf("expected no diff once applied, got %v, %v", diff, err)
+	}
+	id, _ := strconv.ParseInt(state.ID, 10, 64)
+	f.lock.Lock()
+	tag := f.tags[id]
+	if tag.Description != "" || tag.Color != "aa1409" {
+		t.Errorf("expected the description cleared and the color updated, got %+v", tag)
+	}
+	// Deleted out of band
+	delete(f.tags, id)
+	f.lock.Unlock()
+
+	if state, diags = r.RefreshWithoutUpgrade(context.Background(), state, config); diags.HasError() || state != nil {
+		t.Fatalf("expected the tag removed from the state, got %v, %v", state, diags)
+	}
+	d := r.TestResourceData()
+	d.SetId(strconv.FormatInt(id, 10))
+	if diags := resourceExtrasTagDelete(context.Background(), d, config); diags.HasError() {
+		t.Errorf("expected the deleted tag ignored, got %v", diags)
+	}
+}
+
+func TestResourceExtrasTagImportState(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	f.lock.Lock()
+	f.ensureTag("Uplinks")
+	var id int64
+	for _, tag := range f.tags {
+		id = tag.ID
+	}
+	f.lock.Unlock()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	cases := map[string]string{
+		"uplinks":                 "",
+		"Uplinks":                 "",
+		strconv.FormatInt(id, 10): "",
+		"gke":                     "Cannot import the tag gke: tag not found",
+	}
+	for importID, expected := range cases {
+		d := resourceExtrasTag().TestResourceData()
+		d.SetId(importID)
+		imported, err := resourceExtrasTagImportState(context.Background(), d, config)
+		if expected != "" {
+			if err == nil || !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: expected an error containing %q, got %v", importID, expected, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", importID, err)
+		}
+		if imported[0].Id() != strconv.FormatInt(id, 10) {
+			t.Errorf("%s: expected the ID %d, got %s", importID, id, imported[0].Id())
+		}
+	}
+}
+
+// The slug derived from the name of a renamed tag is unknown until it's applied, the one in the config is kept
+func TestResourceExtrasTagPlanRename(t *testing.T) {
+	m := &mockNetbox{}
+	config := m.config()
+	provider := Provider()
+	provider.SetMeta(config)
+	server := schema.NewGRPCProviderServer(provider)
+	ty := resourceExtrasTag().CoreConfigSchema().ImpliedType()
+
+	prior := cty.ObjectVal(map[string]cty.Value{
+		"id":           cty.StringVal("7"),
+		"name":         cty.StringVal("Uplinks"),
+		"slug":         cty.StringVal("uplinks"),
+		"color":        cty.StringVal(defaultTagColor),
+		"description":  cty.StringVal(""),
+		"tagged_items": cty.NumberIntVal(2),
+	})
+	cases := map[string]struct {
+		slug     cty.Value
+		expected string
+	}{
+		"derived":    {slug: cty.NullVal(cty.String)},
+		"configured": {slug: cty.StringVal("uplinks"), expected: "uplinks"},
+	}
+	for tn, tc := range cases {
+		cfg := cty.ObjectVal(map[string]cty.Value{
+			"id":           cty.NullVal(cty.String),
+			"name":         cty.StringVal("Uplinks GCP"),
+			"slug":         tc.slug,
+			"color":        cty.NullVal(cty.String),
+			"description":  cty.NullVal(cty.String),
+			"tagged_items": cty.NullVal(cty.Number),
+		})
+		// The computed attributes missing from the config are proposed from the state, like terraform does
+		proposed := cty.ObjectVal(map[string]cty.Value{
+			"id":           prior.GetAttr("id"),
+			"name":         cfg.GetAttr("name"),
+			"slug":         prior.GetAttr("slug"),
+			"color":        cfg.GetAttr("color"),
+			"description":  cfg.GetAttr("description"),
+			"tagged_items": prior.GetAttr("tagged_items"),
+		})
+		if !tc.slug.IsNull() {
+			attrs := proposed.AsValueMap()
+			attrs["slug"] = tc.slug
+			proposed = cty.ObjectVal(attrs)
+		}
+
+		res, err := server.PlanResourceChange(context.Background(), &tfprotov5.PlanResourceChangeRequest{
+			TypeName:         "netbox_tag",
+			PriorState:       testDynamicValue(t, prior, ty),
+			ProposedNewState: testDynamicValue(t, proposed, ty),
+			Config:           testDynamicValue(t, cfg, ty),
+		})
+		if err != nil {
+			t.Fatalf("%s: error: %v", tn, err)
+		}
+		for _, d := range res.Diagnostics {
+			t.Fatalf("%s: unexpected diagnostic %s: %s", tn, d.Summary, d.Detail)
+		}
+		planned, err := msgpack.Unmarshal(res.PlannedState.MsgPack, ty)
+		if err != nil {
+			t.Fatalf("%s: error: %v", tn, err)
+		}
+		slug := planned.GetAttr("slug")
+		if tc.expected == "" && slug.IsKnown() {
+			t.Errorf("%s: expected the slug unknown, got %#v", tn, slug)
+		}
+		if tc.expected != "" && (!slug.IsKnown() || slug.AsString() != tc.expected) {
+			t.Errorf("%s: expected the slug %s, got %#v", tn, tc.expected, slug)
+		}
+	}
+}
+
+func testDynamicValue(t *testing.T, v cty.Value, ty cty.Type) *tfprotov5.DynamicValue {
+	b, err := msgpack.Marshal(v, ty)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	return &tfprotov5.DynamicValue{MsgPack: b}
+}
+
+// testUnknownValue is how the SDK represents an unknown value in a raw config, like the slug of a tag to create
+const testUnknownValue = "74D93920-ED26-11E3-AC10-0800200C9A66"
+
+func TestValidateTags(t *testing.T) {
+	cases := map[string]struct {
+		tags       []interface{}
+		apiVersion string
+		autoCreate bool
+		err        string
+	}{
+		"by name": {
+			tags: []interface{}{"Uplinks"},
+		},
+		"by slug": {
+			tags: []interface{}{"uplinks"},
+		},
+		"missing": {
+			tags: []interface{}{"uplinks", "gke", "gcp"},
+			err:  "don't exist in NetBox, create them with netbox_tag resources the prefix refers to, e.g. netbox_tag.<name>.slug, or set auto_create_tags",
+		},
+		"unknown": {
+			tags: []interface{}{"uplinks", testUnknownValue},
+		},
+		"auto created": {
+			tags:       []interface{}{"gke"},
+			autoCreate: true,
+		},
+		"netbox 2.8": {
+			tags:       []interface{}{"gke", "gcp"},
+			apiVersion: "2.8",
+		},
+	}
+
+	r := resourceIpamAvailablePrefixes()
+	for tn, tc := range cases {
+		f := newFakeNetbox()
+		f.apiVersion = "3.4"
+		if tc.apiVersion != "" {
+			f.apiVersion = tc.apiVersion
+		}
+		f.lock.Lock()
+		f.ensureTag("Uplinks")
+		f.lock.Unlock()
+		config, err := f.config()
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		config.AutoCreateTags = tc.autoCreate
+
+		cfg := terraform.NewResourceConfigRaw(map[string]interface{}{
+			"parent_prefix_id": int(f.parentPrefixID),
+			"prefix_length":    28,
+			"tags":             tc.tags,
+			"custom_fields":    []interface{}{map[string]interface{}{}},
+		})
+		_, err = r.Diff(context.Background(), nil, cfg, config)
+		f.Close()
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) || !strings.Contains(err.Error(), "gcp, gke") {
+				t.Errorf("%s: expected an error containing %q, got %v", tn, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tn, err)
+		}
+	}
+}
+
+func TestPreferConfiguredTags(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	f.lock.Lock()
+	f.ensureTag("Uplinks")
+	f.ensureTag("gke")
+	f.lock.Unlock()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	cases := map[string]struct {
+		configured []string
+		expected   []string
+	}{
+		"by name":      {configured: []string{"Uplinks", "gke"}, expected: []string{"Uplinks", "gke"}},
+		"by slug":      {configured: []string{"uplinks", "gke"}, expected: []string{"uplinks", "gke"}},
+		"not assigned": {configured: []string{"gke"}, expected: []string{"Uplinks", "gke"}},
+		"unknown":      {configured: []string{"gcp"}, expected: []string{"Uplinks", "gke"}},
+	}
+	for tn, tc := range cases {
+		tags := preferConfiguredTags(config, []string{"Uplinks", "gke"}, tc.configured)
+		if !reflect.DeepEqual(tags, tc.expected) {
+			t.Errorf("%s: expected the tags %v, got %v", tn, tc.expected, tags)
+		}
+	}
+}
@@ -2,13 +2,13 @@ package netbox
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
+	"net"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
@@ -39,10 +39,8 @@ func resourceIpamAvailablePrefixes() *schema.Resource {
 			StateContext: resourceIpamAvailablePrefixesImportState,
 			//StateContext: schema.ImportStatePassthroughContext,
 		},
-		SchemaVersion: 1,
-
-		// TODO when schema change happens
-		// StateUpgraders:
+		SchemaVersion:  2,
+		StateUpgraders: resourceIpamAvailablePrefixesStateUpgraders(),
 
 		Timeouts: &schema.ResourceTimeout{
 			Create:  schema.DefaultTimeout(10 * time.Minute),
@@ -55,6 +53,7 @@ func resourceIpamAvailablePrefixes() *schema.Resource {
 			"parent_prefix": {
 				Type:             schema.TypeString,
 				Optional:         true,
+				Computed:         true,
 				ForceNew:         true,
 				AtLeastOneOf:     availablePrefixesKeys,
 				ValidateDiagFunc: IsCIDRNetworkDiagFunc(1, 128),
@@ -70,11 +69,19 @@ func resourceIpamAvailablePrefixes() *schema.Resource {
 			"parent_prefix_id": {
 				Type:             schema.TypeInt,
 				Optional:         true,
+				Computed:         true,
 				ForceNew:         true,
 				AtLeastOneOf:     availablePrefixesKeys,
 				ValidateDiagFunc: IntAtLeastDiagFunc(0),
 				Description:      "A unique integer value identifying this prefix under which is used crave available prefix",
 			},
+			"fallback_parent_prefix_ids": {
+				Type:             schema.TypeList,
+				Optional:         true,
+				DiffSuppressFunc: createOnlySuppress,
+				Elem:             &schema.Schema{Type: schema.TypeInt, ValidateDiagFunc: IntAtLeastDiagFunc(0)},
+				Description:      "IDs of the prefixes to carve the prefix under, in order, when the parent prefix is full",
+			},
 			"prefix_length": {
 				Type:             schema.TypeInt,
 				Optional:         true,
@@ -82,6 +89,30 @@ func resourceIpamAvailablePrefixes() *schema.Resource {
 				ValidateDiagFunc: IntBetweenDiagFunc(1, 128),
 				Description:      "The mask in integer form",
 			},
+			"subnet_index": {
+				Type:             schema.TypeInt,
+				Optional:         true,
+				DiffSuppressFunc: createOnlySuppress,
+				RequiredWith:     []string{"prefix_length"},
+				ValidateDiagFunc: IntAtLeastDiagFunc(0),
+				Description:      "Carve the n-th subnet of prefix_length under the parent prefix, the same as cidrsubnet() does, instead of the first available one",
+			},
+			"allocation_strategy": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DiffSuppressFunc: createOnlySuppress,
+				ConflictsWith:    []string{"subnet_index"},
+				ValidateDiagFunc: StringInSliceDiagFunc(allocationStrategies, false),
+				Description:      "Where to place the prefix among the free blocks of the parent prefix, one of first_fit (the default), last_fit, best_fit or aligned",
+			},
+			"alignment": {
+				Type:             schema.TypeInt,
+				Optional:         true,
+				DiffSuppressFunc: createOnlySuppress,
+				RequiredWith:     []string{"allocation_strategy"},
+				ValidateDiagFunc: IntBetweenDiagFunc(1, 128),
+				Description:      "With the aligned allocation strategy, place the prefix at the start of a free block of this length, so it can grow up to it later",
+			},
 			"role": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -94,10 +125,10 @@ func resourceIpamAvailablePrefixes() *schema.Resource {
 			},
 			"tags": {
 				Type:        schema.TypeSet,
-				Elem:        &schema.Schema{Type: schema.TypeString},
+				Elem:        &schema.Schema{Type: schema.TypeString, ValidateDiagFunc: StringLenBetween(1, 100)},
 				Set:         schema.HashString,
 				Optional:    true,
-				Description: `The list of tags attached to the available prefix.`,
+				Description: `The tags attached to the available prefix, by name or slug.`,
 			},
 			"tenant": {
 				Type:        schema.TypeString,
@@ -193,12 +224,20 @@ func resourceIpamAvailablePrefixes() *schema.Resource {
 				},
 				suppressEmptyCustomFieldsDiff,
 			),
+			customdiff.If(
+				func(ctx context.Context, d *schema.ResourceDiff, meta interface{}) bool {
+					return d.HasChange("tags")
+				},
+				validateTags,
+			),
+			validateAlignment,
 		),
 	}
 }
 
 func resourceIpamAvailablePrefixesCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	config := m.(*Config)
+	ctx = newLogSubsystem(ctx, config, logSubsystemAvailablePrefixes)
 
 	wPrefix := models.WritablePrefix{}
 
@@ -275,9 +314,14 @@ func resourceIpamAvailablePrefixesCreate(ctx context.Context, d *schema.Resource
 
 	var tags []string
 	if tagsData, ok := d.GetOk("tags"); ok {
-		tags = convertStringSet(tagsData.(*schema.Set))
-		wPrefix.Tags = tags
+		var err error
+		if tags, err = resolveTags(config, convertStringSet(tagsData.(*schema.Set))); err != nil {
+			return netboxDiag(err)
+		}
 	}
+	// Stamp the prefix with the workspace owning it
+	tags = append(tags, ownershipTags(config.Workspace)...)
+	wPrefix.Tags = tags
 
 	var customFields interface{}
 	if cfData, ok := d.GetOk("custom_fields"); ok {
@@ -285,102 +329,185 @@ func resourceIpamAvailablePrefixesCreate(ctx context.Context, d *schema.Resource
 			customFields = cfMap
 			wPrefix.CustomFields = customFields
 		} else {
-			log.Println(err)
+			tflog.SubsystemWarn(ctx, logSubsystemAvailablePrefixes, "Ignoring the custom fields", map[string]interface{}{
+				"error": err.Error(),
+			})
 		}
 	}
 
 	// If parent prefix is given
-	wPrefixRes, _ := json.Marshal(wPrefix)
-	log.Println("[INFO] ", string(wPrefixRes))
-
 	if _, ok := getParentPrefix(config, d); ok == nil {
 		if wPrefix.ID == 0 {
 			results, err := getIpamPrefixes(config, d)
 			if err != nil {
-				return diag.FromErr(err)
+				return netboxDiag(err)
 			}
 			wPrefix.ID = results[0].ID
 			prefix_id = results[0].ID
 		}
 	}
+	// Try the fallback parent prefixes in order when the parent prefix is full
+	parentIDs := uniqueParentIDs(append([]int64{prefix_id}, expandFallbackParentIDs(d)...))
+	for i, parentID := range parentIDs {
+		err := allocateIpamAvailablePrefix(ctx, config, d, parentID, wPrefix)
+		if err == nil {
+			break
+		}
+		// The resource didn't actually create
+		d.SetId("")
+		if !isInsufficientSpace(err) {
+			return netboxDiag(err)
+		}
+		if i == len(parentIDs)-1 {
+			return insufficientSpaceDiag(config, parentIDs, err)
+		}
+		tflog.SubsystemInfo(ctx, logSubsystemAvailablePrefixes, "Parent prefix is full, trying the next one", map[string]interface{}{
+			"parent_prefix_id": parentID,
+		})
+	}
+
+	return resourceIpamAvailablePrefixesRead(ctx, d, m)
+}
+
+// allocateIpamAvailablePrefix reserves a prefix under the parent prefix, or adopts one left reserved by an earlier
+// attempt, commits it with the requested arguments and records it in the state, all under the lock of the parent prefix.
+// A prefix only belongs to a resource once committed, which drops the marker, so whoever finds a reserved prefix
+// under the lock is free to adopt it. A prefix which fails to commit stays reserved for the next attempt to adopt.
+func allocateIpamAvailablePrefix(ctx context.Context, config *Config, d *schema.ResourceData, prefix_id int64, wPrefix models.WritablePrefix) error {
+	requested := wPrefix
 	param := ipam.IpamPrefixesAvailablePrefixesCreateParams{
-		ID:   int64(prefix_id),
+		ID:   prefix_id,
 		Data: &wPrefix,
 	}
 	param.WithContext(context.Background())
 
-	paramRes, _ := json.Marshal(param)
-	log.Printf("[INFO] Requesting AvaliablePrefix creation %s", string(paramRes))
 	// Lock/Unlock have been deprecated, Rewrite them after migrated to sdk v2
-	mutexKV.Lock(fmt.Sprintf("%s_%d", lockNamePrefix, prefix_id))
-	defer mutexKV.Unlock(fmt.Sprintf("%s_%d", lockNamePrefix, prefix_id))
-	res, err := config.client.Ipam.IpamPrefixesAvailablePrefixesCreate(&param, nil)
+	mutexKV.Lock(ctx, fmt.Sprintf("%s_%d", lockNamePrefix, prefix_id))
+	defer mutexKV.Unlock(ctx, fmt.Sprintf("%s_%d", lockNamePrefix, prefix_id))
+
+	// Serialize allocations under the parent with other terraform runs too
+	unlock, err := config.locker.Lock(ctx, fmt.Sprintf("%s_%d", lockNamePrefix, prefix_id))
 	if err != nil {
-		// The resource didn't actually create
-		log.Println("[Error] Failed to create AvaliablePrefix: ", err)
-		d.SetId("")
-		// work around  netbox insufficient space response problem which returns 204 http code with a message body
-		// although according to https://tools.ietf.org/html/rfc2616#section-10.2.5, The 204 response MUST NOT include a message-body,
-		// and thus is always terminated by the first empty line after the header fields.
-		if strings.Contains(err.Error(), "204") {
-			log.Printf("[WARN] Insufficient space is available to accommodate the requested prefix size(s) \"/%d\"", prefixlength)
-			return diag.Errorf("Insufficient space is available to accommodate the requested prefix size(s) \"/%d\"", prefixlength)
-		}
-		return diag.FromErr(err)
+		return err
 	}
+	defer unlock()
 
-	availablePrefix := res.GetPayload()
+	marker, err := reservationMarker(d, prefix_id, &wPrefix)
+	if err != nil {
+		return err
+	}
+
+	availablePrefix, err := findReservedPrefix(ctx, config, marker)
+	if err != nil {
+		return err
+	}
+	if availablePrefix != nil {
+		tflog.SubsystemInfo(ctx, logSubsystemAvailablePrefixes, "Adopting the prefix reserved by an earlier run", map[string]interface{}{
+			"prefix": *availablePrefix.Prefix,
+			"marker": marker,
+		})
+	} else {
+		reservePrefix(&wPrefix, marker)
+		if err := ensureManagedTags(config, wPrefix.Tags); err != nil {
+			return err
+		}
+		availablePrefix, err = createIpamAvailablePrefix(ctx, config, d, prefix_id, &param)
+		if err != nil {
+			return err
+		}
+	}
+	// Commit before the lock is released, so no concurrent run adopts the reservation
+	if err := commitReservedPrefix(ctx, config, availablePrefix, requested, marker); err != nil {
+		return err
+	}
 	d.SetId(fmt.Sprintf("%d", availablePrefix.ID))
+	return nil
+}
 
-	return resourceIpamAvailablePrefixesRead(ctx, d, m)
+// createIpamAvailablePrefix creates the prefix requested by param under the parent prefix, at the
+// subnet_index or where the allocation_strategy places it. Callers must hold the lock of the parent prefix.
+func createIpamAvailablePrefix(ctx context.Context, config *Config, d *schema.ResourceData, prefix_id int64, param *ipam.IpamPrefixesAvailablePrefixesCreateParams) (*models.Prefix, error) {
+	prefixlength := param.Data.PrefixLength
+
+	if index, ok := getSubnetIndex(d); ok {
+		tflog.SubsystemInfo(ctx, logSubsystemAvailablePrefixes, "Requesting a subnet by index", map[string]interface{}{
+			"parent_prefix_id": prefix_id,
+			"prefix_length":    prefixlength,
+			"subnet_index":     index,
+		})
+		return createIpamPrefixAtIndex(config, prefix_id, index, param.Data)
+	}
+
+	if strategy := d.Get("allocation_strategy").(string); strategy != "" && strategy != allocationStrategyFirstFit {
+		alignment := d.Get("alignment").(int)
+		tflog.SubsystemInfo(ctx, logSubsystemAvailablePrefixes, "Requesting a prefix", map[string]interface{}{
+			"parent_prefix_id":    prefix_id,
+			"prefix_length":       prefixlength,
+			"allocation_strategy": strategy,
+		})
+		return createIpamPrefixWithStrategy(config, prefix_id, strategy, alignment, param.Data)
+	}
+
+	tflog.SubsystemInfo(ctx, logSubsystemAvailablePrefixes, "Requesting a prefix", map[string]interface{}{
+		"parent_prefix_id":    prefix_id,
+		"prefix_length":       prefixlength,
+		"allocation_strategy": allocationStrategyFirstFit,
+	})
+	res, err := config.ipam.IpamPrefixesAvailablePrefixesCreate(param, nil, nil)
+	if err != nil {
+		if isInsufficientSpace(err) {
+			return nil, &insufficientSpaceError{Length: prefixlength}
+		}
+		return nil, err
+	}
+
+	return res.GetPayload(), nil
 }
 
 func resourceIpamAvailablePrefixesRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	config := m.(*Config)
+	ctx = newLogSubsystem(ctx, config, logSubsystemAvailablePrefixes)
 
 	prefix, err := getIpamPrefix(config, d)
+	if isNotFound(err) {
+		// Deleted out of band, terraform plans to create it again
+		tflog.SubsystemWarn(ctx, logSubsystemAvailablePrefixes, "Prefix not found, removing it from the state", map[string]interface{}{
+			"id":     d.Id(),
+			"prefix": d.Get("prefix").(string),
+		})
+		d.SetId("")
+		return nil
+	}
 	if err != nil || prefix == nil {
-		return diag.FromErr(err)
+		return netboxDiag(err)
 	}
 
-	log.Println("[INFO] resourceIpamPrefixesRead ", prefix)
-	//d.Set("id", prefix.ID)
+	tflog.SubsystemDebug(ctx, logSubsystemAvailablePrefixes, "Read prefix", map[string]interface{}{
+		"id": prefix.ID,
+	})
+	// Every attribute is set, cleared ones included, so whatever was changed in netbox shows in the plan
 	d.Set("description", prefix.Description)
-
-	log.Println("CustomFields: ", flatterCustomFields(d, prefix.CustomFields))
-
-	if prefix != nil && prefix.CustomFields != nil {
-		d.Set("custom_fields", flatterCustomFields(d, prefix.CustomFields))
-	}
-
-	log.Println("d state", d.State())
-	log.Println("custom_fields.# ", d.Get("custom_fields.#"))
-
-	d.Set("is_pool", prefix.IsPool)
+	d.Set("custom_fields", flatterCustomFields(d, prefix.CustomFields))
+	d.Set("is_pool", prefix.IsPool != nil && *prefix.IsPool)
 	d.Set("created", prefix.Created.String())
 	d.Set("family", prefix.Family.Value)
 	d.Set("last_updated", prefix.LastUpdated.String())
 
-	if prefix != nil && prefix.Role != nil {
-		d.Set("role", prefix.Role.Name)
-	}
-
 	if prefix.Prefix != nil && *prefix.Prefix != "" {
 		parentPrefix, err := getIpamParentPrefixes(config, d, prefix)
 		if err != nil {
-			return diag.FromErr(err)
+			return netboxDiag(err)
 		}
-		if parentPrefix == nil {
-			return diag.Errorf("prefix %s's doens't seem to have a parent prefix", *prefix.Prefix)
+		// A prefix moved out of the VRF of its parent in netbox keeps the parent, the vrf shows in the plan
+		if parentPrefix == nil && !holdsPrefix(d.Get("parent_prefix").(string), *prefix.Prefix) {
+			return diag.Errorf("prefix %s with ID %s has no parent prefix", *prefix.Prefix, d.Id())
 		}
-		if parentPrefix != nil && *parentPrefix.Prefix != "" {
-			if _, ok := d.GetOk("parent_prefix_id"); ok {
-				d.Set("parent_prefix_id", int(parentPrefix.ID))
-			}
-
-			if _, ok := d.GetOk("parent_prefix"); ok {
-				d.Set("parent_prefix", parentPrefix.Prefix)
-			}
+		// netbox nests a prefix under the nearest prefix, which changes whenever a prefix is added or removed
+		// in between. The parent in the state is kept as long as it holds the prefix, since a new parent would
+		// force a new prefix. A prefix carved under a fallback parent keeps the parent it was requested under.
+		if parentPrefix != nil && *parentPrefix.Prefix != "" && !isFallbackParentID(d, parentPrefix.ID) && !holdsPrefix(d.Get("parent_prefix").(string), *prefix.Prefix) {
+			d.Set("parent_prefix_id", int(parentPrefix.ID))
+			d.Set("parent_prefix", parentPrefix.Prefix)
 		}
 	}
 
@@ -391,24 +518,32 @@ func resourceIpamAvailablePrefixesRead(ctx context.Context, d *schema.ResourceDa
 		d.Set("prefix_length", prefixLength)
 	}
 
-	if prefix != nil && prefix.Site != nil {
-		d.Set("site", prefix.Site.Name)
-	} else {
-		d.Set("site", "")
+	var role, site, tenant, vlan, vrf, status string
+	if prefix.Role != nil && prefix.Role.Name != nil {
+		role = *prefix.Role.Name
+	}
+	if prefix.Site != nil && prefix.Site.Name != nil {
+		site = *prefix.Site.Name
 	}
-	if prefix != nil && prefix.Status != nil {
-		d.Set("status", *prefix.Status.Value)
+	if prefix.Tenant != nil && prefix.Tenant.Name != nil {
+		tenant = *prefix.Tenant.Name
 	}
-	d.Set("tags", prefix.Tags)
-	if prefix != nil && prefix.Tenant != nil {
-		d.Set("tenant", prefix.Tenant.Name)
+	if prefix.Vlan != nil && prefix.Vlan.Name != nil {
+		vlan = *prefix.Vlan.Name
 	}
-	if prefix != nil && prefix.Vlan != nil {
-		d.Set("vlan", prefix.Vlan.Name)
+	if prefix.Vrf != nil && prefix.Vrf.Name != nil {
+		vrf = *prefix.Vrf.Name
 	}
-	if prefix != nil && prefix.Vrf != nil {
-		d.Set("vrf", prefix.Vrf.Name)
+	if prefix.Status != nil && prefix.Status.Value != nil {
+		status = *prefix.Status.Value
 	}
+	d.Set("role", role)
+	d.Set("site", site)
+	d.Set("tenant", tenant)
+	d.Set("vlan", vlan)
+	d.Set("vrf", vrf)
+	d.Set("status", status)
+	d.Set("tags", preferConfiguredTags(config, flattenTags(prefix.Tags), convertStringSet(d.Get("tags").(*schema.Set))))
 
 	d.SetId(fmt.Sprintf("%d", prefix.ID))
 	return nil
@@ -416,6 +551,7 @@ func resourceIpamAvailablePrefixesRead(ctx context.Context, d *schema.ResourceDa
 
 func resourceIpamAvailablePrefixesUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	config := m.(*Config)
+	ctx = newLogSubsystem(ctx, config, logSubsystemAvailablePrefixes)
 
 	var writablePrefix models.WritablePrefix
 
@@ -447,69 +583,84 @@ func resourceIpamAvailablePrefixesUpdate(ctx context.Context, d *schema.Resource
 		writablePrefix.IsPool = &v
 	}
 
+	// The fields emptied in the config are cleared in netbox
+	cleared := make(map[string]interface{})
+
 	if d.HasChange("description") && !d.IsNewResource() {
 		descriptionData := d.Get("description").(string)
 		writablePrefix.Description = descriptionData
+		if descriptionData == "" {
+			cleared["description"] = ""
+		}
 	}
 	if d.HasChange("tags") && !d.IsNewResource() {
-		writablePrefix.Tags = convertStringSet(d.Get("tags").(*schema.Set))
+		tags, err := resolveTags(config, convertStringSet(d.Get("tags").(*schema.Set)))
+		if err != nil {
+			return netboxDiag(err)
+		}
+		writablePrefix.Tags = append(tags, ownershipTags(config.Workspace)...)
+		if len(writablePrefix.Tags) == 0 {
+			cleared["tags"] = []string{}
+		}
+		if err := ensureManagedTags(config, writablePrefix.Tags); err != nil {
+			return netboxDiag(err)
+		}
 	}
 	if d.HasChange("custom_fields") && !d.IsNewResource() {
 		cfData := d.Get("custom_fields").([]interface{})
-		log.Println("cfData	", cfData)
 		if cfMap, err := expandCustomFields(d, cfData); err == nil {
 			writablePrefix.CustomFields = cfMap
 		} else {
-			log.Println(err)
+			tflog.SubsystemWarn(ctx, logSubsystemAvailablePrefixes, "Ignoring the custom fields", map[string]interface{}{
+				"error": err.Error(),
+			})
 		}
 	}
 
-	if d.HasChange("site") && !d.IsNewResource() {
-		if siteId, err := getModelId(config, d, "site"); err == nil {
-			writablePrefix.Site = &siteId
+	for _, key := range []string{"site", "vrf", "tenant", "vlan", "role"} {
+		if !d.HasChange(key) || d.IsNewResource() {
+			continue
 		}
-	}
-	if d.HasChange("vrf") && !d.IsNewResource() {
-		if vrfId, err := getModelId(config, d, "vrf"); err == nil {
-			writablePrefix.Vrf = &vrfId
-		}
-	}
-	if d.HasChange("tenant") && !d.IsNewResource() {
-		if tenantId, err := getModelId(config, d, "tenant"); err == nil {
-			writablePrefix.Tenant = &tenantId
+		if d.Get(key).(string) == "" {
+			cleared[key] = nil
+			continue
 		}
-	}
-	if d.HasChange("vlan") && !d.IsNewResource() {
-		if vlanId, err := getModelId(config, d, "vlan"); err == nil {
-			writablePrefix.Vlan = &vlanId
+		modelId, err := getModelId(config, d, key)
+		if err != nil {
+			continue
 		}
-	}
-	if d.HasChange("role") && !d.IsNewResource() {
-		if roleId, err := getModelId(config, d, "role"); err == nil {
-			writablePrefix.Role = &roleId
+		switch key {
+		case "site":
+			writablePrefix.Site = &modelId
+		case "vrf":
+			writablePrefix.Vrf = &modelId
+		case "tenant":
+			writablePrefix.Tenant = &modelId
+		case "vlan":
+			writablePrefix.Vlan = &modelId
+		case "role":
+			writablePrefix.Role = &modelId
 		}
 	}
 
 	id, err := strconv.Atoi(d.Id())
 	if err != nil {
-		return diag.FromErr(err)
+		return netboxDiag(err)
 	}
 	partialUpdatePrefix := ipam.IpamPrefixesPartialUpdateParams{
 		ID:      int64(id),
 		Data:    &writablePrefix,
-		Context: context.Background(),
+		Context: withClearedFields(context.Background(), cleared),
 	}
+	tflog.SubsystemInfo(ctx, logSubsystemAvailablePrefixes, "Updating prefix", map[string]interface{}{
+		"id": id,
+	})
 
-	partialUpdatePrefixRes, _ := json.Marshal(partialUpdatePrefix)
-	log.Println("resourceIpamAvailablePrefixesUpdate partialUpdatePrefix: ", string(partialUpdatePrefixRes))
-
-	mutexKV.Lock(fmt.Sprintf("%s_%d", lockNamePrefix, id))
-	defer mutexKV.Unlock(fmt.Sprintf("%s_%d", lockNamePrefix, id))
+	mutexKV.Lock(ctx, fmt.Sprintf("%s_%d", lockNamePrefix, id))
+	defer mutexKV.Unlock(ctx, fmt.Sprintf("%s_%d", lockNamePrefix, id))
 
-	res, uerr := config.client.Ipam.IpamPrefixesPartialUpdate(&partialUpdatePrefix, nil)
-	if uerr != nil {
-		// TODO Support verbose response body here
-		return diag.Errorf("%v %v", res, uerr)
+	if _, err := config.ipam.IpamPrefixesPartialUpdate(&partialUpdatePrefix, nil); err != nil {
+		return netboxDiag(err)
 	}
 
 	return resourceIpamAvailablePrefixesRead(ctx, d, m)
@@ -517,22 +668,30 @@ func resourceIpamAvailablePrefixesUpdate(ctx context.Context, d *schema.Resource
 
 func resourceIpamAvailablePrefixesDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	config := m.(*Config)
+	ctx = newLogSubsystem(ctx, config, logSubsystemAvailablePrefixes)
 
-	log.Printf("[INFO]Requesting Prefix deletion: %s", d.Get("prefix").(string))
 	id, err := strconv.Atoi(d.Id())
 	if err != nil {
-		return diag.FromErr(err)
+		return netboxDiag(err)
 	}
+	tflog.SubsystemInfo(ctx, logSubsystemAvailablePrefixes, "Deleting prefix", map[string]interface{}{
+		"id":     id,
+		"prefix": d.Get("prefix").(string),
+	})
 	params := ipam.IpamPrefixesDeleteParams{
 		ID: int64(id),
 	}
 	params.WithContext(context.Background())
-	mutexKV.Lock(fmt.Sprintf("%s_%d", lockNamePrefix, id))
-	defer mutexKV.Unlock(fmt.Sprintf("%s_%d", lockNamePrefix, id))
+	mutexKV.Lock(ctx, fmt.Sprintf("%s_%d", lockNamePrefix, id))
+	defer mutexKV.Unlock(ctx, fmt.Sprintf("%s_%d", lockNamePrefix, id))
 
-	_, derr := config.client.Ipam.IpamPrefixesDelete(&params, nil)
-	if derr != nil {
-		return diag.FromErr(derr)
+	_, derr := config.ipam.IpamPrefixesDelete(&params, nil)
+	if isNotFound(derr) {
+		tflog.SubsystemWarn(ctx, logSubsystemAvailablePrefixes, "Prefix already deleted", map[string]interface{}{
+			"id": id,
+		})
+	} else if derr != nil {
+		return netboxDiag(derr)
 	}
 
 	d.SetId("")
@@ -545,13 +704,21 @@ func getIpamPrefix(config *Config, d *schema.ResourceData) (*models.Prefix, erro
 	if err != nil {
 		return nil, err
 	}
+	// The reads of a refresh are batched
+	return config.prefixReads.prefix(config, int64(id))
+}
+
+func getIpamPrefixByID(config *Config, id int64) (*models.Prefix, error) {
 	params := ipam.IpamPrefixesReadParams{
-		ID: int64(id),
+		ID: id,
 	}
 
 	params.WithContext(context.Background())
-	ipamPrefixesReadOK, err := config.client.Ipam.IpamPrefixesRead(&params, nil)
-	if err != nil || ipamPrefixesReadOK == nil {
+	ipamPrefixesReadOK, err := config.ipam.IpamPrefixesRead(&params, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot determine prefix with ID %d: %w", id, err)
+	}
+	if ipamPrefixesReadOK == nil {
 		return nil, fmt.Errorf("Cannot determine prefix with ID %d", id)
 	}
 
@@ -579,7 +746,7 @@ func getIpamPrefixes(config *Config, d *schema.ResourceData) ([]*models.Prefix,
 		Prefix:        &prefix,
 	}
 	param.WithContext(context.Background())
-	ipamPrefixListBody, err := config.client.Ipam.IpamPrefixesList(&param, nil)
+	ipamPrefixListBody, err := config.ipam.IpamPrefixesList(&param, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -587,51 +754,52 @@ func getIpamPrefixes(config *Config, d *schema.ResourceData) ([]*models.Prefix,
 	if ipamPrefixListBody == nil || ipamPrefixListBody.Payload == nil || *ipamPrefixListBody.Payload.Count < 1 {
 		return nil, fmt.Errorf("Unknow prefix %s with ID %s, not found", prefix, d.Id())
 	}
-	// trace level log
-	ipamPrefixesReadOKRes, _ := json.Marshal(&ipamPrefixListBody.Payload.Results)
-	log.Println("[getIpamPrefixes] ipamPrefixListBody", string(ipamPrefixesReadOKRes))
-
 	return ipamPrefixListBody.Payload.Results, nil
 }
 
 func getIpamParentPrefixes(config *Config, d *schema.ResourceData, prefix *models.Prefix) (*models.Prefix, error) {
+	return config.prefixReads.parent(config, prefix)
+}
+
+// getIpamParentPrefix returns the parent of the prefix, or nil for a top level prefix
+func getIpamParentPrefix(config *Config, prefix *models.Prefix) (*models.Prefix, error) {
 	// Compose Parameters for GET: /ipam/prefixes/
-	// The api call to get parent prefix is like: /api/ipam/prefixes/?contains=10.1.0.0/16&vrf_id=null
+	// The api call to get parent prefix is like: /api/ipam/prefixes/?contains=10.1.0.0/16
 	// The WebUI parent prefix fetch pretty much uses the same DB query logic: https://github.com/netbox-community/netbox/blob/develop/netbox/ipam/views.py#L342-L349
+	// The prefixes of every VRF are listed, vrf_id can't ask for a VRF and the global table at once.
 	param := ipam.IpamPrefixesListParams{
 		Context:  context.Background(),
 		Contains: prefix.Prefix,
+		Limit:    &NetboxApiGeneralQueryLimit,
 	}
 
-	var vrfID string
-	if prefix.Vrf != nil {
-		vrfID = strconv.FormatInt(prefix.Vrf.ID, 10)
-		param.VrfID = &vrfID
-	} else {
-		param.VrfID = &vrfID
-	}
-
-	ipamPrefixListBody, err := config.client.Ipam.IpamPrefixesList(&param, nil)
+	ipamPrefixListBody, err := config.ipam.IpamPrefixesList(&param, nil, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	if ipamPrefixListBody == nil || ipamPrefixListBody.Payload == nil || *ipamPrefixListBody.Payload.Count < 1 {
-		return nil, fmt.Errorf("Unknow prefix %s with ID %s, not found", *prefix.Prefix, d.Id())
+		return nil, fmt.Errorf("Unknow prefix %s with ID %d, not found", *prefix.Prefix, prefix.ID)
 	} else if *ipamPrefixListBody.Payload.Count < 2 {
-		return nil, fmt.Errorf("prefix %s with ID %s has no parent prefix", *prefix.Prefix, d.Id())
+		// prefix is a top level prefix
+		return nil, nil
 	}
-	// trace level log
-	ipamPrefixesReadOKRes, _ := json.Marshal(&ipamPrefixListBody.Payload.Results)
-	log.Println("[getIpamParentPrefixes] ipamPrefixListBody", string(ipamPrefixesReadOKRes))
+	return selectParentPrefix(prefix, ipamPrefixListBody.Payload.Results), nil
+}
 
-	var parent *models.Prefix
-	for _, p := range ipamPrefixListBody.Payload.Results {
-		if !strings.EqualFold(*p.Prefix, *prefix.Prefix) {
-			parent = p
-		}
+// holdsPrefix tells whether the parent, in CIDR notation, contains the prefix
+func holdsPrefix(parent, prefix string) bool {
+	_, parentNet, err := net.ParseCIDR(parent)
+	if err != nil {
+		return false
+	}
+	_, prefixNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return false
 	}
-	return parent, nil
+	parentOnes, parentBits := parentNet.Mask.Size()
+	prefixOnes, prefixBits := prefixNet.Mask.Size()
+	return parentBits == prefixBits && parentOnes < prefixOnes && parentNet.Contains(prefixNet.IP)
 }
 
 func getParentPrefix(config *Config, d *schema.ResourceData) (string, error) {
@@ -640,7 +808,6 @@ func getParentPrefix(config *Config, d *schema.ResourceData) (string, error) {
 
 func getAttrFromSchema(resourceSchemaField string, d *schema.ResourceData, config *Config) (string, error) {
 	res, ok := d.GetOk(resourceSchemaField)
-	log.Println("[debug] ", res)
 	if ok && resourceSchemaField != "" {
 		return res.(string), nil
 	}
@@ -653,24 +820,26 @@ func getIpamRoles(config *Config, d *schema.ResourceData) ([]*models.Role, error
 	if err != nil {
 		return nil, err
 	}
-	roleParam := ipam.IpamRolesListParams{
-		Name:    &roleName,
-		Limit:   &NetboxApiGeneralQueryLimit,
-		Context: context.Background(),
-	}
-	roleRes, err := config.client.Ipam.IpamRolesList(&roleParam, nil)
-	if err != nil {
-		fmt.Println("IpamRolesList ", err)
-	}
+	roles, err := config.lookups.get(lookupRole, roleName, func() (interface{}, error) {
+		roleParam := ipam.IpamRolesListParams{
+			Name:    &roleName,
+			Limit:   &NetboxApiGeneralQueryLimit,
+			Context: context.Background(),
+		}
+		roleRes, err := config.ipam.IpamRolesList(&roleParam, nil)
+		if err != nil {
+			return nil, fmt.Errorf("IpamRolesList %w", err)
+		}
 
-	if roleRes == nil || roleRes.Payload == nil || *roleRes.Payload.Count < 1 {
-		return nil, fmt.Errorf("Unknow role %s , not found", roleName)
+		if roleRes == nil || roleRes.Payload == nil || *roleRes.Payload.Count < 1 {
+			return nil, fmt.Errorf("Unknow role %s , not found", roleName)
+		}
+		return roleRes.Payload.Results, nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	// trace level log
-	roleReadOKRes, _ := json.Marshal(&roleRes.Payload.Results)
-	log.Println("roleReadOKRes ", string(roleReadOKRes))
-
-	return roleRes.Payload.Results, nil
+	return roles.([]*models.Role), nil
 }
 
 func getDcimSites(config *Config, d *schema.ResourceData) ([]*models.Site, error) {
@@ -678,20 +847,26 @@ func getDcimSites(config *Config, d *schema.ResourceData) ([]*models.Site, error
 	if err != nil {
 		return nil, err
 	}
-	siteParam := dcim.DcimSitesListParams{
-		Name:    &siteName,
-		Limit:   &NetboxApiGeneralQueryLimit,
-		Context: context.Background(),
-	}
-	siteRes, err := config.client.Dcim.DcimSitesList(&siteParam, nil)
-	if err != nil {
-		return nil, fmt.Errorf("DcimSitesListParams %s", err.Error())
-	}
+	sites, err := config.lookups.get(lookupSite, siteName, func() (interface{}, error) {
+		siteParam := dcim.DcimSitesListParams{
+			Name:    &siteName,
+			Limit:   &NetboxApiGeneralQueryLimit,
+			Context: context.Background(),
+		}
+		siteRes, err := config.dcim.DcimSitesList(&siteParam, nil)
+		if err != nil {
+			return nil, fmt.Errorf("DcimSitesListParams %w", err)
+		}
 
-	if siteRes == nil || siteRes.Payload == nil || *siteRes.Payload.Count < 1 {
-		return nil, fmt.Errorf("Unknow Site %s , not found", siteName)
+		if siteRes == nil || siteRes.Payload == nil || *siteRes.Payload.Count < 1 {
+			return nil, fmt.Errorf("Unknow Site %s , not found", siteName)
+		}
+		return siteRes.Payload.Results, nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return siteRes.Payload.Results, nil
+	return sites.([]*models.Site), nil
 }
 
 func getIpamVlans(config *Config, d *schema.ResourceData) ([]*models.VLAN, error) {
@@ -699,19 +874,25 @@ func getIpamVlans(config *Config, d *schema.ResourceData) ([]*models.VLAN, error
 	if err != nil {
 		return nil, err
 	}
-	vlanParam := ipam.IpamVlansListParams{
-		Name:    &vlanName,
-		Limit:   &NetboxApiGeneralQueryLimit,
-		Context: context.Background(),
-	}
-	vlanData, err := config.client.Ipam.IpamVlansList(&vlanParam, nil)
+	vlans, err := config.lookups.get(lookupVlan, vlanName, func() (interface{}, error) {
+		vlanParam := ipam.IpamVlansListParams{
+			Name:    &vlanName,
+			Limit:   &NetboxApiGeneralQueryLimit,
+			Context: context.Background(),
+		}
+		vlanData, err := config.ipam.IpamVlansList(&vlanParam, nil)
+		if err != nil {
+			return nil, fmt.Errorf("IpamVlansList %w", err)
+		}
+		if vlanData == nil || vlanData.Payload == nil || *vlanData.Payload.Count < 1 {
+			return nil, fmt.Errorf("Unknow vlan %s , not found", vlanName)
+		}
+		return vlanData.Payload.Results, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("IpamVlansList %s", err.Error())
-	}
-	if vlanData == nil || vlanData.Payload == nil || *vlanData.Payload.Count < 1 {
-		return nil, fmt.Errorf("Unknow vlan %s , not found", vlanName)
+		return nil, err
 	}
-	return vlanData.Payload.Results, nil
+	return vlans.([]*models.VLAN), nil
 }
 
 func getIpamVrfs(config *Config, d *schema.ResourceData) ([]*models.VRF, error) {
@@ -720,19 +901,25 @@ func getIpamVrfs(config *Config, d *schema.ResourceData) ([]*models.VRF, error)
 		return nil, err
 	}
 
-	vrfParam := ipam.IpamVrfsListParams{
-		Name:    &vrfName,
-		Limit:   &NetboxApiGeneralQueryLimit,
-		Context: context.Background(),
-	}
-	vrfData, err := config.client.Ipam.IpamVrfsList(&vrfParam, nil)
+	vrfs, err := config.lookups.get(lookupVrf, vrfName, func() (interface{}, error) {
+		vrfParam := ipam.IpamVrfsListParams{
+			Name:    &vrfName,
+			Limit:   &NetboxApiGeneralQueryLimit,
+			Context: context.Background(),
+		}
+		vrfData, err := config.ipam.IpamVrfsList(&vrfParam, nil)
+		if err != nil {
+			return nil, fmt.Errorf("IpamVrfsList %w", err)
+		}
+		if vrfData == nil || vrfData.Payload == nil || *vrfData.Payload.Count < 1 {
+			return nil, fmt.Errorf("Unknow vrf %s , not found", vrfName)
+		}
+		return vrfData.Payload.Results, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("IpamVlansList %s", err.Error())
-	}
-	if vrfData == nil || vrfData.Payload == nil || *vrfData.Payload.Count < 1 {
-		return nil, fmt.Errorf("Unknow vrf %s , not found", vrfName)
+		return nil, err
 	}
-	return vrfData.Payload.Results, nil
+	return vrfs.([]*models.VRF), nil
 }
 
 func getTenancyTenant(config *Config, d *schema.ResourceData) ([]*models.Tenant, error) {
@@ -740,20 +927,26 @@ func getTenancyTenant(config *Config, d *schema.ResourceData) ([]*models.Tenant,
 	if err != nil {
 		return nil, err
 	}
-	tenantParam := tenancy.TenancyTenantsListParams{
-		Name:    &tenantName,
-		Limit:   &NetboxApiGeneralQueryLimit,
-		Context: context.Background(),
-	}
-	tenantData, err := config.client.Tenancy.TenancyTenantsList(&tenantParam, nil)
-	if err != nil {
-		return nil, fmt.Errorf("TenancyTenantsList %s", err.Error())
-	}
+	tenants, err := config.lookups.get(lookupTenant, tenantName, func() (interface{}, error) {
+		tenantParam := tenancy.TenancyTenantsListParams{
+			Name:    &tenantName,
+			Limit:   &NetboxApiGeneralQueryLimit,
+			Context: context.Background(),
+		}
+		tenantData, err := config.tenancy.TenancyTenantsList(&tenantParam, nil)
+		if err != nil {
+			return nil, fmt.Errorf("TenancyTenantsList %w", err)
+		}
 
-	if tenantData == nil || tenantData.Payload == nil || *tenantData.Payload.Count < 1 {
-		return nil, fmt.Errorf("Unknow Tenant %s , not found", tenantName)
+		if tenantData == nil || tenantData.Payload == nil || *tenantData.Payload.Count < 1 {
+			return nil, fmt.Errorf("Unknow Tenant %s , not found", tenantName)
+		}
+		return tenantData.Payload.Results, nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return tenantData.Payload.Results, nil
+	return tenants.([]*models.Tenant), nil
 }
 
 func getModelId(config *Config, d *schema.ResourceData, key string) (int64, error) {
@@ -793,12 +986,132 @@ func getModelId(config *Config, d *schema.ResourceData, key string) (int64, erro
 	}
 }
 
+// resourceIpamAvailablePrefixesImportState resolves the prefix of an import ID, which is one of
+//
+//	911                     the ID of the prefix
+//	10.20.0.0/24            a prefix of the global table
+//	activision/10.20.0.0/24 a prefix of the VRF activision
+//	911/10                  the ID of the prefix and of the parent prefix it was carved under
+//
+// and fills the arguments which force a new prefix and the subnet index, so the plan right after the import is clean.
+// Without a parent prefix ID, the parent is the one netbox nests the prefix under.
 func resourceIpamAvailablePrefixesImportState(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
-	// config := meta.(*Config)
-	log.Println("resourceIpamAvailablePrefixesImportState ", d.Get("custom_fields"))
+	config := meta.(*Config)
+
+	prefix, parentID, err := getImportedIpamPrefix(config, d, d.Id())
+	if err != nil {
+		return nil, err
+	}
+	if prefix.Prefix == nil {
+		return nil, fmt.Errorf("Cannot import prefix with ID %d, it has no prefix", prefix.ID)
+	}
+
+	var parent *models.Prefix
+	if parentID != 0 {
+		p, err := getIpamPrefixByID(config, parentID)
+		if err != nil {
+			return nil, err
+		}
+		// The parent must be one netbox could nest the prefix under
+		if parent = selectParentPrefix(prefix, []*models.Prefix{p}); parent == nil {
+			return nil, fmt.Errorf("Prefix %s with ID %d isn't within the parent prefix with ID %d", *prefix.Prefix, prefix.ID, parentID)
+		}
+	} else {
+		if parent, err = getIpamParentPrefix(config, prefix); err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			return nil, fmt.Errorf("Prefix %s with ID %d has no parent prefix", *prefix.Prefix, prefix.ID)
+		}
+	}
+
+	_, network, err := net.ParseCIDR(*prefix.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	prefixLength, _ := network.Mask.Size()
+
+	d.SetId(strconv.FormatInt(prefix.ID, 10))
+	d.Set("prefix", prefix.Prefix)
+	d.Set("prefix_length", prefixLength)
+	d.Set("parent_prefix_id", int(parent.ID))
+	d.Set("parent_prefix", parent.Prefix)
+	// The n-th subnet of its length under the parent, the prefix may have been allocated at any index
+	if _, parentNet, err := net.ParseCIDR(*parent.Prefix); err == nil {
+		if index, ok := subnetIndex(parentNet, network); ok {
+			d.Set("subnet_index", index)
+		}
+	}
 	if _, ok := d.GetOk("custom_fields"); !ok {
 		d.Set("custom_fields", nil)
 	}
 
 	return []*schema.ResourceData{d}, nil
 }
+
+// getImportedIpamPrefix looks up the prefix of an import ID, and returns the ID of the parent it names, if any
+func getImportedIpamPrefix(config *Config, d *schema.ResourceData, importID string) (*models.Prefix, int64, error) {
+	if id, err := strconv.ParseInt(importID, 10, 64); err == nil {
+		prefix, err := getIpamPrefixByID(config, id)
+		return prefix, 0, err
+	}
+
+	if parts := strings.Split(importID, "/"); len(parts) == 2 {
+		id, idErr := strconv.ParseInt(parts[0], 10, 64)
+		parentID, parentErr := strconv.ParseInt(parts[1], 10, 64)
+		if idErr == nil && parentErr == nil {
+			prefix, err := getIpamPrefixByID(config, id)
+			return prefix, parentID, err
+		}
+	}
+
+	if _, _, err := net.ParseCIDR(importID); err == nil {
+		prefix, err := getIpamPrefixByCIDR(config, importID, "")
+		return prefix, 0, err
+	}
+
+	// The VRF is everything before the prefix, its name may hold slashes too
+	if last := strings.LastIndex(importID, "/"); last > 0 {
+		if sep := strings.LastIndex(importID[:last], "/"); sep > 0 {
+			vrf, cidr := importID[:sep], importID[sep+1:]
+			if _, _, err := net.ParseCIDR(cidr); err == nil {
+				d.Set("vrf", vrf)
+				vrfID, err := getModelId(config, d, "vrf")
+				if err != nil {
+					return nil, 0, err
+				}
+				prefix, err := getIpamPrefixByCIDR(config, cidr, strconv.FormatInt(vrfID, 10))
+				return prefix, 0, err
+			}
+		}
+	}
+
+	return nil, 0, fmt.Errorf("Unexpected import ID %q, expected a prefix ID, a CIDR, <vrf>/<CIDR> or <prefix ID>/<parent prefix ID>", importID)
+}
+
+// getIpamPrefixByCIDR looks up a prefix of a VRF, or of the global table when the VRF ID is empty
+func getIpamPrefixByCIDR(config *Config, cidr, vrfID string) (*models.Prefix, error) {
+	table := "the global table"
+	if vrfID == "" {
+		vrfID = "null"
+	} else {
+		table = "VRF " + vrfID
+	}
+	params := ipam.IpamPrefixesListParams{
+		Prefix:  &cidr,
+		VrfID:   &vrfID,
+		Limit:   &NetboxApiGeneralQueryLimit,
+		Context: context.Background(),
+	}
+	res, err := config.ipam.IpamPrefixesList(&params, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot look up prefix %s in %s: %w", cidr, table, err)
+	}
+	if res == nil || res.Payload == nil || len(res.Payload.Results) == 0 {
+		return nil, fmt.Errorf("Prefix %s not found in %s", cidr, table)
+	}
+	if len(res.Payload.Results) > 1 {
+		return nil, fmt.Errorf("Found %d prefixes %s in %s, import one of them by its ID", len(res.Payload.Results), cidr, table)
+	}
+	return res.Payload.Results[0], nil
+}
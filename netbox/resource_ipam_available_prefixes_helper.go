@@ -3,10 +3,11 @@ package netbox
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net"
+	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
@@ -39,16 +40,6 @@ func flatternRole(nr *models.NestedRole) []map[string]interface{} {
 }
 */
 
-// NestedRole struct for netbox v2.4.7
-func flatternRoleV247(nr *models.NestedRole) []map[string]interface{} {
-	return []map[string]interface{}{{
-		"id":   nr.ID,
-		"name": nr.Name,
-		"slug": nr.Slug,
-		"url":  nr.URL.String(),
-	}}
-}
-
 func flatternSite(ns *models.NestedSite) []map[string]interface{} {
 	return []map[string]interface{}{{
 		"id":   ns.ID,
@@ -94,16 +85,6 @@ func flatternNestedVLAN(nv *models.NestedVLAN) []map[string]interface{} {
 	}}
 }
 
-// NestedVRF struct for netbox v2.4.7
-func flatternNestedVRFV247(nv *models.NestedVRF) []map[string]interface{} {
-	return []map[string]interface{}{{
-		"id":   nv.ID,
-		"name": nv.Name,
-		"url":  nv.URL.String(),
-		"rd":   nv.Rd,
-	}}
-}
-
 /*
 	func flatternNestedVRF(nv *models.NestedVRF) []map[string]interface{} {
 		return []map[string]interface{}{{
@@ -182,8 +163,6 @@ func flatterCustomFields(d *schema.ResourceData, v interface{}) []map[string]int
 		return nil
 	}
 	result := make(map[string]interface{})
-	log.Println("flatterCustomFields  ", d.Get("custom_fields"))
-
 	if _, ok := cf["helpers"]; ok {
 		result["helpers"] = cf["helpers"]
 	}
@@ -194,7 +173,6 @@ func flatterCustomFields(d *schema.ResourceData, v interface{}) []map[string]int
 		result["ipv4_acl_out"] = cf["ipv4_acl_out"]
 	}
 
-	log.Println("result  ", result)
 	cfs = append(cfs, result)
 	return cfs
 }
@@ -279,6 +257,41 @@ func IntAtLeastDiagFunc(min int) schema.SchemaValidateDiagFunc {
 	}
 }
 
+// DurationAtLeastDiagFunc validates a duration such as "2m", which must be at least min
+func DurationAtLeastDiagFunc(min time.Duration) schema.SchemaValidateDiagFunc {
+	return func(i interface{}, path cty.Path) (diags diag.Diagnostics) {
+		v, ok := i.(string)
+		if !ok {
+			diags = append(diags, diag.Diagnostic{
+				Severity:      diag.Error,
+				Summary:       fmt.Sprintf("expected type of %v to be string", path),
+				AttributePath: path,
+			})
+			return diags
+		}
+
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity:      diag.Error,
+				Summary:       fmt.Sprintf("expected %v to be a duration such as \"2m\", got %q", path, v),
+				AttributePath: path,
+			})
+			return diags
+		}
+		if d < min {
+			diags = append(diags, diag.Diagnostic{
+				Severity:      diag.Error,
+				Summary:       fmt.Sprintf("expected %v to be at least %s, got %s", path, min, d),
+				AttributePath: path,
+			})
+			return diags
+		}
+
+		return diags
+	}
+}
+
 func IntBetweenDiagFunc(min, max int) schema.SchemaValidateDiagFunc {
 	return func(i interface{}, path cty.Path) (diags diag.Diagnostics) {
 		v, ok := i.(int)
@@ -356,3 +369,29 @@ func StringLenBetween(min, max int) schema.SchemaValidateDiagFunc {
 		return diags
 	}
 }
+
+// StringMatchDiagFunc returns a SchemaValidateDiagFunc which tests if the provided value
+// is of type string, matches r and is no longer than max
+func StringMatchDiagFunc(r *regexp.Regexp, max int) schema.SchemaValidateDiagFunc {
+	return func(i interface{}, path cty.Path) (diags diag.Diagnostics) {
+		v, ok := i.(string)
+		if !ok {
+			diags = append(diags, diag.Diagnostic{
+				Severity:      diag.Error,
+				Summary:       fmt.Sprintf("expected type of %s to be string", path),
+				AttributePath: path,
+			})
+			return diags
+		}
+
+		if !r.MatchString(v) || len(v) > max {
+			diags = append(diags, diag.Diagnostic{
+				Severity:      diag.Error,
+				Summary:       fmt.Sprintf("expected %v to match %s with at most %d characters, got %s", path, r, max, v),
+				AttributePath: path,
+			})
+		}
+
+		return diags
+	}
+}
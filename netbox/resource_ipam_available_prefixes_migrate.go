@@ -0,0 +1,130 @@
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// resourceIpamAvailablePrefixesStateUpgraders bring the states of the older schema versions to the
+// current one, one version at a time. A schema change which breaks the states adds an upgrader from
+// the current version, along with a frozen copy of the current schema, and bumps SchemaVersion.
+func resourceIpamAvailablePrefixesStateUpgraders() []schema.StateUpgrader {
+	return []schema.StateUpgrader{
+		{
+			Version: 1,
+			Type:    resourceIpamAvailablePrefixesResourceV1().CoreConfigSchema().ImpliedType(),
+			Upgrade: resourceIpamAvailablePrefixesStateUpgradeV1,
+		},
+	}
+}
+
+// resourceIpamAvailablePrefixesResourceV1 is the schema of version 1, only its types matter
+func resourceIpamAvailablePrefixesResourceV1() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"parent_prefix":    {Type: schema.TypeString, Optional: true, Computed: true},
+			"prefix":           {Type: schema.TypeString, Optional: true, Computed: true},
+			"parent_prefix_id": {Type: schema.TypeInt, Optional: true, Computed: true},
+			"fallback_parent_prefix_ids": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeInt},
+			},
+			"prefix_length":       {Type: schema.TypeInt, Optional: true},
+			"subnet_index":        {Type: schema.TypeInt, Optional: true},
+			"allocation_strategy": {Type: schema.TypeString, Optional: true},
+			"alignment":           {Type: schema.TypeInt, Optional: true},
+			"role":                {Type: schema.TypeString, Optional: true},
+			"site":                {Type: schema.TypeString, Optional: true},
+			"tags": {
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"tenant":      {Type: schema.TypeString, Optional: true},
+			"vlan":        {Type: schema.TypeString, Optional: true},
+			"vrf":         {Type: schema.TypeString, Optional: true},
+			"is_pool":     {Type: schema.TypeBool, Optional: true},
+			"status":      {Type: schema.TypeString, Optional: true},
+			"description": {Type: schema.TypeString, Optional: true},
+			"custom_fields": {
+				Type:       schema.TypeList,
+				Required:   true,
+				ConfigMode: schema.SchemaConfigModeAttr,
+				MaxItems:   1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"helpers":      {Type: schema.TypeString, Optional: true},
+						"ipv4_acl_in":  {Type: schema.TypeString, Optional: true},
+						"ipv4_acl_out": {Type: schema.TypeString, Optional: true},
+					},
+				},
+			},
+			"created":      {Type: schema.TypeString, Computed: true},
+			"family":       {Type: schema.TypeInt, Computed: true},
+			"last_updated": {Type: schema.TypeString, Computed: true},
+		},
+	}
+}
+
+// resourceIpamAvailablePrefixesStateUpgradeV1 records the parent_prefix of the states of version 1
+// which only hold parent_prefix_id. The parent a prefix was carved under is kept as long as parent_prefix
+// holds the prefix, without it the refresh would move the prefix to its nearest parent and replace it.
+func resourceIpamAvailablePrefixesStateUpgradeV1(ctx context.Context, rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	if rawState == nil {
+		return rawState, nil
+	}
+	if parent, _ := rawState["parent_prefix"].(string); parent != "" {
+		return rawState, nil
+	}
+	parentID, ok := stateInt(rawState["parent_prefix_id"])
+	if !ok || parentID <= 0 {
+		return rawState, nil
+	}
+	config, ok := meta.(*Config)
+	if !ok || config == nil {
+		return rawState, nil
+	}
+	ctx = newLogSubsystem(ctx, config, logSubsystemAvailablePrefixes)
+
+	parent, err := getIpamPrefixByID(config, parentID)
+	if isNotFound(err) {
+		// Left to the refresh, which records the parent netbox nests the prefix under
+		tflog.SubsystemWarn(ctx, logSubsystemAvailablePrefixes, "Parent prefix not found, parent_prefix left empty", map[string]interface{}{
+			"id":               rawState["id"],
+			"parent_prefix_id": parentID,
+		})
+		return rawState, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot upgrade the state of prefix %v: %w", rawState["id"], err)
+	}
+	if parent != nil && parent.Prefix != nil {
+		rawState["parent_prefix"] = *parent.Prefix
+	}
+	return rawState, nil
+}
+
+// stateInt reads a number of a raw state, which is decoded as a float64, or a json.Number
+func stateInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	case string:
+		i, err := strconv.ParseInt(n, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,206 @@
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty/msgpack"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/fenglyu/go-netbox/netbox/client/ipam"
+	"github.com/fenglyu/go-netbox/netbox/models"
+)
+
+// testPrefixStateV1 is a state written by the provider at schema version 1
+const testPrefixStateV1 = `{
+  "version": 4,
+  "terraform_version": "0.14.11",
+  "serial": 12,
+  "lineage": "6a1e3a55-4cb2-6a6d-0a38-d1e0d4c7a6b2",
+  "outputs": {},
+  "resources": [
+    {
+      "mode": "managed",
+      "type": "netbox_available_prefixes",
+      "name": "gke",
+      "provider": "provider[\"terraform.cloud.blizzard.net/cf/netbox\"]",
+      "instances": [
+        {
+          "schema_version": 1,
+          "attributes": {
+            "created": "2021-03-02",
+            "custom_fields": [
+              {
+                "helpers": "10.0.1.1",
+                "ipv4_acl_in": "",
+                "ipv4_acl_out": ""
+              }
+            ],
+            "description": "gke nodes",
+            "family": 4,
+            "id": "20",
+            "is_pool": true,
+            "last_updated": "2021-03-02T21:07:08.331027Z",
+            "parent_prefix": null,
+            "parent_prefix_id": 10,
+            "prefix": "10.0.1.0/24",
+            "prefix_length": 24,
+            "role": "gcp",
+            "site": "se1",
+            "status": "active",
+            "tags": [
+              "gke"
+            ],
+            "tenant": "cloud",
+            "timeouts": null,
+            "vlan": "gcp",
+            "vrf": ""
+          },
+          "private": "eyJlMmJmYjczMC1lY2FhLTExZTYtOGY4OC0zNDM2M2JjN2M0YzAiOnsiY3JlYXRlIjo2MDAwMDAwMDAwMDB9LCJzY2hlbWFfdmVyc2lvbiI6IjEifQ=="
+        }
+      ]
+    }
+  ]
+}`
+
+// testPrefixStateV1Attributes returns the raw attributes of the prefix of testPrefixStateV1
+func testPrefixStateV1Attributes(t *testing.T) map[string]interface{} {
+	var state struct {
+		Resources []struct {
+			Instances []struct {
+				Attributes map[string]interface{} `json:"attributes"`
+			} `json:"instances"`
+		} `json:"resources"`
+	}
+	if err := json.Unmarshal([]byte(testPrefixStateV1), &state); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	return state.Resources[0].Instances[0].Attributes
+}
+
+func TestResourceIpamAvailablePrefixesStateUpgradeV1(t *testing.T) {
+	cases := map[string]struct {
+		raw      map[string]interface{}
+		mock     func(m *mockNetbox)
+		noMeta   bool
+		expected interface{}
+		err      string
+	}{
+		"parent looked up": {
+			expected: "10.0.0.0/16",
+		},
+		"parent kept": {
+			raw:      map[string]interface{}{"parent_prefix": "10.0.0.0/20"},
+			expected: "10.0.0.0/20",
+		},
+		"parent deleted": {
+			mock: func(m *mockNetbox) {
+				m.mockPrefixes()
+			},
+			expected: nil,
+		},
+		"parent lookup failure": {
+			mock: func(m *mockNetbox) {
+				m.prefixesRead = func(*ipam.IpamPrefixesReadParams) (*ipam.IpamPrefixesReadOK, error) {
+					return nil, mockAPIError("ipam_prefixes_read", http.StatusBadGateway)
+				}
+			},
+			err: "Cannot upgrade the state of prefix 20",
+		},
+		"without parent id": {
+			raw:      map[string]interface{}{"parent_prefix_id": nil, "parent_prefix": "10.0.0.0/16"},
+			expected: "10.0.0.0/16",
+		},
+		"provider not configured": {
+			noMeta:   true,
+			expected: nil,
+		},
+	}
+
+	for tn, tc := range cases {
+		m := &mockNetbox{}
+		m.mockPrefixes(mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(20, "10.0.1.0/24", nil))
+		if tc.mock != nil {
+			tc.mock(m)
+		}
+		var meta interface{} = m.config()
+		if tc.noMeta {
+			meta = nil
+		}
+
+		raw := testPrefixStateV1Attributes(t)
+		for k, v := range tc.raw {
+			raw[k] = v
+		}
+		upgraded, err := resourceIpamAvailablePrefixesStateUpgradeV1(context.Background(), raw, meta)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("%s: expected an error containing %q, got %v", tn, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tn, err)
+		}
+		if upgraded["parent_prefix"] != tc.expected {
+			t.Fatalf("%s: expected parent_prefix %v, got %v", tn, tc.expected, upgraded["parent_prefix"])
+		}
+		if upgraded["parent_prefix_id"] != raw["parent_prefix_id"] || upgraded["prefix"] != "10.0.1.0/24" {
+			t.Fatalf("%s: expected the other attributes kept, got %v", tn, upgraded)
+		}
+	}
+}
+
+// The state of version 1 goes through the upgraders the way terraform upgrades it
+func TestResourceIpamAvailablePrefixesUpgradeResourceState(t *testing.T) {
+	m := &mockNetbox{}
+	m.mockPrefixes(mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(20, "10.0.1.0/24", &models.NestedVRF{ID: 7}))
+	provider := Provider()
+	provider.SetMeta(m.config())
+
+	var state struct {
+		Resources []struct {
+			Instances []struct {
+				SchemaVersion int64           `json:"schema_version"`
+				Attributes    json.RawMessage `json:"attributes"`
+			} `json:"instances"`
+		} `json:"resources"`
+	}
+	if err := json.Unmarshal([]byte(testPrefixStateV1), &state); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	instance := state.Resources[0].Instances[0]
+
+	server := schema.NewGRPCProviderServer(provider)
+	res, err := server.UpgradeResourceState(context.Background(), &tfprotov5.UpgradeResourceStateRequest{
+		TypeName: "netbox_available_prefixes",
+		Version:  instance.SchemaVersion,
+		RawState: &tfprotov5.RawState{JSON: instance.Attributes},
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	for _, d := range res.Diagnostics {
+		t.Fatalf("unexpected diagnostic %s: %s", d.Summary, d.Detail)
+	}
+
+	upgraded, err := msgpack.Unmarshal(res.UpgradedState.MsgPack, resourceIpamAvailablePrefixes().CoreConfigSchema().ImpliedType())
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	attributes := upgraded.AsValueMap()
+	if parent := attributes["parent_prefix"]; parent.IsNull() || parent.AsString() != "10.0.0.0/16" {
+		t.Errorf("expected parent_prefix 10.0.0.0/16, got %#v", parent)
+	}
+	if id := attributes["id"].AsString(); id != "20" {
+		t.Errorf("expected ID 20, got %s", id)
+	}
+	cfs := attributes["custom_fields"].AsValueSlice()
+	if len(cfs) != 1 || cfs[0].GetAttr("helpers").AsString() != "10.0.1.1" {
+		t.Errorf("expected the custom fields kept, got %#v", attributes["custom_fields"])
+	}
+}
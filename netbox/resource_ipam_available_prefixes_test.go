@@ -4,15 +4,20 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 
+	"github.com/fenglyu/go-netbox/netbox/client/dcim"
+	"github.com/fenglyu/go-netbox/netbox/client/extras"
 	"github.com/fenglyu/go-netbox/netbox/client/ipam"
 	"github.com/fenglyu/go-netbox/netbox/models"
 )
@@ -66,10 +71,34 @@ func TestAccAvailablePrefixes_basic1(t *testing.T) {
 				ImportStateVerify:       true,
 				ImportStateVerifyIgnore: []string{"parent_prefix_id"},
 			},
+			{
+				ResourceName:            "netbox_available_prefixes.foo",
+				ImportState:             true,
+				ImportStateIdFunc:       testAccAvailablePrefixImportID("netbox_available_prefixes.foo", "activision/%[1]s"),
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"parent_prefix_id"},
+			},
+			{
+				ResourceName:      "netbox_available_prefixes.foo",
+				ImportState:       true,
+				ImportStateIdFunc: testAccAvailablePrefixImportID("netbox_available_prefixes.foo", fmt.Sprintf("%%[2]s/%d", testNetboxParentPrefixIdWithVrf)),
+				ImportStateVerify: true,
+			},
 		},
 	})
 }
 
+// testAccAvailablePrefixImportID formats the import ID of a prefix, given its prefix and its ID
+func testAccAvailablePrefixImportID(name, format string) resource.ImportStateIdFunc {
+	return func(s *terraform.State) (string, error) {
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return "", fmt.Errorf("%s not found", name)
+		}
+		return fmt.Sprintf(format, rs.Primary.Attributes["prefix"], rs.Primary.ID), nil
+	}
+}
+
 func TestAccAvailablePrefixes_basic2(t *testing.T) {
 	context := map[string]interface{}{
 		"random_prefix_length": randIntRange(t, 16, 30),
@@ -120,6 +149,105 @@ func TestAccAvailablePrefixes_EmptyCustomFields(t *testing.T) {
 	})
 }
 
+func TestAccAvailablePrefixes_subnetIndex(t *testing.T) {
+	context := map[string]interface{}{
+		"subnet_index":     randIntRange(t, 0, 16),
+		"random_suffix":    randString(t, 10),
+		"parent_prefix_id": testNetboxParentPrefixId,
+	}
+
+	resource.ParallelTest(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		CheckDestroy:      testAccCheckAvailablePrefixesDestroyProducer(t),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccAvailablePrefixWithSubnetIndex(context),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckResourceAttrOutput("netbox_available_prefixes.index", "prefix", "expected_prefix"),
+				),
+			},
+			{
+				Config:      testAccAvailablePrefixWithSubnetIndexTaken(context),
+				ExpectError: regexp.MustCompile("is not available"),
+			},
+		},
+	})
+}
+
+func TestAccAvailablePrefixes_allocationStrategy(t *testing.T) {
+	context := map[string]interface{}{
+		"random_suffix":    randString(t, 10),
+		"parent_prefix_id": testNetboxParentPrefixId,
+	}
+
+	resource.ParallelTest(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		CheckDestroy:      testAccCheckAvailablePrefixesDestroyProducer(t),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccAvailablePrefixWithAllocationStrategy(context),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckResourceAttrOutput("netbox_available_prefixes.first", "prefix", "expected_first"),
+					testAccCheckResourceAttrOutput("netbox_available_prefixes.last", "prefix", "expected_last"),
+					testAccCheckResourceAttrOutput("netbox_available_prefixes.aligned", "prefix", "expected_aligned"),
+				),
+			},
+		},
+	})
+}
+
+// testAccCheckResourceAttrOutput checks that the attribute of a resource equals the value of an output
+func testAccCheckResourceAttrOutput(resourceName, key, outputName string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[resourceName]
+		if !ok {
+			return fmt.Errorf("can't find %s in state", resourceName)
+		}
+		output, ok := s.RootModule().Outputs[outputName]
+		if !ok {
+			return fmt.Errorf("can't find output %s in state", outputName)
+		}
+		if rs.Primary.Attributes[key] != output.Value {
+			return fmt.Errorf("%s.%s is %s; want %v", resourceName, key, rs.Primary.Attributes[key], output.Value)
+		}
+		return nil
+	}
+}
+
+func testAccAvailablePrefixWithSubnetIndex(context map[string]interface{}) string {
+	return Nprintf(`
+data "netbox_prefix" "parent" {
+	id = %{parent_prefix_id}
+}
+
+resource "netbox_available_prefixes" "index" {
+	parent_prefix_id = %{parent_prefix_id}
+	prefix_length    = data.netbox_prefix.parent.prefix_length + 4
+	subnet_index     = %{subnet_index}
+	tags             = ["AvailablePrefix-acc%{random_suffix}-01"]
+	custom_fields {}
+}
+
+output "expected_prefix" {
+	value = cidrsubnet(data.netbox_prefix.parent.prefix, 4, %{subnet_index})
+}`, context)
+}
+
+func testAccAvailablePrefixWithSubnetIndexTaken(context map[string]interface{}) string {
+	return testAccAvailablePrefixWithSubnetIndex(context) + Nprintf(`
+
+resource "netbox_available_prefixes" "taken" {
+	parent_prefix_id = %{parent_prefix_id}
+	prefix_length    = data.netbox_prefix.parent.prefix_length + 4
+	subnet_index     = %{subnet_index}
+	tags             = ["AvailablePrefix-acc%{random_suffix}-02"]
+	custom_fields {}
+	depends_on       = [netbox_available_prefixes.index]
+}`, context)
+}
+
 func TestAccAvailablePrefixesMultipleSteps(t *testing.T) {
 	context := map[string]interface{}{
 		"random_prefix_length": randIntRange(t, 16, 30),
@@ -151,6 +279,120 @@ func TestAccAvailablePrefixesMultipleSteps(t *testing.T) {
 	})
 }
 
+// testAccPrefixDrifts are changes made to a prefix in the UI, each of which must show in the plan
+var testAccPrefixDrifts = []struct {
+	name    string
+	data    models.WritablePrefix
+	cleared map[string]interface{}
+}{
+	{name: "role cleared", cleared: map[string]interface{}{"role": nil}},
+	{name: "site cleared", cleared: map[string]interface{}{"site": nil}},
+	{name: "tenant cleared", cleared: map[string]interface{}{"tenant": nil}},
+	{name: "vlan cleared", cleared: map[string]interface{}{"vlan": nil}},
+	{name: "vrf cleared", cleared: map[string]interface{}{"vrf": nil}},
+	{name: "description cleared", cleared: map[string]interface{}{"description": ""}},
+	{name: "status", data: models.WritablePrefix{Status: "deprecated"}},
+	{name: "is_pool", data: models.WritablePrefix{IsPool: new(bool)}},
+	{name: "tags", data: models.WritablePrefix{Tags: []string{"drifted"}}},
+	{name: "custom fields", data: models.WritablePrefix{CustomFields: map[string]interface{}{"helpers": "drifted"}}},
+}
+
+func TestAccAvailablePrefixes_drift(t *testing.T) {
+	context := map[string]interface{}{
+		"random_suffix":    randString(t, 10),
+		"parent_prefix_id": testNetboxParentPrefixIdWithVrf,
+	}
+
+	var id string
+	steps := []resource.TestStep{
+		{
+			Config: testAccAvailablePrefixDrift(context),
+			Check:  testAccCheckAvailablePrefixID("netbox_available_prefixes.drift", &id),
+		},
+	}
+	for _, drift := range testAccPrefixDrifts {
+		drift := drift
+		steps = append(steps,
+			resource.TestStep{
+				PreConfig: func() {
+					if err := testChangePrefix(testAccProvider.Meta().(*Config), id, drift.data, drift.cleared); err != nil {
+						t.Fatalf("%s: %v", drift.name, err)
+					}
+				},
+				Config:             testAccAvailablePrefixDrift(context),
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+			// Applying the config undoes the change
+			resource.TestStep{
+				Config: testAccAvailablePrefixDrift(context),
+			},
+		)
+	}
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		CheckDestroy:      testAccCheckAvailablePrefixesDestroyProducer(t),
+		Steps:             steps,
+	})
+}
+
+func testAccCheckAvailablePrefixID(name string, id *string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("%s not found", name)
+		}
+		*id = rs.Primary.ID
+		return nil
+	}
+}
+
+// testChangePrefix updates a prefix behind terraform's back
+func testChangePrefix(config *Config, id string, data models.WritablePrefix, cleared map[string]interface{}) error {
+	prefixID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return err
+	}
+	prefix, err := getIpamPrefixByID(config, prefixID)
+	if err != nil {
+		return err
+	}
+	data.Prefix = prefix.Prefix
+	params := ipam.IpamPrefixesPartialUpdateParams{
+		ID:      prefixID,
+		Data:    &data,
+		Context: withClearedFields(context.Background(), cleared),
+	}
+	_, err = config.ipam.IpamPrefixesPartialUpdate(&params, nil)
+	return err
+}
+
+func testAccAvailablePrefixDrift(context map[string]interface{}) string {
+	return Nprintf(`
+resource "netbox_available_prefixes" "drift" {
+	parent_prefix_id = %{parent_prefix_id}
+	prefix_length    = 28
+	is_pool          = true
+	status           = "active"
+	description      = "drift-acc%{random_suffix}"
+
+	role   = "gcp"
+	site   = "se1"
+	vlan   = "gcp"
+	vrf    = "activision"
+	tenant = "cloud"
+	tags   = ["AvailablePrefix-acc%{random_suffix}-01"]
+
+	custom_fields {
+		helpers      = "cf-acc%{random_suffix}-01"
+		ipv4_acl_in  = "cf-acc%{random_suffix}-02"
+		ipv4_acl_out = "cf-acc%{random_suffix}-03"
+	}
+}`, context)
+}
+
 func testAccAvailablePrefixWithParentPrefixIdMultipleStep1(context map[string]interface{}) string {
 	return Nprintf(`
 resource "netbox_available_prefixes" "bar" {
@@ -353,7 +595,7 @@ func sendRequestforPrefix(config *Config, rs *terraform.ResourceState) ([]*model
 		}
 		params.WithContext(context.Background())
 
-		ipamPrefixesReadOK, err := config.client.Ipam.IpamPrefixesRead(&params, nil)
+		ipamPrefixesReadOK, err := config.ipam.IpamPrefixesRead(&params, nil, nil)
 		if err != nil {
 			mulError = multierror.Append(mulError, fmt.Errorf("Lookup Prefix ID %s", idStr))
 		}
@@ -364,3 +606,708 @@ func sendRequestforPrefix(config *Config, rs *terraform.ResourceState) ([]*model
 
 	return prefixList, mulError
 }
+
+// testAccAvailablePrefixWithAllocationStrategy allocates within a fresh container, so the placement is predictable
+func testAccAvailablePrefixWithAllocationStrategy(context map[string]interface{}) string {
+	return Nprintf(`
+data "netbox_prefix" "parent" {
+	id = %{parent_prefix_id}
+}
+
+resource "netbox_available_prefixes" "container" {
+	parent_prefix_id = %{parent_prefix_id}
+	prefix_length    = data.netbox_prefix.parent.prefix_length + 4
+	status           = "container"
+	tags             = ["AvailablePrefix-acc%{random_suffix}-01"]
+	custom_fields {}
+}
+
+resource "netbox_available_prefixes" "first" {
+	parent_prefix_id = netbox_available_prefixes.container.id
+	prefix_length    = netbox_available_prefixes.container.prefix_length + 3
+	tags             = ["AvailablePrefix-acc%{random_suffix}-01"]
+	custom_fields {}
+}
+
+resource "netbox_available_prefixes" "last" {
+	parent_prefix_id    = netbox_available_prefixes.container.id
+	prefix_length       = netbox_available_prefixes.container.prefix_length + 2
+	allocation_strategy = "last_fit"
+	tags                = ["AvailablePrefix-acc%{random_suffix}-01"]
+	custom_fields {}
+}
+
+resource "netbox_available_prefixes" "aligned" {
+	parent_prefix_id    = netbox_available_prefixes.container.id
+	prefix_length       = netbox_available_prefixes.container.prefix_length + 3
+	allocation_strategy = "aligned"
+	alignment           = netbox_available_prefixes.container.prefix_length + 2
+	tags                = ["AvailablePrefix-acc%{random_suffix}-01"]
+	custom_fields {}
+
+	depends_on = [netbox_available_prefixes.first, netbox_available_prefixes.last]
+}
+
+output "expected_first" {
+	value = cidrsubnet(netbox_available_prefixes.container.prefix, 3, 0)
+}
+
+output "expected_last" {
+	value = cidrsubnet(netbox_available_prefixes.container.prefix, 2, 3)
+}
+
+output "expected_aligned" {
+	value = cidrsubnet(netbox_available_prefixes.container.prefix, 3, 2)
+}`, context)
+}
+
+func TestAvailablePrefixes_fakeNetbox(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	create := func(raw map[string]interface{}) (*schema.ResourceData, diag.Diagnostics) {
+		raw["parent_prefix_id"] = int(f.parentPrefixID)
+		raw["custom_fields"] = []interface{}{map[string]interface{}{"helpers": ""}}
+		d := schema.TestResourceDataRaw(t, resourceIpamAvailablePrefixes().Schema, raw)
+		return d, resourceIpamAvailablePrefixesCreate(context.Background(), d, config)
+	}
+
+	cases := []struct {
+		name     string
+		raw      map[string]interface{}
+		expected string
+		err      string
+	}{
+		{name: "first", raw: map[string]interface{}{"prefix_length": 24, "description": "testAcc first"}, expected: "10.0.0.0/24"},
+		{name: "next", raw: map[string]interface{}{"prefix_length": 24}, expected: "10.0.1.0/24"},
+		{name: "smaller", raw: map[string]interface{}{"prefix_length": 26}, expected: "10.0.2.0/26"},
+		{name: "last fit", raw: map[string]interface{}{"prefix_length": 24, "allocation_strategy": "last_fit"}, expected: "10.15.255.0/24"},
+		{name: "best fit", raw: map[string]interface{}{"prefix_length": 27, "allocation_strategy": "best_fit"}, expected: "10.0.2.64/27"},
+		{name: "too large", raw: map[string]interface{}{"prefix_length": 11}, err: "Insufficient space"},
+	}
+
+	var first *schema.ResourceData
+	for _, tc := range cases {
+		d, diags := create(tc.raw)
+		if tc.err != "" {
+			if !diags.HasError() || !strings.Contains(diags[0].Summary, tc.err) {
+				t.Fatalf("%s: expected an error containing %q, got %v", tc.name, tc.err, diags)
+			}
+			if d.Id() != "" {
+				t.Fatalf("%s: expected no ID, got %s", tc.name, d.Id())
+			}
+			continue
+		}
+		if diags.HasError() {
+			t.Fatalf("%s: unexpected error %v", tc.name, diags)
+		}
+		if prefix := d.Get("prefix").(string); prefix != tc.expected {
+			t.Fatalf("%s: expected %s, got %s", tc.name, tc.expected, prefix)
+		}
+		if status := d.Get("status").(string); status != "active" {
+			t.Fatalf("%s: expected the reservation committed as active, got %s", tc.name, status)
+		}
+		if parent := d.Get("parent_prefix_id").(int); int64(parent) != f.parentPrefixID {
+			t.Fatalf("%s: expected the parent %d, got %d", tc.name, f.parentPrefixID, parent)
+		}
+		if first == nil {
+			first = d
+		}
+	}
+
+	if diags := resourceIpamAvailablePrefixesDelete(context.Background(), first, config); diags.HasError() {
+		t.Fatalf("unexpected error %v", diags)
+	}
+	if f.requestCount("DELETE", "/ipam/prefixes/{id}/") != 1 {
+		t.Fatalf("expected the prefix to be deleted")
+	}
+	d, diags := create(map[string]interface{}{"prefix_length": 24})
+	if diags.HasError() {
+		t.Fatalf("unexpected error %v", diags)
+	}
+	if prefix := d.Get("prefix").(string); prefix != "10.0.0.0/24" {
+		t.Fatalf("expected the freed 10.0.0.0/24 to be allocated again, got %s", prefix)
+	}
+	for _, tag := range f.tags {
+		if strings.HasPrefix(*tag.Name, reservationTagPrefix) {
+			t.Fatalf("expected the reservation tags to be deleted, got %s", *tag.Name)
+		}
+	}
+}
+
+func testAvailablePrefixesData(t *testing.T, id string, raw map[string]interface{}) *schema.ResourceData {
+	if _, ok := raw["custom_fields"]; !ok {
+		raw["custom_fields"] = []interface{}{map[string]interface{}{"helpers": ""}}
+	}
+	d := schema.TestResourceDataRaw(t, resourceIpamAvailablePrefixes().Schema, raw)
+	d.SetId(id)
+	return d
+}
+
+func TestResourceIpamAvailablePrefixesCreate(t *testing.T) {
+	parent := mockPrefix(10, "10.0.0.0/16", nil)
+	allocated := mockPrefix(20, "10.0.1.0/24", nil)
+
+	cases := map[string]struct {
+		raw      map[string]interface{}
+		mock     func(m *mockNetbox)
+		expected string
+		err      string
+	}{
+		"allocated": {
+			raw: map[string]interface{}{"parent_prefix_id": 10, "prefix_length": 24, "description": "uplinks"},
+			mock: func(m *mockNetbox) {
+				m.availablePrefixesCreate = func(params *ipam.IpamPrefixesAvailablePrefixesCreateParams) (*ipam.IpamPrefixesAvailablePrefixesCreateCreated, error) {
+					if params.ID != 10 || params.Data.PrefixLength != 24 || params.Data.Status != prefixStatusReservedSlug {
+						return nil, fmt.Errorf("unexpected request %d %+v", params.ID, params.Data)
+					}
+					return &ipam.IpamPrefixesAvailablePrefixesCreateCreated{Payload: allocated}, nil
+				}
+			},
+			expected: "10.0.1.0/24",
+		},
+		"by parent prefix": {
+			raw:      map[string]interface{}{"parent_prefix": "10.0.0.0/16", "prefix_length": 24},
+			expected: "10.0.1.0/24",
+		},
+		"insufficient space": {
+			raw: map[string]interface{}{"parent_prefix_id": 10, "prefix_length": 24},
+			mock: func(m *mockNetbox) {
+				m.availablePrefixesCreate = func(*ipam.IpamPrefixesAvailablePrefixesCreateParams) (*ipam.IpamPrefixesAvailablePrefixesCreateCreated, error) {
+					return nil, mockInsufficientSpaceError()
+				}
+			},
+			err: `Insufficient space is available to accommodate the requested prefix size(s) "/24"`,
+		},
+		"rejected": {
+			raw: map[string]interface{}{"parent_prefix_id": 10, "prefix_length": 24},
+			mock: func(m *mockNetbox) {
+				m.availablePrefixesCreate = func(*ipam.IpamPrefixesAvailablePrefixesCreateParams) (*ipam.IpamPrefixesAvailablePrefixesCreateCreated, error) {
+					return nil, mockAPIError("ipam_prefixes_available-prefixes_create", http.StatusBadRequest)
+				}
+			},
+			err: "status 400",
+		},
+		"missing parent prefix": {
+			raw: map[string]interface{}{"parent_prefix": "10.9.0.0/16", "prefix_length": 24},
+			err: "Unknow prefix 10.9.0.0/16",
+		},
+		"parent lookup failure": {
+			raw: map[string]interface{}{"parent_prefix": "10.0.0.0/16", "prefix_length": 24},
+			mock: func(m *mockNetbox) {
+				m.prefixesList = func(*ipam.IpamPrefixesListParams) (*ipam.IpamPrefixesListOK, error) {
+					return nil, mockAPIError("ipam_prefixes_list", http.StatusInternalServerError)
+				}
+			},
+			err: "status 500",
+		},
+		"incompatible site and tenant": {
+			raw: map[string]interface{}{"parent_prefix_id": 10, "prefix_length": 24, "site": "se1", "tenant": "other"},
+			mock: func(m *mockNetbox) {
+				m.sitesList = func(*dcim.DcimSitesListParams) (*dcim.DcimSitesListOK, error) {
+					name, slug, tenant := "se1", "se1", "cloud"
+					count := int64(1)
+					site := &models.Site{ID: 3, Name: &name, Slug: &slug, Tenant: &models.NestedTenant{ID: 4, Name: &tenant}}
+					return &dcim.DcimSitesListOK{Payload: &dcim.DcimSitesListOKBody{Count: &count, Results: []*models.Site{site}}}, nil
+				}
+			},
+			err: "Incompatible site se1 and the tenant other, expected tenant cloud",
+		},
+		"reserved prefix lookup failure": {
+			raw: map[string]interface{}{"parent_prefix_id": 10, "prefix_length": 24},
+			mock: func(m *mockNetbox) {
+				// The marker tag is left by an earlier run
+				m.tagsList = func(params *extras.ExtrasTagsListParams) (*extras.ExtrasTagsListOK, error) {
+					return &extras.ExtrasTagsListOK{Payload: &extras.ExtrasTagsListOKBody{Results: []*models.Tag{{ID: 1, Name: params.Slug, Slug: params.Slug}}}}, nil
+				}
+				list := m.prefixesList
+				m.prefixesList = func(params *ipam.IpamPrefixesListParams) (*ipam.IpamPrefixesListOK, error) {
+					if params.Tag != nil {
+						return nil, mockAPIError("ipam_prefixes_list", http.StatusServiceUnavailable)
+					}
+					return list(params)
+				}
+			},
+			err: "Cannot look up prefixes reserved with tf-reserved-",
+		},
+		"commit failure": {
+			raw: map[string]interface{}{"parent_prefix_id": 10, "prefix_length": 24, "fallback_parent_prefix_ids": []interface{}{11}},
+			mock: func(m *mockNetbox) {
+				m.prefixesPartialUpdate = func(*ipam.IpamPrefixesPartialUpdateParams) (*ipam.IpamPrefixesPartialUpdateOK, error) {
+					return nil, mockAPIError("ipam_prefixes_partial_update", http.StatusInternalServerError)
+				}
+			},
+			err: "Prefix 10.0.1.0/24 is reserved but its status could not be set to active",
+		},
+	}
+
+	for tn, tc := range cases {
+		m := &mockNetbox{}
+		m.mockPrefixes(parent, allocated)
+		m.mockTags()
+		m.availablePrefixesCreate = func(*ipam.IpamPrefixesAvailablePrefixesCreateParams) (*ipam.IpamPrefixesAvailablePrefixesCreateCreated, error) {
+			return &ipam.IpamPrefixesAvailablePrefixesCreateCreated{Payload: allocated}, nil
+		}
+		var committed *models.WritablePrefix
+		m.prefixesPartialUpdate = func(params *ipam.IpamPrefixesPartialUpdateParams) (*ipam.IpamPrefixesPartialUpdateOK, error) {
+			committed = params.Data
+			return &ipam.IpamPrefixesPartialUpdateOK{Payload: allocated}, nil
+		}
+		if tc.mock != nil {
+			tc.mock(m)
+		}
+
+		d := testAvailablePrefixesData(t, "", tc.raw)
+		diags := resourceIpamAvailablePrefixesCreate(context.Background(), d, m.config())
+		if tc.err != "" {
+			if !diags.HasError() || !strings.Contains(diags[0].Summary, tc.err) {
+				t.Fatalf("%s: expected an error containing %q, got %v", tn, tc.err, diags)
+			}
+			if d.Id() != "" {
+				t.Fatalf("%s: expected no ID, got %s", tn, d.Id())
+			}
+			continue
+		}
+		if diags.HasError() {
+			t.Fatalf("%s: unexpected error %v, calls %v", tn, diags, m.calls)
+		}
+		if d.Id() != "20" || d.Get("prefix").(string) != tc.expected {
+			t.Fatalf("%s: expected prefix %s with ID 20, got %s with ID %s", tn, tc.expected, d.Get("prefix"), d.Id())
+		}
+		if committed == nil || committed.Status != "active" {
+			t.Fatalf("%s: expected the reservation committed as active, got %+v", tn, committed)
+		}
+	}
+}
+
+func TestResourceIpamAvailablePrefixesRead(t *testing.T) {
+	vrf := &models.NestedVRF{ID: 7}
+	cases := map[string]struct {
+		prefixes []*models.Prefix
+		raw      map[string]interface{}
+		mock     func(m *mockNetbox)
+		expected map[string]interface{}
+		removed  bool
+		err      string
+	}{
+		"global": {
+			prefixes: []*models.Prefix{mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(20, "10.0.1.0/24", nil)},
+			raw:      map[string]interface{}{"parent_prefix_id": 1},
+			expected: map[string]interface{}{"prefix": "10.0.1.0/24", "prefix_length": 24, "parent_prefix_id": 10, "status": "active"},
+		},
+		"within a vrf": {
+			prefixes: []*models.Prefix{mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(11, "10.0.0.0/16", vrf), mockPrefix(20, "10.0.1.0/24", vrf)},
+			raw:      map[string]interface{}{"parent_prefix": "10.1.0.0/16"},
+			expected: map[string]interface{}{"prefix": "10.0.1.0/24", "parent_prefix": "10.0.0.0/16", "parent_prefix_id": 11},
+		},
+		"global prefix under global parents only": {
+			prefixes: []*models.Prefix{mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(11, "10.0.0.0/20", vrf), mockPrefix(20, "10.0.1.0/24", nil)},
+			raw:      map[string]interface{}{"parent_prefix_id": 1},
+			expected: map[string]interface{}{"parent_prefix": "10.0.0.0/16", "parent_prefix_id": 10},
+		},
+		"nearest ancestor": {
+			prefixes: []*models.Prefix{mockPrefix(12, "10.0.0.0/20", nil), mockPrefix(11, "10.0.0.0/16", nil), mockPrefix(10, "10.0.0.0/8", nil), mockPrefix(20, "10.0.1.0/24", nil)},
+			raw:      map[string]interface{}{"parent_prefix_id": 1},
+			expected: map[string]interface{}{"parent_prefix": "10.0.0.0/20", "parent_prefix_id": 12},
+		},
+		"reparented in netbox": {
+			prefixes: []*models.Prefix{mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(12, "10.0.0.0/20", nil), mockPrefix(20, "10.0.1.0/24", nil)},
+			raw:      map[string]interface{}{"parent_prefix": "10.0.0.0/16", "parent_prefix_id": 10},
+			expected: map[string]interface{}{"parent_prefix": "10.0.0.0/16", "parent_prefix_id": 10},
+		},
+		"moved out of the parent": {
+			prefixes: []*models.Prefix{mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(20, "10.0.1.0/24", nil)},
+			raw:      map[string]interface{}{"parent_prefix": "10.2.0.0/16", "parent_prefix_id": 30},
+			expected: map[string]interface{}{"parent_prefix": "10.0.0.0/16", "parent_prefix_id": 10},
+		},
+		"managed tags hidden": {
+			prefixes: []*models.Prefix{mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(20, "10.0.1.0/24", nil)},
+			raw:      map[string]interface{}{"parent_prefix_id": 10},
+			expected: map[string]interface{}{"tags.#": 0},
+		},
+		"missing parent": {
+			prefixes: []*models.Prefix{mockPrefix(20, "10.0.1.0/24", nil)},
+			raw:      map[string]interface{}{"parent_prefix_id": 10},
+			err:      "prefix 10.0.1.0/24 with ID 20 has no parent prefix",
+		},
+		"deleted out of band": {
+			prefixes: []*models.Prefix{mockPrefix(10, "10.0.0.0/16", nil)},
+			raw:      map[string]interface{}{"parent_prefix_id": 10},
+			removed:  true,
+		},
+		"read failure": {
+			prefixes: []*models.Prefix{mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(20, "10.0.1.0/24", nil)},
+			raw:      map[string]interface{}{"parent_prefix_id": 10},
+			mock: func(m *mockNetbox) {
+				m.prefixesRead = func(*ipam.IpamPrefixesReadParams) (*ipam.IpamPrefixesReadOK, error) {
+					return nil, mockAPIError("ipam_prefixes_read", http.StatusForbidden)
+				}
+			},
+			err: "Cannot determine prefix with ID 20",
+		},
+		"parent lookup failure": {
+			prefixes: []*models.Prefix{mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(20, "10.0.1.0/24", nil)},
+			raw:      map[string]interface{}{"parent_prefix_id": 10},
+			mock: func(m *mockNetbox) {
+				m.prefixesList = func(*ipam.IpamPrefixesListParams) (*ipam.IpamPrefixesListOK, error) {
+					return nil, mockAPIError("ipam_prefixes_list", http.StatusBadGateway)
+				}
+			},
+			err: "status 502",
+		},
+	}
+
+	for tn, tc := range cases {
+		m := &mockNetbox{}
+		m.mockPrefixes(tc.prefixes...)
+		if tc.mock != nil {
+			tc.mock(m)
+		}
+
+		d := testAvailablePrefixesData(t, "20", tc.raw)
+		diags := resourceIpamAvailablePrefixesRead(context.Background(), d, m.config())
+		if tc.err != "" {
+			if !diags.HasError() || !strings.Contains(diags[0].Summary, tc.err) {
+				t.Fatalf("%s: expected an error containing %q, got %v", tn, tc.err, diags)
+			}
+			continue
+		}
+		if diags.HasError() {
+			t.Fatalf("%s: unexpected error %v", tn, diags)
+		}
+		if removed := d.Id() == ""; removed != tc.removed {
+			t.Fatalf("%s: expected removed %t, got ID %q", tn, tc.removed, d.Id())
+		}
+		for k, v := range tc.expected {
+			if got := d.Get(k); got != v {
+				t.Fatalf("%s: expected %s %v, got %v", tn, k, v, got)
+			}
+		}
+	}
+}
+
+func TestResourceIpamAvailablePrefixesDrift(t *testing.T) {
+	r := resourceIpamAvailablePrefixes()
+	for _, drift := range testAccPrefixDrifts {
+		f := newFakeNetbox()
+		f.lock.Lock()
+		f.ensureTag("gke")
+		f.lock.Unlock()
+		config, err := f.config()
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		raw := map[string]interface{}{
+			"parent_prefix_id": int(f.parentPrefixWithVrfID),
+			"prefix_length":    28,
+			"is_pool":          true,
+			"status":           "active",
+			"description":      "uplinks",
+			"role":             "gcp",
+			"site":             "se1",
+			"vlan":             "gcp",
+			"vrf":              "activision",
+			"tenant":           "cloud",
+			"tags":             []interface{}{"gke"},
+			"custom_fields":    []interface{}{map[string]interface{}{"helpers": "10.0.0.1", "ipv4_acl_in": "in", "ipv4_acl_out": "out"}},
+		}
+		d := schema.TestResourceDataRaw(t, r.Schema, raw)
+		if diags := resourceIpamAvailablePrefixesCreate(context.Background(), d, config); diags.HasError() {
+			t.Fatalf("%s: unexpected error %v", drift.name, diags)
+		}
+		if err := testChangePrefix(config, d.Id(), drift.data, drift.cleared); err != nil {
+			t.Fatalf("%s: %v", drift.name, err)
+		}
+
+		state, diags := r.RefreshWithoutUpgrade(context.Background(), d.State(), config)
+		if diags.HasError() {
+			t.Fatalf("%s: unexpected error %v", drift.name, diags)
+		}
+		cfg := terraform.NewResourceConfigRaw(raw)
+		diff, err := r.Diff(context.Background(), state, cfg, config)
+		if err != nil || diff.Empty() || diff.RequiresNew() {
+			t.Fatalf("%s: expected an in-place diff, got %v, %v", drift.name, diff, err)
+		}
+
+		// Applying the config undoes the change
+		if state, diags = r.Apply(context.Background(), state, diff, config); diags.HasError() {
+			t.Fatalf("%s: unexpected error %v", drift.name, diags)
+		}
+		if diff, err := r.Diff(context.Background(), state, cfg, config); err != nil || !diff.Empty() {
+			t.Fatalf("%s: expected no diff once applied, got %v, %v", drift.name, diff, err)
+		}
+		f.Close()
+	}
+}
+
+func TestResourceIpamAvailablePrefixesClear(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	r := resourceIpamAvailablePrefixes()
+	raw := map[string]interface{}{
+		"parent_prefix_id": int(f.parentPrefixWithVrfID),
+		"prefix_length":    28,
+		"description":      "uplinks",
+		"role":             "gcp",
+		"site":             "se1",
+		"vlan":             "gcp",
+		"vrf":              "activision",
+		"tenant":           "cloud",
+		"custom_fields":    []interface{}{map[string]interface{}{"helpers": ""}},
+	}
+	d := schema.TestResourceDataRaw(t, r.Schema, raw)
+	if diags := resourceIpamAvailablePrefixesCreate(context.Background(), d, config); diags.HasError() {
+		t.Fatalf("unexpected error %v", diags)
+	}
+
+	for _, key := range []string{"description", "role", "site", "vlan", "vrf", "tenant"} {
+		delete(raw, key)
+	}
+	cfg := terraform.NewResourceConfigRaw(raw)
+	diff, err := r.Diff(context.Background(), d.State(), cfg, config)
+	if err != nil || diff.Empty() {
+		t.Fatalf("expected a diff, got %v, %v", diff, err)
+	}
+	state, diags := r.Apply(context.Background(), d.State(), diff, config)
+	if diags.HasError() {
+		t.Fatalf("unexpected error %v", diags)
+	}
+	if diff, err := r.Diff(context.Background(), state, cfg, config); err != nil || !diff.Empty() {
+		t.Fatalf("expected no diff once applied, got %v, %v", diff, err)
+	}
+
+	id, _ := strconv.ParseInt(state.ID, 10, 64)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	p := f.prefixes[id]
+	if p.description != "" || p.role != nil || p.site != nil || p.vlan != nil || p.vrf != nil || p.tenant != nil {
+		t.Fatalf("expected the fields cleared, got %+v", p)
+	}
+}
+
+func TestResourceIpamAvailablePrefixesImportStateFormats(t *testing.T) {
+	f := newFakeNetbox()
+	defer f.Close()
+	config, err := f.config()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	f.lock.Lock()
+	vrf := f.vrfs[0]
+	container := f.seedPrefix("10.1.0.0/16", nil, "container")
+	global := f.seedPrefix("10.1.0.0/24", nil, "active")
+	inVrf := f.seedPrefix("10.1.0.0/24", vrf, "active")
+	underVrf := f.seedPrefix("172.16.1.0/24", vrf, "active")
+	f.lock.Unlock()
+
+	cases := map[string]struct {
+		importID string
+		id       int64
+		parentID int64
+		parent   string
+		err      string
+	}{
+		"id":               {importID: fmt.Sprint(global.id), id: global.id, parentID: container.id, parent: "10.1.0.0/16"},
+		"cidr":             {importID: "10.1.0.0/24", id: global.id, parentID: container.id, parent: "10.1.0.0/16"},
+		"vrf and cidr":     {importID: "activision/10.1.0.0/24", id: inVrf.id, parentID: container.id, parent: "10.1.0.0/16"},
+		"vrf parent":       {importID: "activision/172.16.1.0/24", id: underVrf.id, parentID: f.parentPrefixWithVrfID, parent: "172.16.0.0/12"},
+		"id and parent":    {importID: fmt.Sprintf("%d/%d", global.id, f.parentPrefixID), id: global.id, parentID: f.parentPrefixID, parent: "10.0.0.0/12"},
+		"outside parent":   {importID: fmt.Sprintf("%d/%d", global.id, f.parentPrefixWithVrfID), err: "isn't within the parent prefix"},
+		"vrf of parent":    {importID: fmt.Sprintf("%d/%d", underVrf.id, f.parentPrefixID), err: "isn't within the parent prefix"},
+		"unknown prefix":   {importID: "10.9.9.0/24", err: "Prefix 10.9.9.0/24 not found in the global table"},
+		"unknown vrf":      {importID: "gcp/10.1.0.0/24", err: "Unknow vrf gcp"},
+		"unknown format":   {importID: "gke", err: `Unexpected import ID "gke"`},
+		"unknown id":       {importID: "9999", err: "Cannot determine prefix with ID 9999"},
+		"no parent prefix": {importID: fmt.Sprint(f.parentPrefixID), err: "has no parent prefix"},
+	}
+	for tn, tc := range cases {
+		d := resourceIpamAvailablePrefixes().Data(nil)
+		d.SetId(tc.importID)
+		imported, err := resourceIpamAvailablePrefixesImportState(context.Background(), d, config)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tn, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tn, err)
+			continue
+		}
+
+		d = imported[0]
+		if diags := resourceIpamAvailablePrefixesRead(context.Background(), d, config); diags.HasError() {
+			t.Errorf("%s: unexpected error %v", tn, diags)
+			continue
+		}
+		if d.Id() != fmt.Sprint(tc.id) || d.Get("prefix_length").(int) != 24 {
+			t.Errorf("%s: expected prefix %d, a /24, got %s, a /%d", tn, tc.id, d.Id(), d.Get("prefix_length").(int))
+		}
+		if d.Get("parent_prefix_id").(int) != int(tc.parentID) || d.Get("parent_prefix").(string) != tc.parent {
+			t.Errorf("%s: expected parent %s with ID %d, got %s with ID %d", tn, tc.parent, tc.parentID, d.Get("parent_prefix").(string), d.Get("parent_prefix_id").(int))
+		}
+	}
+}
+
+func TestResourceIpamAvailablePrefixesUpdate(t *testing.T) {
+	cases := map[string]struct {
+		raw       map[string]interface{}
+		workspace string
+		err       error
+		expected  func(data *models.WritablePrefix) bool
+	}{
+		"description": {
+			raw: map[string]interface{}{"parent_prefix_id": 10, "prefix": "10.0.1.0/24", "description": "uplinks"},
+			expected: func(data *models.WritablePrefix) bool {
+				return data.Description == "uplinks" && *data.Prefix == "10.0.1.0/24"
+			},
+		},
+		"tags keep the ownership tag": {
+			raw:       map[string]interface{}{"parent_prefix_id": 10, "prefix": "10.0.1.0/24", "tags": []interface{}{"gke"}},
+			workspace: "prod",
+			expected: func(data *models.WritablePrefix) bool {
+				return len(data.Tags) == 2 && data.Tags[0] == "gke" && data.Tags[1] == ownershipTag("prod")
+			},
+		},
+		"tags without workspace": {
+			raw: map[string]interface{}{"parent_prefix_id": 10, "prefix": "10.0.1.0/24", "tags": []interface{}{"gke"}},
+			expected: func(data *models.WritablePrefix) bool {
+				return len(data.Tags) == 1 && data.Tags[0] == "gke"
+			},
+		},
+		"tags by slug": {
+			raw: map[string]interface{}{"parent_prefix_id": 10, "prefix": "10.0.1.0/24", "tags": []interface{}{"uplinks"}},
+			expected: func(data *models.WritablePrefix) bool {
+				return len(data.Tags) == 1 && data.Tags[0] == "Uplinks"
+			},
+		},
+		"unknown tag": {
+			raw: map[string]interface{}{"parent_prefix_id": 10, "prefix": "10.0.1.0/24", "tags": []interface{}{"gcp"}},
+		},
+		"invalid status": {
+			raw: map[string]interface{}{"parent_prefix_id": 10, "prefix": "10.0.1.0/24", "status": "retired"},
+		},
+		"rejected": {
+			raw: map[string]interface{}{"parent_prefix_id": 10, "prefix": "10.0.1.0/24", "description": "uplinks"},
+			err: mockAPIError("ipam_prefixes_partial_update", http.StatusBadRequest),
+		},
+	}
+
+	for tn, tc := range cases {
+		m := &mockNetbox{}
+		m.mockPrefixes(mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(20, "10.0.1.0/24", nil))
+		m.mockTags("gke", "Uplinks", ownershipTag("prod"))
+		var updated *models.WritablePrefix
+		m.prefixesPartialUpdate = func(params *ipam.IpamPrefixesPartialUpdateParams) (*ipam.IpamPrefixesPartialUpdateOK, error) {
+			updated = params.Data
+			return &ipam.IpamPrefixesPartialUpdateOK{}, tc.err
+		}
+
+		config := m.config()
+		config.Workspace = tc.workspace
+		// The tags are looked up from NetBox 2.9
+		config.apiVersion = netboxNestedTagsVersion
+		d := testAvailablePrefixesData(t, "20", tc.raw)
+		diags := resourceIpamAvailablePrefixesUpdate(context.Background(), d, config)
+		if tc.expected == nil {
+			if !diags.HasError() {
+				t.Fatalf("%s: expected an error", tn)
+			}
+			continue
+		}
+		if diags.HasError() {
+			t.Fatalf("%s: unexpected error %v", tn, diags)
+		}
+		if updated == nil || !tc.expected(updated) {
+			t.Fatalf("%s: unexpected update %+v", tn, updated)
+		}
+	}
+}
+
+func TestResourceIpamAvailablePrefixesDelete(t *testing.T) {
+	cases := map[string]struct {
+		id  string
+		err error
+	}{
+		"deleted":         {id: "20"},
+		"already deleted": {id: "20", err: mockAPIError("ipam_prefixes_delete", http.StatusNotFound)},
+		"rejected":        {id: "20", err: mockAPIError("ipam_prefixes_delete", http.StatusConflict)},
+		"malformed":       {id: "gke"},
+	}
+
+	for tn, tc := range cases {
+		m := &mockNetbox{}
+		var deleted int64
+		m.prefixesDelete = func(params *ipam.IpamPrefixesDeleteParams) (*ipam.IpamPrefixesDeleteNoContent, error) {
+			deleted = params.ID
+			return &ipam.IpamPrefixesDeleteNoContent{}, tc.err
+		}
+
+		d := testAvailablePrefixesData(t, tc.id, map[string]interface{}{"parent_prefix_id": 10})
+		diags := resourceIpamAvailablePrefixesDelete(context.Background(), d, m.config())
+		if _, err := strconv.Atoi(tc.id); (tc.err != nil && !isNotFound(tc.err)) || err != nil {
+			if !diags.HasError() || d.Id() != tc.id {
+				t.Fatalf("%s: expected an error keeping the ID, got %v with ID %q", tn, diags, d.Id())
+			}
+			continue
+		}
+		if diags.HasError() || d.Id() != "" || deleted != 20 {
+			t.Fatalf("%s: expected prefix 20 deleted, got %v, deleted %d, ID %q", tn, diags, deleted, d.Id())
+		}
+	}
+}
+
+func TestResourceIpamAvailablePrefixesImportState(t *testing.T) {
+	m := &mockNetbox{}
+	m.mockPrefixes(mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(20, "10.0.1.0/24", nil))
+
+	d := resourceIpamAvailablePrefixes().Data(nil)
+	d.SetId("20")
+	imported, err := resourceIpamAvailablePrefixesImportState(context.Background(), d, m.config())
+	if err != nil || len(imported) != 1 {
+		t.Fatalf("unexpected import %v, %v", imported, err)
+	}
+	if diags := resourceIpamAvailablePrefixesRead(context.Background(), imported[0], m.config()); diags.HasError() {
+		t.Fatalf("unexpected error %v", diags)
+	}
+	if prefix := imported[0].Get("prefix").(string); prefix != "10.0.1.0/24" || imported[0].Id() != "20" {
+		t.Fatalf("expected 10.0.1.0/24 with ID 20, got %s with ID %s", prefix, imported[0].Id())
+	}
+}
+
+// The subnet index is told from the prefix and the parent it's imported under
+func TestResourceIpamAvailablePrefixesImportSubnetIndex(t *testing.T) {
+	cases := map[string]struct {
+		prefix   string
+		expected int
+	}{
+		"first":  {prefix: "10.0.0.0/24", expected: 0},
+		"third":  {prefix: "10.0.3.0/24", expected: 3},
+		"longer": {prefix: "10.0.1.32/28", expected: 18},
+		"last":   {prefix: "10.0.255.254/31", expected: 32767},
+	}
+	for tn, tc := range cases {
+		m := &mockNetbox{}
+		m.mockPrefixes(mockPrefix(10, "10.0.0.0/16", nil), mockPrefix(20, tc.prefix, nil))
+
+		d := resourceIpamAvailablePrefixes().Data(nil)
+		d.SetId("20/10")
+		imported, err := resourceIpamAvailablePrefixesImportState(context.Background(), d, m.config())
+		if err != nil || len(imported) != 1 {
+			t.Fatalf("%s: unexpected import %v, %v", tn, imported, err)
+		}
+		attributes := imported[0].State().Attributes
+		if attributes["parent_prefix_id"] != "10" || attributes["subnet_index"] != strconv.Itoa(tc.expected) {
+			t.Errorf("%s: expected the subnet %d of the parent with ID 10, got %q of %q", tn, tc.expected, attributes["subnet_index"], attributes["parent_prefix_id"])
+		}
+	}
+}
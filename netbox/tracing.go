@@ -0,0 +1,62 @@
+package netbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// NetboxRequestIDHeader carries the correlation ID of a request, NetBox logs it when its web server is told to
+const NetboxRequestIDHeader = "X-Request-ID"
+
+// netboxTracingTransport stamps every request with a correlation ID, <run>-<sequence>, and records
+// its method, URL, status and latency at INFO level, so the calls of a run can be matched to the
+// logs of NetBox without the TRACE level dumps.
+type netboxTracingTransport struct {
+	next  http.RoundTripper
+	ctx   context.Context
+	runID string
+	seq   uint64
+}
+
+func newNetboxTracingTransport(ctx context.Context, config *Config, next http.RoundTripper) (*netboxTracingTransport, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("Cannot generate the run ID of the request tracing: %w", err)
+	}
+	return &netboxTracingTransport{
+		next:  next,
+		ctx:   newLogSubsystem(ctx, config, logSubsystemHTTP),
+		runID: hex.EncodeToString(b),
+	}, nil
+}
+
+func (t *netboxTracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestID := fmt.Sprintf("%s-%d", t.runID, atomic.AddUint64(&t.seq, 1))
+	// A round tripper must not modify the request it's given
+	req = req.Clone(req.Context())
+	req.Header.Set(NetboxRequestIDHeader, requestID)
+
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	fields := map[string]interface{}{
+		"request_id": requestID,
+		"method":     req.Method,
+		"url":        req.URL.String(),
+		"latency_ms": time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+		tflog.SubsystemInfo(t.ctx, logSubsystemHTTP, "NetBox request failed", fields)
+		return res, err
+	}
+	fields["status"] = res.StatusCode
+	tflog.SubsystemInfo(t.ctx, logSubsystemHTTP, "NetBox request", fields)
+	return res, nil
+}
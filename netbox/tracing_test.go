@@ -0,0 +1,133 @@
+package netbox
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-log/tflogtest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestNetboxTracingTransport(t *testing.T) {
+	var requestIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestIDs = append(requestIDs, r.Header.Get(NetboxRequestIDHeader))
+		if r.URL.Path != "/api/" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	var output bytes.Buffer
+	ctx := tflogtest.RootLogger(context.Background(), &output)
+	transport, err := newNetboxTracingTransport(ctx, &Config{}, http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	client := &http.Client{Transport: transport}
+
+	for _, path := range []string{"/api/", "/api/ipam/prefixes/9999/"} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		res.Body.Close()
+		if req.Header.Get(NetboxRequestIDHeader) != "" {
+			t.Errorf("the request of the caller was modified")
+		}
+	}
+
+	expectedIDs := []string{transport.runID + "-1", transport.runID + "-2"}
+	if strings.Join(requestIDs, ",") != strings.Join(expectedIDs, ",") {
+		t.Errorf("request IDs: expected %v, got %v", expectedIDs, requestIDs)
+	}
+
+	entries, err := tflogtest.MultilineJSONDecode(&output)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	var traced []map[string]interface{}
+	for _, entry := range entries {
+		if entry["@message"] == "NetBox request" && entry["@level"] == "info" {
+			traced = append(traced, entry)
+		}
+	}
+	if len(traced) != 2 {
+		t.Fatalf("expected 2 traced requests, got %d:\n%v", len(traced), entries)
+	}
+	for i, status := range []float64{200, 404} {
+		entry := traced[i]
+		if entry["request_id"] != expectedIDs[i] {
+			t.Errorf("request_id: expected %s, got %v", expectedIDs[i], entry["request_id"])
+		}
+		if entry["method"] != http.MethodGet {
+			t.Errorf("method: expected GET, got %v", entry["method"])
+		}
+		if !strings.HasPrefix(entry["url"].(string), server.URL) {
+			t.Errorf("url: expected %s..., got %v", server.URL, entry["url"])
+		}
+		if entry["status"] != status {
+			t.Errorf("status: expected %v, got %v", status, entry["status"])
+		}
+		if _, ok := entry["latency_ms"]; !ok {
+			t.Errorf("latency_ms is missing")
+		}
+	}
+}
+
+func TestProviderTraceRequests(t *testing.T) {
+	fake := newFakeNetbox()
+	defer fake.Close()
+
+	cases := map[string]struct {
+		env      string
+		raw      map[string]interface{}
+		expected bool
+	}{
+		"default": {
+			expected: false,
+		},
+		"argument": {
+			raw:      map[string]interface{}{"trace_requests": true},
+			expected: true,
+		},
+		"env": {
+			env:      "true",
+			expected: true,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if c.env != "" {
+				os.Setenv("NETBOX_TRACE_REQUESTS", c.env)
+				defer os.Unsetenv("NETBOX_TRACE_REQUESTS")
+			}
+			raw := map[string]interface{}{
+				"host":      fake.host(),
+				"api_token": fakeNetboxToken,
+				"base_path": "/api",
+			}
+			for k, v := range c.raw {
+				raw[k] = v
+			}
+			p := Provider()
+			d := schema.TestResourceDataRaw(t, p.Schema, raw)
+			m, diags := providerConfigure(context.Background(), d, p, "")
+			if diags.HasError() {
+				t.Fatalf("error: %v", diags)
+			}
+			if traced := m.(*Config).TraceRequests; traced != c.expected {
+				t.Errorf("expected %t, got %t", c.expected, traced)
+			}
+		})
+	}
+}
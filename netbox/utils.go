@@ -1,10 +1,12 @@
 package netbox
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"strings"
 	"sync"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 )
 
 // This is a Printf sibling (Nprintf; Named Printf), which handles strings like
@@ -32,17 +34,19 @@ type MutexKV struct {
 
 // Locks the mutex for the given key. Caller is responsible for calling Unlock
 // for the same key
-func (m *MutexKV) Lock(key string) {
-	log.Printf("[DEBUG] Locking %q", key)
+func (m *MutexKV) Lock(ctx context.Context, key string) {
+	ctx = newLogSubsystem(ctx, nil, logSubsystemLock)
+	tflog.SubsystemDebug(ctx, logSubsystemLock, "Locking mutex", map[string]interface{}{"mutex": key})
 	m.get(key).Lock()
-	log.Printf("[DEBUG] Locked %q", key)
+	tflog.SubsystemDebug(ctx, logSubsystemLock, "Locked mutex", map[string]interface{}{"mutex": key})
 }
 
 // Unlock the mutex for the given key. Caller must have called Lock for the same key first
-func (m *MutexKV) Unlock(key string) {
-	log.Printf("[DEBUG] Unlocking %q", key)
+func (m *MutexKV) Unlock(ctx context.Context, key string) {
+	ctx = newLogSubsystem(ctx, nil, logSubsystemLock)
+	tflog.SubsystemDebug(ctx, logSubsystemLock, "Unlocking mutex", map[string]interface{}{"mutex": key})
 	m.get(key).Unlock()
-	log.Printf("[DEBUG] Unlocked %q", key)
+	tflog.SubsystemDebug(ctx, logSubsystemLock, "Unlocked mutex", map[string]interface{}{"mutex": key})
 }
 
 // Returns a mutex for the given key, no guarantee of its lock status